@@ -2,62 +2,159 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
-
+	"avito-tech/internal/cleanup"
+	"avito-tech/internal/config"
 	"avito-tech/internal/handler"
+	"avito-tech/internal/metrics"
+	"avito-tech/internal/models"
 	"avito-tech/internal/repository"
+	"avito-tech/internal/scheduler"
+	"avito-tech/migrations"
+	"avito-tech/pkg/apikey"
+	"avito-tech/pkg/exchange"
 	"avito-tech/pkg/logger"
+	"avito-tech/pkg/migrate"
 	"avito-tech/pkg/repositories/postgres"
 
 	"avito-tech/pkg/server"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	config, err := config.Load(".env")
 	if err != nil {
-		log.Fatalf("Error loading .env file. %s", err.Error())
+		log.Fatalf("Error while loading config. %s", err.Error())
 	}
 
-	logger := logger.GetLogger()
+	logger, err := logger.New(logger.Config{Level: config.Log.Level, Format: config.Log.Format})
+	if err != nil {
+		log.Fatalf("Error while initialisation logger. %s", err.Error())
+	}
 
 	db, err := postgres.NewPostgresDB(&postgres.PostgresDB{
-		Host:     os.Getenv("POSTGRES_HOST"),
-		Port:     os.Getenv("POSTGRES_PORT"),
-		Username: os.Getenv("POSTGRES_USER"),
-		Password: os.Getenv("POSTGRES_PASSWORD"),
-		DBName:   os.Getenv("POSTGRES_DB"),
-		SSLMode:  os.Getenv("POSTGRES_SSLMODE"),
-		Logger:   logger,
+		Host:            config.Database.Host,
+		Port:            config.Database.Port,
+		Username:        config.Database.User,
+		Password:        config.Database.Password,
+		DBName:          config.Database.Name,
+		SSLMode:         config.Database.SSLMode,
+		MaxOpenConns:    config.Database.MaxOpenConns,
+		MaxIdleConns:    config.Database.MaxIdleConns,
+		ConnMaxLifetime: config.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: config.Database.ConnMaxIdleTime,
+		Logger:          logger,
 	})
 	if err != nil {
 		logger.Panicf("Error while initialisation database:%s", err)
 	}
-	repository := repository.New(db, logger)
-	handler := handler.NewHandler(logger, repository)
 
-	server := server.NewServer(logger, *handler, os.Getenv("SERVER_HOST"), os.Getenv("SERVER_PORT"))
+	migrator, err := migrate.New(db, logger, migrations.FS)
+	if err != nil {
+		logger.Panicf("Error while loading migrations:%s", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(migrator, os.Args[2:])
+		db.Close()
+		if err != nil {
+			logger.Fatalf("Error while migrating:%s", err)
+		}
+		return
+	}
+	if config.Features.MigrateOnStart {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			logger.Panicf("Error while migrating:%s", err)
+		}
+		logger.Infof("applied %d migrations", applied)
+	}
+
+	metrics := metrics.New(db)
+	repository := metrics.Instrument(repository.New(db, logger, config.Features.AutoCreateAccounts))
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		err = runClient(repository.APIClients, config.Auth.SigningKey, os.Args[2:])
+		db.Close()
+		if err != nil {
+			logger.Fatalf("Error while managing api clients:%s", err)
+		}
+		return
+	}
+
+	rates, err := newRateProvider(metrics, config.Rates)
+	if err != nil {
+		logger.Panicf("Error while initialisation exchange rates:%s", err)
+	}
+	readiness := handler.Readiness{DB: db, Migrations: migrator, Timeout: config.Server.ReadinessTimeout}
+	if config.Features.ReadyzCheckRates {
+		readiness.Rates = rates
+	}
+	handler := handler.NewHandler(logger, repository, rates, metrics, readiness, handler.Config{
+		Server:      config.Server,
+		Idempotency: config.Idempotency,
+		Reports:     config.Reports,
+		Auth:        config.Auth,
+	})
+
+	server := server.NewServer(logger, *handler, config.Server)
+
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerStopped := make(chan struct{})
+	if config.Scheduler.Enabled {
+		go func() {
+			scheduler.New(logger, repository.ScheduledTransfers, config.Scheduler).Run(schedulerCtx)
+			close(schedulerStopped)
+		}()
+	} else {
+		close(schedulerStopped)
+	}
+
+	cleanerCtx, stopCleaner := context.WithCancel(context.Background())
+	cleanerStopped := make(chan struct{})
+	go func() {
+		cleanup.New(logger, config.Cleanup.Interval,
+			cleanup.Task{Name: "api nonces", Run: repository.APIClients.DeleteExpiredNonces},
+			cleanup.Task{Name: "idempotency keys", Run: repository.Idempotency.DeleteExpired},
+		).Run(cleanerCtx)
+		close(cleanerStopped)
+	}()
 
 	idleConnsClosed := make(chan struct{})
 	go func() {
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		<-c
-		err = server.Shutdown(context.Background())
+		logger.Info("shutting down")
+
+		// Fail readiness first and keep serving while load balancers notice,
+		// so no request is sent to a server that has stopped accepting them.
+		// The scheduler starts no new runs from now on.
+		handler.Drain()
+		stopScheduler()
+		stopCleaner()
+		time.Sleep(config.Server.ShutdownDrainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
 		if err != nil {
 			logger.Errorf("Error occured on server shutting down: %s", err.Error())
 		}
+		// the run in progress, if any, still needs the database.
+		<-schedulerStopped
+		<-cleanerStopped
 		err = db.Close()
 		if err != nil {
 			logger.Errorf("Error occured on db connection close: %s", err.Error())
 		}
-
-		logger.Info("shutting down")
-		os.Exit(0)
+		close(idleConnsClosed)
 	}()
 
 	if err := server.Start(); err != http.ErrServerClosed {
@@ -65,3 +162,109 @@ func main() {
 	}
 	<-idleConnsClosed
 }
+
+// newRateProvider uses the apilayer API when an API key is configured and the
+// rates file when one is configured, preferring the API and falling back to
+// the file while the API is down.
+func newRateProvider(metrics *metrics.Metrics, config config.Rates) (exchange.RateProvider, error) {
+	var providers []exchange.RateProvider
+
+	if config.APIKey != "" {
+		api := exchange.NewAPILayerProvider(exchange.APILayerURL, config.APIKey, config.Timeout)
+		providers = append(providers, exchange.NewCachingProvider(metrics.InstrumentRates(api), config.CacheTTL))
+	}
+
+	if config.File != "" {
+		file, err := exchange.NewFileProvider(config.File)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, file)
+	}
+
+	if len(providers) == 0 {
+		return exchange.NewStaticProvider(nil), nil
+	}
+	return exchange.NewFallbackProvider(providers...), nil
+}
+
+// runMigrate implements `migrate up|down|status`.
+func runMigrate(migrator *migrate.Migrator, args []string) error {
+	ctx := context.Background()
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+	}
+
+	return nil
+}
+
+// runClient implements `client create <name> <scope,...>`, which prints the
+// new key, and the secret to sign requests with when signing is enabled,
+// once, and `client revoke <key id>`.
+func runClient(clients repository.APIClients, signingKey string, args []string) error {
+	ctx := context.Background()
+	usage := fmt.Errorf("usage: client create <name> <%s,...> | client revoke <key id>", strings.Join(models.Scopes, "|"))
+
+	switch {
+	case len(args) == 3 && args[0] == "create":
+		key, keyID, secret, err := apikey.Generate()
+		if err != nil {
+			return err
+		}
+		client := &models.APIClient{
+			KeyID:     keyID,
+			Name:      args[1],
+			KeyHash:   apikey.Hash(secret),
+			Scopes:    strings.Split(args[2], ","),
+			CreatedAt: time.Now().UTC(),
+		}
+		if err = client.Validate(); err != nil {
+			return err
+		}
+		if err = clients.CreateClient(ctx, client); err != nil {
+			return err
+		}
+		fmt.Printf("created client %s, key id %s\nkey (shown only once): %s\n", client.Name, keyID, key)
+		if signingKey != "" {
+			fmt.Printf("signing secret (shown only once): %s\n", apikey.SigningSecret([]byte(signingKey), keyID))
+		}
+	case len(args) == 2 && args[0] == "revoke":
+		if err := clients.RevokeClient(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s\n", args[1])
+	default:
+		return usage
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package cleanup deletes expired rows in the background.
+package cleanup
+
+import (
+	"avito-tech/pkg/logger"
+	"context"
+	"time"
+)
+
+// Task deletes the rows of one kind that expired before now and returns how
+// many it deleted.
+type Task struct {
+	Name string
+	Run  func(ctx context.Context, now time.Time) (deleted int64, err error)
+}
+
+type Cleaner struct {
+	logger   logger.Logger
+	interval time.Duration
+	tasks    []Task
+	now      func() time.Time
+}
+
+func New(logger logger.Logger, interval time.Duration, tasks ...Task) *Cleaner {
+	return &Cleaner{
+		logger:   logger,
+		interval: interval,
+		tasks:    tasks,
+		now:      time.Now,
+	}
+}
+
+// Run runs every task right away and then every interval, until ctx is
+// cancelled.
+func (c *Cleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every task once. A failing task does not keep the others from
+// running; it is run again on the next tick.
+func (c *Cleaner) RunOnce(ctx context.Context) {
+	for _, task := range c.tasks {
+		if ctx.Err() != nil {
+			return
+		}
+
+		deleted, err := task.Run(ctx, c.now())
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Errorf("error occurred while deleting expired %s. err: %s", task.Name, err)
+			}
+			continue
+		}
+		if deleted > 0 {
+			c.logger.Debugf("deleted %d expired %s", deleted, task.Name)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package cleanup_test
+
+import (
+	"avito-tech/internal/cleanup"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var log = logger.GetLogger()
+
+func Test_RunOnce(t *testing.T) {
+	var ran []string
+	task := func(name string, err error) cleanup.Task {
+		return cleanup.Task{Name: name, Run: func(ctx context.Context, now time.Time) (int64, error) {
+			ran = append(ran, name)
+			return 1, err
+		}}
+	}
+
+	c := cleanup.New(log, time.Hour, task("nonces", errors.New("connection refused")), task("idempotency keys", nil))
+	c.RunOnce(context.Background())
+	assert.Equal(t, []string{"nonces", "idempotency keys"}, ran)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran = nil
+	c.RunOnce(ctx)
+	assert.Empty(t, ran)
+}
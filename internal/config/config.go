@@ -0,0 +1,343 @@
+package config
+
+import (
+	"avito-tech/pkg/logger"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable holding the path of the optional
+// YAML config file.
+const FileEnv = "CONFIG_FILE"
+
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Database    Database    `yaml:"database"`
+	Log         Log         `yaml:"log"`
+	Rates       Rates       `yaml:"rates"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Reports     Reports     `yaml:"reports"`
+	Scheduler   Scheduler   `yaml:"scheduler"`
+	Auth        Auth        `yaml:"auth"`
+	Cleanup     Cleanup     `yaml:"cleanup"`
+	Features    Features    `yaml:"features"`
+}
+
+type Server struct {
+	Host               string        `yaml:"host" env:"SERVER_HOST"`
+	Port               string        `yaml:"port" env:"SERVER_PORT"`
+	ReadTimeout        time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout       time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	RequestTimeout     time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	ShutdownDrainDelay time.Duration `yaml:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	ReadinessTimeout   time.Duration `yaml:"readiness_timeout" env:"READINESS_TIMEOUT"`
+}
+
+type Database struct {
+	Host            string        `yaml:"host" env:"POSTGRES_HOST"`
+	Port            string        `yaml:"port" env:"POSTGRES_PORT"`
+	User            string        `yaml:"user" env:"POSTGRES_USER"`
+	Password        string        `yaml:"password" env:"POSTGRES_PASSWORD"`
+	Name            string        `yaml:"name" env:"POSTGRES_DB"`
+	SSLMode         string        `yaml:"sslmode" env:"POSTGRES_SSLMODE"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+// Rates configures the exchange-rate providers: the apilayer API when APIKey
+// is set and the rates file when File is set.
+type Rates struct {
+	APIKey   string        `yaml:"api_key" env:"CURRENCY_API_KEY"`
+	Timeout  time.Duration `yaml:"timeout" env:"RATES_TIMEOUT"`
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"RATES_CACHE_TTL"`
+	File     string        `yaml:"file" env:"RATES_FILE"`
+}
+
+type Idempotency struct {
+	KeyTTL time.Duration `yaml:"key_ttl" env:"IDEMPOTENCY_KEY_TTL"`
+}
+
+// Reports configures revenue reports: they are written to Dir, and the links
+// to them start with BaseURL, the address clients reach the service at.
+type Reports struct {
+	Dir     string `yaml:"dir" env:"REPORTS_DIR"`
+	BaseURL string `yaml:"base_url" env:"REPORTS_BASE_URL"`
+}
+
+// Scheduler configures the runs of scheduled transfers: every Interval up to
+// BatchSize due schedules are run, each within RunTimeout. Runs that failed
+// for insufficient funds are retried every RetryInterval, at most MaxAttempts
+// times.
+type Scheduler struct {
+	Enabled       bool          `yaml:"enabled" env:"SCHEDULER_ENABLED"`
+	Interval      time.Duration `yaml:"interval" env:"SCHEDULER_INTERVAL"`
+	BatchSize     int           `yaml:"batch_size" env:"SCHEDULER_BATCH_SIZE"`
+	RunTimeout    time.Duration `yaml:"run_timeout" env:"SCHEDULER_RUN_TIMEOUT"`
+	RetryInterval time.Duration `yaml:"retry_interval" env:"SCHEDULER_RETRY_INTERVAL"`
+	MaxAttempts   int           `yaml:"max_attempts" env:"SCHEDULER_MAX_ATTEMPTS"`
+}
+
+// Auth configures request authentication. SigningKey is the server secret
+// the signing secrets of clients are derived from; signed requests are refused
+// while it is empty.
+type Auth struct {
+	SigningKey string `yaml:"signing_key" env:"AUTH_SIGNING_KEY"`
+}
+
+// Cleanup configures the deletion of expired rows, run every Interval.
+type Cleanup struct {
+	Interval time.Duration `yaml:"interval" env:"CLEANUP_INTERVAL"`
+}
+
+type Features struct {
+	MigrateOnStart     bool `yaml:"migrate_on_start" env:"MIGRATE_ON_START"`
+	AutoCreateAccounts bool `yaml:"auto_create_accounts" env:"AUTO_CREATE_ACCOUNTS"`
+	ReadyzCheckRates   bool `yaml:"readyz_check_rates" env:"READYZ_CHECK_RATES"`
+}
+
+// Default is the configuration values not set anywhere fall back to.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:               "8080",
+			ReadTimeout:        10 * time.Second,
+			WriteTimeout:       10 * time.Second,
+			IdleTimeout:        time.Minute,
+			RequestTimeout:     8 * time.Second,
+			ShutdownTimeout:    15 * time.Second,
+			ShutdownDrainDelay: 5 * time.Second,
+			ReadinessTimeout:   2 * time.Second,
+		},
+		Database: Database{
+			Port:            "5432",
+			SSLMode:         "disable",
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Log: Log{
+			Level:  "debug",
+			Format: logger.FormatPretty,
+		},
+		Rates: Rates{
+			Timeout:  10 * time.Second,
+			CacheTTL: time.Hour,
+		},
+		Idempotency: Idempotency{KeyTTL: 24 * time.Hour},
+		Reports:     Reports{Dir: "reports", BaseURL: "http://localhost:8080"},
+		Scheduler: Scheduler{
+			Enabled:       true,
+			Interval:      30 * time.Second,
+			BatchSize:     100,
+			RunTimeout:    10 * time.Second,
+			RetryInterval: time.Hour,
+			MaxAttempts:   24,
+		},
+		Cleanup: Cleanup{Interval: 10 * time.Minute},
+	}
+}
+
+// Load builds the configuration from, in increasing priority, the defaults,
+// the YAML file named by CONFIG_FILE and environment variables. envFile is
+// loaded into the environment first, without overriding variables that are
+// already set; like the YAML file it is optional. Empty variables count as
+// unset.
+func Load(envFile string) (*Config, error) {
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading %s: %w", envFile, err)
+	}
+
+	config := Default()
+	if path := os.Getenv(FileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if err := loadEnv(reflect.ValueOf(config).Elem()); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// loadEnv sets every field of v tagged with env to the value of that
+// variable, when it is set.
+func loadEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := v.Type().Field(i).Tag.Get("env")
+		value := os.Getenv(key)
+		if key == "" || value == "" {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case string:
+		field.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+var (
+	port    = regexp.MustCompile(`^[0-9]{1,5}$`)
+	baseURL = regexp.MustCompile(`^https?://[^/?#\s]+(/[^?#\s]*)?$`)
+)
+
+func (c *Config) Validate() error {
+	return validation.Errors{
+		"server":      c.Server.Validate(),
+		"database":    c.Database.Validate(),
+		"log":         c.Log.Validate(),
+		"rates":       c.Rates.Validate(),
+		"idempotency": c.Idempotency.Validate(),
+		"reports":     c.Reports.Validate(),
+		"scheduler":   c.Scheduler.Validate(),
+		"auth":        c.Auth.Validate(),
+		"cleanup":     c.Cleanup.Validate(),
+	}.Filter()
+}
+
+func (s *Server) Validate() error {
+	return validation.ValidateStruct(
+		s,
+		validation.Field(&s.Port, validation.Required, validation.Match(port)),
+		validation.Field(&s.ReadTimeout, validation.Min(time.Duration(0))),
+		validation.Field(&s.WriteTimeout, validation.Min(time.Duration(0))),
+		validation.Field(&s.IdleTimeout, validation.Min(time.Duration(0))),
+		validation.Field(&s.RequestTimeout, validation.Min(time.Duration(0))),
+		validation.Field(&s.ShutdownTimeout, validation.Min(time.Duration(0))),
+		validation.Field(&s.ShutdownDrainDelay, validation.Min(time.Duration(0))),
+		validation.Field(&s.ReadinessTimeout, validation.Required, validation.Min(time.Duration(0))),
+	)
+}
+
+func (d *Database) Validate() error {
+	return validation.ValidateStruct(
+		d,
+		validation.Field(&d.Host, validation.Required),
+		validation.Field(&d.Port, validation.Required, validation.Match(port)),
+		validation.Field(&d.User, validation.Required),
+		validation.Field(&d.Name, validation.Required),
+		validation.Field(&d.SSLMode, validation.In("disable", "allow", "prefer", "require", "verify-ca", "verify-full")),
+		validation.Field(&d.MaxOpenConns, validation.Min(0)),
+		validation.Field(&d.MaxIdleConns, validation.Min(0)),
+		validation.Field(&d.ConnMaxLifetime, validation.Min(time.Duration(0))),
+		validation.Field(&d.ConnMaxIdleTime, validation.Min(time.Duration(0))),
+	)
+}
+
+func (l *Log) Validate() error {
+	return validation.ValidateStruct(
+		l,
+		validation.Field(&l.Level, validation.In("panic", "fatal", "error", "warn", "warning", "info", "debug", "trace")),
+		validation.Field(&l.Format, validation.In(logger.FormatPretty, logger.FormatJSON, logger.FormatText)),
+	)
+}
+
+func (r *Rates) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Timeout, validation.Required, validation.Min(time.Duration(0))),
+		validation.Field(&r.CacheTTL, validation.Min(time.Duration(0))),
+	)
+}
+
+func (i *Idempotency) Validate() error {
+	return validation.ValidateStruct(
+		i,
+		validation.Field(&i.KeyTTL, validation.Required, validation.Min(time.Duration(0))),
+	)
+}
+
+func (r *Reports) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Dir, validation.Required),
+		validation.Field(&r.BaseURL, validation.Required, validation.Match(baseURL)),
+	)
+}
+
+func (s *Scheduler) Validate() error {
+	return validation.ValidateStruct(
+		s,
+		validation.Field(&s.Interval, validation.Required, validation.Min(time.Duration(0))),
+		validation.Field(&s.BatchSize, validation.Required, validation.Min(1)),
+		validation.Field(&s.RunTimeout, validation.Required, validation.Min(time.Duration(0))),
+		validation.Field(&s.RetryInterval, validation.Required, validation.Min(time.Duration(0))),
+		validation.Field(&s.MaxAttempts, validation.Required, validation.Min(1)),
+	)
+}
+
+func (a *Auth) Validate() error {
+	return validation.ValidateStruct(
+		a,
+		validation.Field(&a.SigningKey, validation.Length(32, 0)),
+	)
+}
+
+func (c *Cleanup) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Interval, validation.Required, validation.Min(time.Duration(0))),
+	)
+}
@@ -0,0 +1,165 @@
+package config_test
+
+import (
+	"avito-tech/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setEnv sets the variables for the test only. A nil map entry unsets the
+// variable, so that a .env file may set it.
+func setEnv(t *testing.T, env map[string]*string) {
+	for key, value := range env {
+		t.Setenv(key, "")
+		if value == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *value)
+		}
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func Test_Load(t *testing.T) {
+	required := map[string]*string{
+		"POSTGRES_HOST": str("db"),
+		"POSTGRES_USER": str("avitotech"),
+		"POSTGRES_DB":   str("avitotech"),
+		"CONFIG_FILE":   nil,
+	}
+	noEnvFile := filepath.Join(t.TempDir(), ".env")
+
+	t.Run("defaults", func(t *testing.T) {
+		setEnv(t, required)
+
+		c, err := config.Load(noEnvFile)
+		assert.NoError(t, err)
+		expected := config.Default()
+		expected.Database.Host, expected.Database.User, expected.Database.Name = "db", "avitotech", "avitotech"
+		assert.Equal(t, expected, c)
+	})
+
+	t.Run("yaml overridden by env", func(t *testing.T) {
+		setEnv(t, required)
+		setEnv(t, map[string]*string{
+			"CONFIG_FILE": str(writeFile(t, "config.yaml", `
+server:
+  port: "9090"
+  request_timeout: 3s
+database:
+  host: yaml-db
+  max_open_conns: 50
+features:
+  auto_create_accounts: true
+`)),
+			"POSTGRES_HOST":    nil,
+			"REQUEST_TIMEOUT":  str("4s"),
+			"MIGRATE_ON_START": str("true"),
+		})
+
+		c, err := config.Load(noEnvFile)
+		assert.NoError(t, err)
+		assert.Equal(t, "9090", c.Server.Port)
+		assert.Equal(t, 4*time.Second, c.Server.RequestTimeout)
+		assert.Equal(t, 10*time.Second, c.Server.ReadTimeout)
+		assert.Equal(t, "yaml-db", c.Database.Host)
+		assert.Equal(t, 50, c.Database.MaxOpenConns)
+		assert.True(t, c.Features.AutoCreateAccounts)
+		assert.True(t, c.Features.MigrateOnStart)
+	})
+
+	t.Run("env file does not override env", func(t *testing.T) {
+		setEnv(t, required)
+		setEnv(t, map[string]*string{
+			"SERVER_PORT": str("7070"),
+			"REPORTS_DIR": nil,
+			"RATES_FILE":  nil,
+			"LOG_FORMAT":  nil,
+			"POSTGRES_DB": nil,
+		})
+		envFile := writeFile(t, ".env", "SERVER_PORT=6060\nREPORTS_DIR=/tmp/reports\nRATES_FILE=\nLOG_FORMAT=json\nPOSTGRES_DB=fromfile\n")
+
+		c, err := config.Load(envFile)
+		assert.NoError(t, err)
+		assert.Equal(t, "7070", c.Server.Port)
+		assert.Equal(t, "/tmp/reports", c.Reports.Dir)
+		assert.Equal(t, "", c.Rates.File)
+		assert.Equal(t, "json", c.Log.Format)
+		assert.Equal(t, "fromfile", c.Database.Name)
+	})
+
+	errorCases := []struct {
+		name          string
+		env           map[string]*string
+		expectedError string
+	}{
+		{
+			name:          "missing database host",
+			env:           map[string]*string{"POSTGRES_HOST": nil},
+			expectedError: "invalid config: database: (Host: cannot be blank.).",
+		},
+		{
+			name:          "invalid duration",
+			env:           map[string]*string{"REQUEST_TIMEOUT": str("8")},
+			expectedError: "REQUEST_TIMEOUT: time: missing unit in duration \"8\"",
+		},
+		{
+			name:          "invalid bool",
+			env:           map[string]*string{"MIGRATE_ON_START": str("sometimes")},
+			expectedError: "MIGRATE_ON_START: strconv.ParseBool: parsing \"sometimes\": invalid syntax",
+		},
+		{
+			name:          "unknown log format",
+			env:           map[string]*string{"LOG_FORMAT": str("xml")},
+			expectedError: "invalid config: log: (Format: must be a valid value.).",
+		},
+		{
+			name:          "negative pool size",
+			env:           map[string]*string{"DB_MAX_OPEN_CONNS": str("-1")},
+			expectedError: "invalid config: database: (MaxOpenConns: must be no less than 0.).",
+		},
+		{
+			name:          "negative scheduler batch size",
+			env:           map[string]*string{"SCHEDULER_BATCH_SIZE": str("-1")},
+			expectedError: "invalid config: scheduler: (BatchSize: must be no less than 1.).",
+		},
+		{
+			name:          "short signing key",
+			env:           map[string]*string{"AUTH_SIGNING_KEY": str("secret")},
+			expectedError: "invalid config: auth: (SigningKey: the length must be no less than 32.).",
+		},
+		{
+			name:          "reports base url without scheme",
+			env:           map[string]*string{"REPORTS_BASE_URL": str("localhost:8080")},
+			expectedError: "invalid config: reports: (BaseURL: must be in a valid format.).",
+		},
+		{
+			name:          "missing yaml file",
+			env:           map[string]*string{"CONFIG_FILE": str("/nonexistent/config.yaml")},
+			expectedError: "open /nonexistent/config.yaml: no such file or directory",
+		},
+	}
+
+	for _, testCase := range errorCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			setEnv(t, required)
+			setEnv(t, testCase.env)
+
+			_, err := config.Load(noEnvFile)
+			assert.EqualError(t, err, testCase.expectedError)
+		})
+	}
+}
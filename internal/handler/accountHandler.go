@@ -3,14 +3,15 @@ package handler
 import (
 	"avito-tech/internal/models"
 	"avito-tech/internal/repository"
+	"avito-tech/pkg/exchange"
 	"avito-tech/pkg/logger"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
-	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -23,20 +24,26 @@ const (
 	transaction    = "/transaction"
 	changeBalance  = "/changeBalance"
 
-	apiLink = "http://api.apilayer.com/exchangerates_data/convert?to=%s&from=RUB&amount=%g"
-)
+	batchTransaction  = "/transactions/batch"
+	refundTransaction = "/transaction/{id:[0-9]+}/refund"
 
-var apiKey = os.Getenv("CURRENCY_API_KEY")
+	createAccount   = "/account"
+	freezeAccount   = "/account/{id:[0-9]+}/freeze"
+	unfreezeAccount = "/account/{id:[0-9]+}/unfreeze"
+	closeAccount    = "/account/{id:[0-9]+}/close"
+)
 
 type accountHandler struct {
 	logger  logger.Logger
 	accRepo repository.Account
+	rates   exchange.RateProvider
 }
 
-func NewAccountHandler(logger logger.Logger, accRepo repository.Account) *accountHandler {
+func NewAccountHandler(logger logger.Logger, accRepo repository.Account, rates exchange.RateProvider) *accountHandler {
 	return &accountHandler{
 		logger:  logger,
 		accRepo: accRepo,
+		rates:   rates,
 	}
 }
 
@@ -44,38 +51,39 @@ func (fh *accountHandler) Register(router *mux.Router) {
 	router.HandleFunc(getBalanceByID, fh.getBalanceByID).Methods("GET")
 	router.HandleFunc(getAllAccounts, fh.getAll).Methods("GET")
 	router.HandleFunc(transaction, fh.moneyTransaction).Methods("POST")
+	router.HandleFunc(batchTransaction, fh.batchTransaction).Methods("POST")
+	router.HandleFunc(refundTransaction, fh.refundTransaction).Methods("POST")
 	router.HandleFunc(changeBalance, fh.changeBalance).Methods("POST")
 	router.HandleFunc(currencyBalance, fh.currencyBalance).Methods("GET")
+	router.HandleFunc(createAccount, fh.createAccount).Methods("POST")
+	router.HandleFunc(freezeAccount, fh.freezeAccount).Methods("POST")
+	router.HandleFunc(unfreezeAccount, fh.unfreezeAccount).Methods("POST")
+	router.HandleFunc(closeAccount, fh.closeAccount).Methods("POST")
 }
 
 func (fh *accountHandler) getBalanceByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while getting id. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while  getting id. err:%s ", err))
+		writeError(w, r, fh.logger, "getting id", invalidParameter("id", "a number", err))
 		return
 	}
-	account, err := fh.accRepo.GetBalanceByID(id)
+	account, err := fh.accRepo.GetBalanceByID(r.Context(), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while getting account . err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting all account. err:%s ", err))
+		writeError(w, r, fh.logger, "getting account", err)
 		return
 	}
 
+	setETag(w, account)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(account)
 
 }
 
 func (fh *accountHandler) getAll(w http.ResponseWriter, r *http.Request) {
-	users, err := fh.accRepo.GetAll()
+	users, err := fh.accRepo.GetAll(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while getting all users . err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting all accounts. err:%s ", err))
+		writeError(w, r, fh.logger, "getting all accounts", err)
 		return
 	}
 
@@ -87,25 +95,38 @@ func (fh *accountHandler) moneyTransaction(w http.ResponseWriter, r *http.Reques
 	req := &models.Transaction{}
 	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while parsing json. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while parsing json. err:%s ", err))
+		writeError(w, r, fh.logger, "parsing json", invalidJSON(err))
 		return
 	}
 
 	err = req.Validate()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while validating data. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while validating data. err:%s ", err))
+		writeError(w, r, fh.logger, "validating data", validationFailed(err))
+		return
+	}
+	req.ExpectedVersion, err = ifMatch(r)
+	if err != nil {
+		writeError(w, r, fh.logger, "getting if-match", err)
 		return
 	}
 
-	err = fh.accRepo.MoneyTransaction(req)
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+	if req.ReceiverCurrency == "" {
+		req.ReceiverCurrency = req.Currency
+	}
+	if req.ReceiverCurrency != req.Currency {
+		req.Rate, err = fh.rate(r.Context(), req.Currency, req.ReceiverCurrency)
+		if err != nil {
+			writeError(w, r, fh.logger, "getting exchange rates", err)
+			return
+		}
+	}
+
+	err = fh.accRepo.MoneyTransaction(r.Context(), req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while money transaction between users. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while money transaction between users. err:%s ", err))
+		writeError(w, r, fh.logger, "money transaction between users", err)
 		return
 	}
 
@@ -113,29 +134,178 @@ func (fh *accountHandler) moneyTransaction(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode("Transaction was successful")
 }
 
+// batchTransaction applies a list of transactions in one database
+// transaction. When one fails and the batch is not best effort, nothing is
+// applied and the error is the one of the failed transaction, with code
+// batch_failed and the result of every transaction as details.
+func (fh *accountHandler) batchTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	req := &models.TransactionBatch{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, r, fh.logger, "parsing json", invalidJSON(err))
+		return
+	}
+
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, fh.logger, "validating data", validationFailed(err))
+		return
+	}
+
+	result := &models.TransactionBatchResult{Results: make([]models.BatchItemResult, len(req.Transactions))}
+	// the transactions passed on to the repository and their index in the batch.
+	items := make([]models.Transaction, 0, len(req.Transactions))
+	indices := make([]int, 0, len(req.Transactions))
+	for i := range req.Transactions {
+		tr := &req.Transactions[i]
+		if tr.Currency == "" {
+			tr.Currency = models.DefaultCurrency
+		}
+		if tr.ReceiverCurrency == "" {
+			tr.ReceiverCurrency = tr.Currency
+		}
+		if tr.ReceiverCurrency != tr.Currency {
+			tr.Rate, err = fh.rate(r.Context(), tr.Currency, tr.ReceiverCurrency)
+			if err != nil {
+				if !req.BestEffort {
+					fh.writeBatchFailure(w, r, result, i, err)
+					return
+				}
+				fh.failBatchItem(r, result, i, err)
+				continue
+			}
+		}
+		items = append(items, *tr)
+		indices = append(indices, i)
+	}
+
+	var errs []error
+	if len(items) > 0 {
+		errs, err = fh.accRepo.MoneyTransactions(r.Context(), items, req.BestEffort)
+	}
+	var batchErr *repository.BatchError
+	if errors.As(err, &batchErr) {
+		fh.writeBatchFailure(w, r, result, indices[batchErr.Index], batchErr.Err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, fh.logger, "applying transaction batch", err)
+		return
+	}
+
+	for j, itemErr := range errs {
+		if itemErr != nil {
+			fh.failBatchItem(r, result, indices[j], itemErr)
+			continue
+		}
+		result.Results[indices[j]] = models.BatchItemResult{Index: indices[j], Status: models.BatchItemApplied}
+		result.Applied++
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// refundTransaction returns money of a transfer to its sender. The body is
+// optional: without an amount what is left of the transfer is refunded.
+func (fh *accountHandler) refundTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, fh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+
+	req := &models.Refund{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, fh.logger, "parsing json", invalidJSON(err))
+		return
+	}
+	req.TransactionID = id
+
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, fh.logger, "validating data", validationFailed(err))
+		return
+	}
+
+	err = fh.accRepo.Refund(r.Context(), req)
+	if err != nil {
+		writeError(w, r, fh.logger, "refunding transaction", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(req)
+}
+
+// failBatchItem records that the transaction at index failed with err.
+func (fh *accountHandler) failBatchItem(r *http.Request, result *models.TransactionBatchResult, index int, err error) {
+	logger := fh.logger.WithContext(r.Context())
+	if toAPIError(err).status >= http.StatusInternalServerError {
+		logger.Errorf("error occurred while applying transaction %d of the batch. err:%s ", index, err)
+	} else {
+		logger.Warnf("error occurred while applying transaction %d of the batch. err:%s ", index, err)
+	}
+
+	result.Results[index] = failedBatchItem(index, err)
+	result.Failed++
+}
+
+// writeBatchFailure reports a batch rolled back because the transaction at
+// index failed with err, with the status err has on its own.
+func (fh *accountHandler) writeBatchFailure(w http.ResponseWriter, r *http.Request, result *models.TransactionBatchResult, index int, err error) {
+	for i := range result.Results {
+		result.Results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemRolledBack}
+	}
+	result.Results[index] = failedBatchItem(index, err)
+	result.Applied, result.Failed = 0, 1
+
+	writeError(w, r, fh.logger, fmt.Sprintf("applying transaction %d of the batch", index),
+		newAPIError(toAPIError(err).status, codeBatchFailed, "batch was rolled back", result, err))
+}
+
+func failedBatchItem(index int, err error) models.BatchItemResult {
+	apiErr := toAPIError(err)
+	return models.BatchItemResult{
+		Index:  index,
+		Status: models.BatchItemFailed,
+		Error: &models.ErrorResponse{
+			Code:    apiErr.code,
+			Message: apiErr.message,
+			Details: apiErr.details,
+		},
+	}
+}
+
 func (fh *accountHandler) changeBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	req := &models.AccountDebit{}
 	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while parsing json. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while parsing json. err:%s ", err))
+		writeError(w, r, fh.logger, "parsing json", invalidJSON(err))
 		return
 	}
 	err = req.Validate()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while validating data. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while validating data. err:%s ", err))
+		writeError(w, r, fh.logger, "validating data", validationFailed(err))
+		return
+	}
+	req.ExpectedVersion, err = ifMatch(r)
+	if err != nil {
+		writeError(w, r, fh.logger, "getting if-match", err)
 		return
 	}
 
-	err = fh.accRepo.ChangeBalance(req)
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+
+	err = fh.accRepo.ChangeBalance(r.Context(), req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while changing balance. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while changing balance. err:%s ", err))
+		writeError(w, r, fh.logger, "changing balance", err)
 		return
 	}
 
@@ -149,71 +319,111 @@ func (fh *accountHandler) currencyBalance(w http.ResponseWriter, r *http.Request
 
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while getting id. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting id. err:%s ", err))
+		writeError(w, r, fh.logger, "getting id", invalidParameter("id", "a number", err))
 		return
 	}
 
-	balance, err := fh.accRepo.GetBalanceByID(id)
+	currency, err := exchange.NormalizeCurrency(vars["currency"])
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while getting account. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting all account. err:%s ", err))
+		writeError(w, r, fh.logger, "getting exchange rates", err)
 		return
 	}
 
-	fh.convert(w, vars["currency"], balance.Balance)
-}
+	account, err := fh.accRepo.GetBalanceByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, fh.logger, "getting account", err)
+		return
+	}
 
-func (fh *accountHandler) convert(w http.ResponseWriter, currency string, balance float64) {
+	var total models.Money
+	for from, balance := range account.Balances {
+		rate, err := fh.rate(r.Context(), from, currency)
+		if err != nil {
+			writeError(w, r, fh.logger, "getting exchange rates", err)
+			return
+		}
+		total += balance.Convert(rate, currency)
+	}
 
-	url := fmt.Sprintf(apiLink, currency, balance)
+	setETag(w, account)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&models.ExchangeResponse{Result: total})
+}
 
-	request, err := http.NewRequest(http.MethodGet, url, nil)
+func (fh *accountHandler) createAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	req := &models.AccountCreateReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while making request.err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while  making request.err:%s ", err))
+		writeError(w, r, fh.logger, "parsing json", invalidJSON(err))
 		return
 	}
-	request.Header.Set("apikey", apiKey)
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, fh.logger, "validating data", validationFailed(err))
+		return
 	}
 
-	resp, err := client.Do(request)
+	err = fh.accRepo.Create(r.Context(), req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fh.logger.Errorf("error occurred while getting exchange rates.err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting exchange rates.err:%s ", err))
+		writeError(w, r, fh.logger, "creating account", err)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		w.WriteHeader(resp.StatusCode)
-		b, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fh.logger.Errorf("error occurred while getting exchange rates.err:%s ", err)
-			json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting exchange rates.err:%s ", err))
-			return
-		}
-		fh.logger.Errorf("error occurred while getting exchange rates.err:%s ", string(b))
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting exchange rates.err:%s ", string(b)))
+	fh.writeAccount(w, r, req.AccountID, http.StatusCreated)
+}
+
+func (fh *accountHandler) freezeAccount(w http.ResponseWriter, r *http.Request) {
+	fh.changeStatus(w, r, fh.accRepo.Freeze, "freezing account")
+}
+
+func (fh *accountHandler) unfreezeAccount(w http.ResponseWriter, r *http.Request) {
+	fh.changeStatus(w, r, fh.accRepo.Unfreeze, "unfreezing account")
+}
+
+func (fh *accountHandler) closeAccount(w http.ResponseWriter, r *http.Request) {
+	fh.changeStatus(w, r, fh.accRepo.Close, "closing account")
+}
+
+func (fh *accountHandler) changeStatus(w http.ResponseWriter, r *http.Request, action func(context.Context, int, int64) error, operation string) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, fh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, fh.logger, "getting if-match", err)
+		return
+	}
+
+	err = action(r.Context(), id, version)
+	if err != nil {
+		writeError(w, r, fh.logger, operation, err)
 		return
 	}
 
-	exchange := &models.ExchangeResponse{}
+	fh.writeAccount(w, r, id, http.StatusOK)
+}
 
-	err = json.NewDecoder(resp.Body).Decode(exchange)
+// writeAccount responds with the current state of the account.
+func (fh *accountHandler) writeAccount(w http.ResponseWriter, r *http.Request, id, status int) {
+	account, err := fh.accRepo.GetBalanceByID(r.Context(), id)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fh.logger.Errorf("error occurred while parsing json. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while parsing json. err:%s ", err))
+		writeError(w, r, fh.logger, "getting account", err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(exchange)
 
+	setETag(w, account)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(account)
+}
+
+// rate returns how many units of to one unit of from is worth.
+func (fh *accountHandler) rate(ctx context.Context, from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	return fh.rates.Rate(ctx, from, to)
 }
@@ -1,14 +1,20 @@
 package handler_test
 
 import (
+	"avito-tech/internal/config"
 	"avito-tech/internal/handler"
 	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
 	"avito-tech/internal/repository/mock_repository"
+	"avito-tech/pkg/exchange"
 	"avito-tech/pkg/logger"
 	"bytes"
+	"context"
 	"errors"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
@@ -17,6 +23,25 @@ import (
 
 var log = logger.GetLogger()
 
+var rates = exchange.NewStaticProvider(map[string]map[string]float64{
+	"RUB": {"USD": 0.016},
+})
+
+// handlerConfig returns the settings handlers are tested with. Reports are
+// written to a temporary directory.
+func handlerConfig(t *testing.T) handler.Config {
+	return handler.Config{
+		Idempotency: config.Idempotency{KeyTTL: time.Hour},
+		Reports:     config.Reports{Dir: t.TempDir(), BaseURL: "http://localhost:8080"},
+	}
+}
+
+type unavailableRates struct{}
+
+func (unavailableRates) Rate(ctx context.Context, from, to string) (float64, error) {
+	return 0, exchange.ErrRateUnavailable
+}
+
 func Test_MoneyTransaction(t *testing.T) {
 	type mockBehavior func(s *mock_repository.MockAccount, tr *models.Transaction)
 
@@ -36,17 +61,97 @@ func Test_MoneyTransaction(t *testing.T) {
 				"comment": "You paid for me in a restaurant"
 			}`,
 			tr: &models.Transaction{
-				ReceiverID: 1,
-				SenderID:   2,
-				Amount:     22.5,
-				Comment:    "You paid for me in a restaurant",
+				ReceiverID:       1,
+				SenderID:         2,
+				Amount:           225000,
+				Comment:          "You paid for me in a restaurant",
+				Currency:         "RUB",
+				ReceiverCurrency: "RUB",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
-				s.EXPECT().MoneyTransaction(tr).Return(nil)
+				s.EXPECT().MoneyTransaction(gomock.Any(), tr).Return(nil)
 			},
 			expectedStatusCode:  200,
 			expectedRequestBody: "\"Transaction was successful\"\n",
 		},
+		{
+			name: "sender cannot afford it",
+			inputBody: `{
+				"receiver_id": 1,
+				"sender_id": 2,
+				"amount": 22.5,
+				"comment": "You paid for me in a restaurant"
+			}`,
+			tr: &models.Transaction{
+				ReceiverID:       1,
+				SenderID:         2,
+				Amount:           225000,
+				Comment:          "You paid for me in a restaurant",
+				Currency:         "RUB",
+				ReceiverCurrency: "RUB",
+			},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
+				s.EXPECT().MoneyTransaction(gomock.Any(), tr).Return(&repository.InsufficientFundsError{AccountID: 2, Currency: "RUB", Balance: 0, Requested: 225000})
+			},
+			expectedStatusCode:  422,
+			expectedRequestBody: "{\"code\":\"insufficient_funds\",\"message\":\"insufficient funds\",\"details\":{\"account_id\":2,\"currency\":\"RUB\",\"balance\":0.00,\"requested\":22.50}}\n",
+		},
+		{
+			name: "converted to receiver currency",
+			inputBody: `{
+				"receiver_id": 1,
+				"sender_id": 2,
+				"amount": 1000,
+				"comment": "You paid for me in a restaurant",
+				"currency": "RUB",
+				"receiver_currency": "USD"
+			}`,
+			tr: &models.Transaction{
+				ReceiverID:       1,
+				SenderID:         2,
+				Amount:           10000000,
+				Comment:          "You paid for me in a restaurant",
+				Currency:         "RUB",
+				ReceiverCurrency: "USD",
+				Rate:             0.016,
+			},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
+				s.EXPECT().MoneyTransaction(gomock.Any(), tr).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Transaction was successful\"\n",
+		},
+		{
+			name: "no rate between currencies",
+			inputBody: `{
+				"receiver_id": 1,
+				"sender_id": 2,
+				"amount": 1000,
+				"comment": "You paid for me in a restaurant",
+				"currency": "USD",
+				"receiver_currency": "EUR"
+			}`,
+			tr: &models.Transaction{},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"unknown_currency\",\"message\":\"unknown currency\"}\n",
+		},
+		{
+			name: "invalid currency",
+			inputBody: `{
+				"receiver_id": 1,
+				"sender_id": 2,
+				"amount": 1000,
+				"comment": "You paid for me in a restaurant",
+				"currency": "rub"
+			}`,
+			tr: &models.Transaction{},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"currency\":\"must be an ISO 4217 currency code\"}}\n",
+		},
 		{
 			name: "Invalid JSON request",
 			inputBody: `{
@@ -60,7 +165,7 @@ func Test_MoneyTransaction(t *testing.T) {
 			},
 
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while parsing json. err:invalid character 'a' looking for beginning of object key string \"\n",
+			expectedRequestBody: "{\"code\":\"invalid_json\",\"message\":\"request body is not valid JSON\",\"details\":\"invalid character 'a' looking for beginning of object key string\"}\n",
 		},
 		{
 			name: "Invalid receiver_id",
@@ -73,14 +178,14 @@ func Test_MoneyTransaction(t *testing.T) {
 			tr: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   2,
-				Amount:     22.5,
+				Amount:     225000,
 				Comment:    "owe you",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
 
 			},
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while validating data. err:receiver_id: must be no less than 1. \"\n",
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"receiver_id\":\"must be no less than 1\"}}\n",
 		},
 		{
 			name: "receiver_id: cannot be blank",
@@ -92,14 +197,14 @@ func Test_MoneyTransaction(t *testing.T) {
 			tr: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   2,
-				Amount:     22.5,
+				Amount:     225000,
 				Comment:    "owe you",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.Transaction) {
 
 			},
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while validating data. err:receiver_id: cannot be blank. \"\n",
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"receiver_id\":\"cannot be blank\"}}\n",
 		},
 	}
 
@@ -111,7 +216,7 @@ func Test_MoneyTransaction(t *testing.T) {
 			rep := mock_repository.NewMockAccount(c)
 			testCase.mockBehavior(rep, testCase.tr)
 
-			handler := handler.NewAccountHandler(log, rep)
+			handler := handler.NewAccountHandler(log, rep, rates)
 			router := mux.NewRouter()
 			handler.Register(router)
 
@@ -124,6 +229,245 @@ func Test_MoneyTransaction(t *testing.T) {
 	}
 }
 
+func Test_batchTransaction(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockAccount)
+
+	salary := func(receiverID int) models.Transaction {
+		return models.Transaction{ReceiverID: receiverID, SenderID: 1, Amount: 1000000, Comment: "salary for March", Currency: "RUB", ReceiverCurrency: "RUB"}
+	}
+	inUSD := salary(3)
+	inUSD.ReceiverCurrency, inUSD.Rate = "USD", 0.016
+
+	testTable := []struct {
+		name, inputBody     string
+		mockBehavior        mockBehavior
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name: "ok",
+			inputBody: `{"transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 3, "sender_id": 1, "amount": 100, "comment": "salary for March", "receiver_currency": "USD"}
+			]}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransactions(gomock.Any(), []models.Transaction{salary(2), inUSD}, false).Return([]error{nil, nil}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"applied\":2,\"failed\":0,\"results\":[{\"index\":0,\"status\":\"applied\"},{\"index\":1,\"status\":\"applied\"}]}\n",
+		},
+		{
+			name: "rolled back",
+			inputBody: `{"transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 3, "sender_id": 1, "amount": 100, "comment": "salary for March"}
+			]}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransactions(gomock.Any(), []models.Transaction{salary(2), salary(3)}, false).
+					Return(nil, &repository.BatchError{Index: 1, Err: &repository.InsufficientFundsError{AccountID: 1, Currency: "RUB", Balance: 500000, Requested: 1000000}})
+			},
+			expectedStatusCode:  422,
+			expectedRequestBody: "{\"code\":\"batch_failed\",\"message\":\"batch was rolled back\",\"details\":{\"applied\":0,\"failed\":1,\"results\":[{\"index\":0,\"status\":\"rolled_back\"},{\"index\":1,\"status\":\"failed\",\"error\":{\"code\":\"insufficient_funds\",\"message\":\"insufficient funds\",\"details\":{\"account_id\":1,\"currency\":\"RUB\",\"balance\":50.00,\"requested\":100.00}}}]}}\n",
+		},
+		{
+			name: "rolled back on a rate",
+			inputBody: `{"transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 3, "sender_id": 1, "amount": 100, "comment": "salary for March", "receiver_currency": "EUR"}
+			]}`,
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"batch_failed\",\"message\":\"batch was rolled back\",\"details\":{\"applied\":0,\"failed\":1,\"results\":[{\"index\":0,\"status\":\"rolled_back\"},{\"index\":1,\"status\":\"failed\",\"error\":{\"code\":\"unknown_currency\",\"message\":\"unknown currency\"}}]}}\n",
+		},
+		{
+			name: "best effort",
+			inputBody: `{"best_effort": true, "transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 3, "sender_id": 1, "amount": 100, "comment": "salary for March", "receiver_currency": "EUR"},
+				{"receiver_id": 4, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 5, "sender_id": 1, "amount": 100, "comment": "salary for March"}
+			]}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransactions(gomock.Any(), []models.Transaction{salary(2), salary(4), salary(5)}, true).
+					Return([]error{nil, repository.ErrAccountFrozen, nil}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"applied\":2,\"failed\":2,\"results\":[{\"index\":0,\"status\":\"applied\"},{\"index\":1,\"status\":\"failed\",\"error\":{\"code\":\"unknown_currency\",\"message\":\"unknown currency\"}},{\"index\":2,\"status\":\"failed\",\"error\":{\"code\":\"account_frozen\",\"message\":\"account is frozen\"}},{\"index\":3,\"status\":\"applied\"}]}\n",
+		},
+		{
+			name: "invalid item",
+			inputBody: `{"transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},
+				{"receiver_id": 3, "sender_id": 1, "amount": 100}
+			]}`,
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"transactions\":{\"1\":{\"comment\":\"cannot be blank\"}}}}\n",
+		},
+		{
+			name:                "empty",
+			inputBody:           `{"transactions": []}`,
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"transactions\":\"cannot be blank\"}}\n",
+		},
+		{
+			name: "database down",
+			inputBody: `{"transactions": [
+				{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"}
+			]}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransactions(gomock.Any(), gomock.Any(), false).Return(nil, errors.New("connection refused"))
+			},
+			expectedStatusCode:  500,
+			expectedRequestBody: "{\"code\":\"internal_error\",\"message\":\"internal server error\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewAccountHandler(log, rep, rates)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", "/transactions/batch", bytes.NewBufferString(testCase.inputBody))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
+func Test_batchTransactionSizeLimit(t *testing.T) {
+	batch := func(n int) string {
+		item := `{"receiver_id": 2, "sender_id": 1, "amount": 100, "comment": "salary for March"},`
+		return `{"transactions": [` + strings.TrimSuffix(strings.Repeat(item, n), ",") + `]}`
+	}
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	rep := mock_repository.NewMockAccount(c)
+	rep.EXPECT().MoneyTransactions(gomock.Any(), gomock.Len(models.MaxBatchTransactions), false).
+		Return(make([]error, models.MaxBatchTransactions), nil)
+
+	handler := handler.NewAccountHandler(log, rep, rates)
+	router := mux.NewRouter()
+	handler.Register(router)
+
+	req := httptest.NewRequest("POST", "/transactions/batch", bytes.NewBufferString(batch(models.MaxBatchTransactions)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"applied":100,"failed":0`)
+
+	req = httptest.NewRequest("POST", "/transactions/batch", bytes.NewBufferString(batch(models.MaxBatchTransactions+1)))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"transactions\":\"the length must be between 1 and 100\"}}\n", w.Body.String())
+}
+
+func Test_refundTransaction(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockAccount)
+
+	refunded := func(ctx context.Context, refund *models.Refund) error {
+		*refund = models.Refund{TransactionID: refund.TransactionID, Amount: 2000000, Comment: "refund of transaction 12", Currency: "RUB",
+			EntryID: 9, Refunded: 2000000, Remaining: 3000000}
+		return nil
+	}
+
+	testTable := []struct {
+		name, url, inputBody string
+		mockBehavior         mockBehavior
+		expectedStatusCode   int
+		expectedRequestBody  string
+	}{
+		{
+			name:      "partial",
+			url:       "/transaction/12/refund",
+			inputBody: `{"amount": 200}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Refund(gomock.Any(), &models.Refund{TransactionID: 12, Amount: 2000000}).DoAndReturn(refunded)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transaction_id\":12,\"amount\":200.00,\"comment\":\"refund of transaction 12\",\"currency\":\"RUB\",\"entry_id\":9,\"refunded\":200.00,\"remaining\":300.00}\n",
+		},
+		{
+			name: "full without a body",
+			url:  "/transaction/12/refund",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Refund(gomock.Any(), &models.Refund{TransactionID: 12}).DoAndReturn(refunded)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transaction_id\":12,\"amount\":200.00,\"comment\":\"refund of transaction 12\",\"currency\":\"RUB\",\"entry_id\":9,\"refunded\":200.00,\"remaining\":300.00}\n",
+		},
+		{
+			name:      "more than is left",
+			url:       "/transaction/12/refund",
+			inputBody: `{"amount": 600}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Refund(gomock.Any(), &models.Refund{TransactionID: 12, Amount: 6000000}).Return(repository.ErrRefundExceedsAmount)
+			},
+			expectedStatusCode:  422,
+			expectedRequestBody: "{\"code\":\"refund_exceeds_amount\",\"message\":\"refund exceeds the amount not yet refunded\"}\n",
+		},
+		{
+			name: "not a transfer",
+			url:  "/transaction/3/refund",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Refund(gomock.Any(), &models.Refund{TransactionID: 3}).Return(repository.ErrNotRefundable)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"not_refundable\",\"message\":\"transaction is not a refundable transfer\"}\n",
+		},
+		{
+			name: "unknown transaction",
+			url:  "/transaction/13/refund",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Refund(gomock.Any(), &models.Refund{TransactionID: 13}).Return(repository.ErrTransactionNotFound)
+			},
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"transaction_not_found\",\"message\":\"transaction not found\"}\n",
+		},
+		{
+			name:                "negative amount",
+			url:                 "/transaction/12/refund",
+			inputBody:           `{"amount": -1}`,
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"amount\":\"must be no less than 0.00\"}}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewAccountHandler(log, rep, rates)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", testCase.url, bytes.NewBufferString(testCase.inputBody))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
 func Test_changeBalance(t *testing.T) {
 	type mockBehavior func(s *mock_repository.MockAccount, tr *models.AccountDebit)
 
@@ -143,15 +487,35 @@ func Test_changeBalance(t *testing.T) {
 				}`,
 			tr: &models.AccountDebit{
 				AccountID: 1,
-				Amount:    22.5,
+				Amount:    225000,
 				Comment:   "Credit payment",
+				Currency:  "RUB",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
-				s.EXPECT().ChangeBalance(tr).Return(nil)
+				s.EXPECT().ChangeBalance(gomock.Any(), tr).Return(nil)
 			},
 			expectedStatusCode:  200,
 			expectedRequestBody: "\"Balance succsefully changed\"\n",
 		},
+		{
+			name: "insufficient funds",
+			inputBody: `{
+				"account_id": 1,
+				"amount": -22.5,
+				"comment": "Credit payment"
+				}`,
+			tr: &models.AccountDebit{
+				AccountID: 1,
+				Amount:    -225000,
+				Comment:   "Credit payment",
+				Currency:  "RUB",
+			},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
+				s.EXPECT().ChangeBalance(gomock.Any(), tr).Return(&repository.InsufficientFundsError{AccountID: 1, Currency: "RUB", Balance: 100000, Requested: 225000})
+			},
+			expectedStatusCode:  422,
+			expectedRequestBody: "{\"code\":\"insufficient_funds\",\"message\":\"insufficient funds\",\"details\":{\"account_id\":1,\"currency\":\"RUB\",\"balance\":10.00,\"requested\":22.50}}\n",
+		},
 		{
 			name: "Invalid JSON request",
 			inputBody: `{
@@ -164,7 +528,52 @@ func Test_changeBalance(t *testing.T) {
 			},
 
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while parsing json. err:invalid character 'c' looking for beginning of object key string \"\n",
+			expectedRequestBody: "{\"code\":\"invalid_json\",\"message\":\"request body is not valid JSON\",\"details\":\"invalid character 'c' looking for beginning of object key string\"}\n",
+		},
+		{
+			name: "Too precise amount",
+			inputBody: `{
+				"account_id": 1,
+				"amount": 22.50005,
+				"comment": "Credit payment"
+				}`,
+			tr: &models.AccountDebit{},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
+			},
+
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_json\",\"message\":\"request body is not valid JSON\",\"details\":\"amount must have at most 4 decimal places\"}\n",
+		},
+		{
+			name: "Too precise for the currency",
+			inputBody: `{
+				"account_id": 1,
+				"amount": 22.5,
+				"comment": "Credit payment",
+				"currency": "JPY"
+				}`,
+			tr: &models.AccountDebit{},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
+			},
+
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"amount\":\"must have at most 0 decimal places in JPY\"}}\n",
+		},
+		{
+			name: "Three decimal places in KWD",
+			inputBody: `{
+				"account_id": 1,
+				"amount": 22.505,
+				"comment": "Credit payment",
+				"currency": "KWD"
+				}`,
+			tr: &models.AccountDebit{AccountID: 1, Amount: 225050, Comment: "Credit payment", Currency: "KWD"},
+			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
+				s.EXPECT().ChangeBalance(gomock.Any(), tr).Return(nil)
+			},
+
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Balance succsefully changed\"\n",
 		},
 		{
 			name: "Invalid account_id",
@@ -175,14 +584,14 @@ func Test_changeBalance(t *testing.T) {
 				}`,
 			tr: &models.AccountDebit{
 				AccountID: 1,
-				Amount:    22.5,
+				Amount:    225000,
 				Comment:   "Credit payment",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
 
 			},
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while validating data. err:account_id: must be no less than 1. \"\n",
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"account_id\":\"must be no less than 1\"}}\n",
 		},
 		{
 			name: "account_id: cannot be blank",
@@ -192,14 +601,14 @@ func Test_changeBalance(t *testing.T) {
 				}`,
 			tr: &models.AccountDebit{
 				AccountID: 1,
-				Amount:    22.5,
+				Amount:    225000,
 				Comment:   "Credit payment",
 			},
 			mockBehavior: func(s *mock_repository.MockAccount, tr *models.AccountDebit) {
 
 			},
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while validating data. err:account_id: cannot be blank. \"\n",
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"account_id\":\"cannot be blank\"}}\n",
 		},
 	}
 
@@ -211,7 +620,7 @@ func Test_changeBalance(t *testing.T) {
 			rep := mock_repository.NewMockAccount(c)
 			testCase.mockBehavior(rep, testCase.tr)
 
-			handler := handler.NewAccountHandler(log, rep)
+			handler := handler.NewAccountHandler(log, rep, rates)
 			router := mux.NewRouter()
 			handler.Register(router)
 
@@ -224,6 +633,93 @@ func Test_changeBalance(t *testing.T) {
 	}
 }
 
+func Test_moneyIfMatch(t *testing.T) {
+	testTable := []struct {
+		name, url, inputBody, ifMatch string
+		mockBehavior                  func(s *mock_repository.MockAccount)
+		expectedStatusCode            int
+		expectedRequestBody           string
+	}{
+		{
+			name:      "transfer",
+			url:       "/transaction",
+			inputBody: `{"receiver_id": 1, "sender_id": 2, "amount": 22.5, "comment": "for lunch"}`,
+			ifMatch:   "\"5\"",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransaction(gomock.Any(), &models.Transaction{
+					ReceiverID:       1,
+					SenderID:         2,
+					Amount:           225000,
+					Comment:          "for lunch",
+					Currency:         "RUB",
+					ReceiverCurrency: "RUB",
+					ExpectedVersion:  5,
+				}).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Transaction was successful\"\n",
+		},
+		{
+			name:      "stale transfer",
+			url:       "/transaction",
+			inputBody: `{"receiver_id": 1, "sender_id": 2, "amount": 22.5, "comment": "for lunch"}`,
+			ifMatch:   "\"4\"",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().MoneyTransaction(gomock.Any(), gomock.Any()).Return(repository.ErrVersionMismatch)
+			},
+			expectedStatusCode:  412,
+			expectedRequestBody: "{\"code\":\"precondition_failed\",\"message\":\"account was changed since the given version\"}\n",
+		},
+		{
+			name:      "change balance",
+			url:       "/changeBalance",
+			inputBody: `{"account_id": 1, "amount": -22.5, "comment": "Credit payment"}`,
+			ifMatch:   "\"5\"",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().ChangeBalance(gomock.Any(), &models.AccountDebit{
+					AccountID:       1,
+					Amount:          -225000,
+					Comment:         "Credit payment",
+					Currency:        "RUB",
+					ExpectedVersion: 5,
+				}).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Balance succsefully changed\"\n",
+		},
+		{
+			name:                "weak etag",
+			url:                 "/changeBalance",
+			inputBody:           `{"account_id": 1, "amount": -22.5, "comment": "Credit payment"}`,
+			ifMatch:             "W/\"5\"",
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"If-Match must be an ETag of the account\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewAccountHandler(log, rep, rates)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", testCase.url, bytes.NewBufferString(testCase.inputBody))
+			req.Header.Set("If-Match", testCase.ifMatch)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
 func Test_getBalanceByID(t *testing.T) {
 	type mockBehavior func(s *mock_repository.MockAccount, id int)
 
@@ -233,16 +729,18 @@ func Test_getBalanceByID(t *testing.T) {
 		mockBehavior        mockBehavior
 		expectedStatusCode  int
 		expectedRequestBody string
+		expectedETag        string
 	}{
 		{
 			name: "ok",
 			id:   1,
 			url:  "/get/balance/1",
 			mockBehavior: func(s *mock_repository.MockAccount, id int) {
-				s.EXPECT().GetBalanceByID(id).Return(&models.Account{ID: 1, Balance: 22}, nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(&models.Account{ID: 1, Balances: map[string]models.Money{"RUB": 220000}, Version: 7}, nil)
 			},
 			expectedStatusCode:  200,
-			expectedRequestBody: "{\"account_id\":1,\"balance\":22}\n",
+			expectedRequestBody: "{\"account_id\":1,\"balances\":{\"RUB\":22.00},\"version\":7}\n",
+			expectedETag:        "\"7\"",
 		},
 		{
 			name: "Invalid link",
@@ -259,11 +757,11 @@ func Test_getBalanceByID(t *testing.T) {
 			id:   100,
 			url:  "/get/balance/100",
 			mockBehavior: func(s *mock_repository.MockAccount, id int) {
-				s.EXPECT().GetBalanceByID(id).Return(nil, errors.New("sql: no rows in result set"))
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(nil, repository.ErrUserDoesntExist)
 
 			},
-			expectedStatusCode:  500,
-			expectedRequestBody: "\"error occurred while getting all account. err:sql: no rows in result set \"\n",
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"account_not_found\",\"message\":\"user doesnt exist\"}\n",
 		},
 	}
 
@@ -275,7 +773,7 @@ func Test_getBalanceByID(t *testing.T) {
 			rep := mock_repository.NewMockAccount(c)
 			testCase.mockBehavior(rep, testCase.id)
 
-			handler := handler.NewAccountHandler(log, rep)
+			handler := handler.NewAccountHandler(log, rep, rates)
 			router := mux.NewRouter()
 			handler.Register(router)
 
@@ -284,6 +782,7 @@ func Test_getBalanceByID(t *testing.T) {
 			router.ServeHTTP(w, req)
 			assert.Equal(t, testCase.expectedStatusCode, w.Code)
 			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+			assert.Equal(t, testCase.expectedETag, w.Header().Get("ETag"))
 		})
 	}
 }
@@ -294,10 +793,52 @@ func Test_currencyBalance(t *testing.T) {
 	testTable := []struct {
 		name, url           string
 		id                  int
+		rates               exchange.RateProvider
 		mockBehavior        mockBehavior
 		expectedStatusCode  int
 		expectedRequestBody string
 	}{
+		{
+			name: "ok",
+			id:   1,
+			url:  "/get/balance/usd/1",
+			mockBehavior: func(s *mock_repository.MockAccount, id int) {
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(&models.Account{ID: 1, Balances: map[string]models.Money{"RUB": 10000000}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"result\":16.00}\n",
+		},
+		{
+			name: "several currencies",
+			id:   1,
+			url:  "/get/balance/USD/1",
+			mockBehavior: func(s *mock_repository.MockAccount, id int) {
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(&models.Account{ID: 1, Balances: map[string]models.Money{"RUB": 10000000, "USD": 50000}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"result\":21.00}\n",
+		},
+		{
+			name: "unknown currency",
+			id:   1,
+			url:  "/get/balance/XXX/1",
+			mockBehavior: func(s *mock_repository.MockAccount, id int) {
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(&models.Account{ID: 1, Balances: map[string]models.Money{"RUB": 10000000}}, nil)
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"unknown_currency\",\"message\":\"unknown currency\"}\n",
+		},
+		{
+			name:  "rates unavailable",
+			id:    1,
+			url:   "/get/balance/USD/1",
+			rates: unavailableRates{},
+			mockBehavior: func(s *mock_repository.MockAccount, id int) {
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(&models.Account{ID: 1, Balances: map[string]models.Money{"RUB": 10000000}}, nil)
+			},
+			expectedStatusCode:  503,
+			expectedRequestBody: "{\"code\":\"rates_unavailable\",\"message\":\"exchange rate is unavailable\"}\n",
+		},
 
 		{
 			name: "Invalid link",
@@ -314,11 +855,11 @@ func Test_currencyBalance(t *testing.T) {
 			id:   100,
 			url:  "/get/balance/USD/100",
 			mockBehavior: func(s *mock_repository.MockAccount, id int) {
-				s.EXPECT().GetBalanceByID(id).Return(nil, errors.New("sql: no rows in result set"))
+				s.EXPECT().GetBalanceByID(gomock.Any(), id).Return(nil, repository.ErrUserDoesntExist)
 
 			},
-			expectedStatusCode:  500,
-			expectedRequestBody: "\"error occurred while getting all account. err:sql: no rows in result set \"\n",
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"account_not_found\",\"message\":\"user doesnt exist\"}\n",
 		},
 	}
 
@@ -330,7 +871,11 @@ func Test_currencyBalance(t *testing.T) {
 			rep := mock_repository.NewMockAccount(c)
 			testCase.mockBehavior(rep, testCase.id)
 
-			handler := handler.NewAccountHandler(log, rep)
+			provider := testCase.rates
+			if provider == nil {
+				provider = rates
+			}
+			handler := handler.NewAccountHandler(log, rep, provider)
 			router := mux.NewRouter()
 			handler.Register(router)
 
@@ -356,20 +901,20 @@ func Test_getAll(t *testing.T) {
 			name: "ok",
 
 			mockBehavior: func(s *mock_repository.MockAccount) {
-				s.EXPECT().GetAll().Return([]models.Account{{ID: 1, Balance: 22}}, nil)
+				s.EXPECT().GetAll(gomock.Any()).Return([]models.Account{{ID: 1, Balances: map[string]models.Money{"RUB": 220000}}}, nil)
 			},
 			expectedStatusCode:  200,
-			expectedRequestBody: "[{\"account_id\":1,\"balance\":22}]\n",
+			expectedRequestBody: "[{\"account_id\":1,\"balances\":{\"RUB\":22.00}}]\n",
 		},
 		{
 			name: "no accounts",
 		
 			mockBehavior: func(s *mock_repository.MockAccount) {
-				s.EXPECT().GetAll().Return(nil, errors.New("sql: no rows in result set"))
+				s.EXPECT().GetAll(gomock.Any()).Return(nil, errors.New("sql: no rows in result set"))
 
 			},
 			expectedStatusCode:  500,
-			expectedRequestBody: "\"error occurred while getting all accounts. err:sql: no rows in result set \"\n",
+			expectedRequestBody: "{\"code\":\"internal_error\",\"message\":\"internal server error\"}\n",
 		},
 	}
 
@@ -381,7 +926,7 @@ func Test_getAll(t *testing.T) {
 			rep := mock_repository.NewMockAccount(c)
 			testCase.mockBehavior(rep)
 
-			handler := handler.NewAccountHandler(log, rep)
+			handler := handler.NewAccountHandler(log, rep, rates)
 			router := mux.NewRouter()
 			handler.Register(router)
 
@@ -393,3 +938,200 @@ func Test_getAll(t *testing.T) {
 		})
 	}
 }
+
+func Test_createAccount(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockAccount)
+
+	testTable := []struct {
+		name, inputBody     string
+		mockBehavior        mockBehavior
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name:      "ok",
+			inputBody: `{"account_id": 1}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Create(gomock.Any(), 1).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountActive, Balances: map[string]models.Money{}}, nil)
+			},
+			expectedStatusCode:  201,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"active\",\"balances\":{}}\n",
+		},
+		{
+			name:      "already exists",
+			inputBody: `{"account_id": 1}`,
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Create(gomock.Any(), 1).Return(repository.ErrAccountExists)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"account_exists\",\"message\":\"account already exists\"}\n",
+		},
+		{
+			name:                "no id",
+			inputBody:           `{}`,
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"account_id\":\"cannot be blank\"}}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewAccountHandler(log, rep, rates)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", "/account", bytes.NewBufferString(testCase.inputBody))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
+func Test_changeAccountStatus(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockAccount)
+
+	testTable := []struct {
+		name, url, ifMatch  string
+		mockBehavior        mockBehavior
+		expectedStatusCode  int
+		expectedRequestBody string
+		expectedETag        string
+	}{
+		{
+			name: "freeze",
+			url:  "/account/1/freeze",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Freeze(gomock.Any(), 1, int64(0)).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountFrozen, Balances: map[string]models.Money{"RUB": 220000}, Version: 4}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"frozen\",\"balances\":{\"RUB\":22.00},\"version\":4}\n",
+			expectedETag:        "\"4\"",
+		},
+		{
+			name:    "freeze if match",
+			url:     "/account/1/freeze",
+			ifMatch: "\"3\"",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Freeze(gomock.Any(), 1, int64(3)).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountFrozen, Balances: map[string]models.Money{"RUB": 220000}, Version: 4}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"frozen\",\"balances\":{\"RUB\":22.00},\"version\":4}\n",
+			expectedETag:        "\"4\"",
+		},
+		{
+			name:    "freeze if match any",
+			url:     "/account/1/freeze",
+			ifMatch: "*",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Freeze(gomock.Any(), 1, int64(0)).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountFrozen, Balances: map[string]models.Money{"RUB": 220000}, Version: 4}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"frozen\",\"balances\":{\"RUB\":22.00},\"version\":4}\n",
+			expectedETag:        "\"4\"",
+		},
+		{
+			name:    "freeze stale version",
+			url:     "/account/1/freeze",
+			ifMatch: "\"2\"",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Freeze(gomock.Any(), 1, int64(2)).Return(repository.ErrVersionMismatch)
+			},
+			expectedStatusCode:  412,
+			expectedRequestBody: "{\"code\":\"precondition_failed\",\"message\":\"account was changed since the given version\"}\n",
+		},
+		{
+			name:                "freeze malformed if match",
+			url:                 "/account/1/freeze",
+			ifMatch:             "3",
+			mockBehavior:        func(s *mock_repository.MockAccount) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"If-Match must be an ETag of the account\"}\n",
+		},
+		{
+			name: "unfreeze",
+			url:  "/account/1/unfreeze",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Unfreeze(gomock.Any(), 1, int64(0)).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountActive, Balances: map[string]models.Money{"RUB": 220000}, Version: 5}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"active\",\"balances\":{\"RUB\":22.00},\"version\":5}\n",
+			expectedETag:        "\"5\"",
+		},
+		{
+			name: "close",
+			url:  "/account/1/close",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Close(gomock.Any(), 1, int64(0)).Return(nil)
+				s.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Status: models.AccountClosed, Balances: map[string]models.Money{}, Version: 5}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"status\":\"closed\",\"balances\":{},\"version\":5}\n",
+			expectedETag:        "\"5\"",
+		},
+		{
+			name: "close with money left",
+			url:  "/account/1/close",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Close(gomock.Any(), 1, int64(0)).Return(repository.ErrAccountNotEmpty)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"account_not_empty\",\"message\":\"account still holds money\"}\n",
+		},
+		{
+			name: "freeze closed",
+			url:  "/account/1/freeze",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Freeze(gomock.Any(), 1, int64(0)).Return(repository.ErrAccountClosed)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"account_closed\",\"message\":\"account is closed\"}\n",
+		},
+		{
+			name: "no such acc",
+			url:  "/account/100/unfreeze",
+			mockBehavior: func(s *mock_repository.MockAccount) {
+				s.EXPECT().Unfreeze(gomock.Any(), 100, int64(0)).Return(repository.ErrUserDoesntExist)
+			},
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"account_not_found\",\"message\":\"user doesnt exist\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewAccountHandler(log, rep, rates)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", testCase.url, nil)
+			if testCase.ifMatch != "" {
+				req.Header.Set("If-Match", testCase.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+			assert.Equal(t, testCase.expectedETag, w.Header().Get("ETag"))
+		})
+	}
+}
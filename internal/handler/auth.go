@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/apikey"
+	"avito-tech/pkg/logger"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiKeyHeader    = "X-API-Key"
+	timestampHeader = "X-Timestamp"
+	nonceHeader     = "X-Nonce"
+	hmacScheme      = "HMAC "
+
+	// maxClockSkew bounds how old, or how far in the future, the timestamp
+	// of a signed request may be.
+	maxClockSkew = 5 * time.Minute
+
+	// maxSignedBodySize bounds the body of signed requests, which is read
+	// whole to be verified before the route is served. Bodies sent with an
+	// Idempotency-Key are read whole to be hashed and share the limit.
+	maxSignedBodySize = 1 << 20
+)
+
+var nonceFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+type clientKey struct{}
+
+// routeScopes is the scope each route requires. Routes missing here need
+// admin, so a new route is never left open by accident.
+var routeScopes = map[string]string{
+	getBalanceByID:             models.ScopeReadBalance,
+	currencyBalance:            models.ScopeReadBalance,
+	getTransactionsByAccountID: models.ScopeReadBalance,
+	transaction:                models.ScopeTransfer,
+	batchTransaction:           models.ScopeTransfer,
+	refundTransaction:          models.ScopeTransfer,
+	reserve:                    models.ScopeTransfer,
+	reserveConfirm:             models.ScopeTransfer,
+	reserveCancel:              models.ScopeTransfer,
+	schedules:                  models.ScopeTransfer,
+	schedule:                   models.ScopeTransfer,
+	scheduleRuns:               models.ScopeTransfer,
+}
+
+// publicRoutes are served without credentials.
+var publicRoutes = map[string]bool{
+	metricsRoute: true,
+	healthz:      true,
+	readyz:       true,
+}
+
+type authHandler struct {
+	logger     logger.Logger
+	clients    repository.APIClients
+	signingKey []byte
+	now        func() time.Time
+}
+
+// NewAuthHandler refuses signed requests when signingKey is empty.
+func NewAuthHandler(logger logger.Logger, clients repository.APIClients, signingKey string) *authHandler {
+	return &authHandler{
+		logger:     logger,
+		clients:    clients,
+		signingKey: []byte(signingKey),
+		now:        time.Now,
+	}
+}
+
+// Middleware authenticates every request, either by an X-API-Key header
+// holding the key, or by an HMAC signature of the request:
+//
+//	Authorization: HMAC <key id>:<signature>
+//	X-Timestamp: <unix seconds>
+//	X-Nonce: <16 to 64 of A-Z, a-z, 0-9, _ and ->
+//
+// and checks that the client holds the scope of the matched route. The client
+// is put in the request context, see clientFrom.
+func (ah *authHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, err := ah.authenticate(w, r)
+		if err != nil {
+			writeError(w, r, ah.logger, "authenticating request", err)
+			return
+		}
+
+		scope := requiredScope(r)
+		if !client.HasScope(scope) {
+			writeError(w, r, ah.logger, "authorizing request",
+				newAPIError(http.StatusForbidden, codeForbidden, "client lacks the "+scope+" scope", nil, nil))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+}
+
+func (ah *authHandler) authenticate(w http.ResponseWriter, r *http.Request) (client *models.APIClient, err error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return ah.byKey(r.Context(), key)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, hmacScheme) {
+		return ah.bySignature(w, r, strings.TrimPrefix(auth, hmacScheme))
+	}
+	return nil, unauthorized(errors.New("no credentials"))
+}
+
+func (ah *authHandler) byKey(ctx context.Context, key string) (client *models.APIClient, err error) {
+	keyID, secret, err := apikey.Parse(key)
+	if err != nil {
+		return nil, unauthorized(err)
+	}
+
+	client, err = ah.client(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(apikey.Hash(secret), client.KeyHash) != 1 {
+		return nil, unauthorized(errors.New("wrong api key secret"))
+	}
+
+	return client, nil
+}
+
+// bySignature authenticates a signed request. Its nonce is only recorded once
+// the signature is verified, so nobody but the client can use up its nonces,
+// and is refused when seen before, so a captured request cannot be replayed
+// while its timestamp is still accepted.
+func (ah *authHandler) bySignature(w http.ResponseWriter, r *http.Request, credentials string) (client *models.APIClient, err error) {
+	if len(ah.signingKey) == 0 {
+		return nil, unauthorized(errors.New("signed requests are disabled"))
+	}
+
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 {
+		return nil, unauthorized(errors.New("malformed signature"))
+	}
+	keyID, signature := parts[0], parts[1]
+
+	timestamp := r.Header.Get(timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, unauthorized(errors.New("missing or malformed timestamp"))
+	}
+	signedAt := time.Unix(seconds, 0)
+	if skew := ah.now().Sub(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, unauthorized(errors.New("request timestamp is too far from the server time"))
+	}
+
+	requestNonce := r.Header.Get(nonceHeader)
+	if !nonceFormat.MatchString(requestNonce) {
+		return nil, unauthorized(errors.New("missing or malformed nonce"))
+	}
+
+	client, err = ah.client(r.Context(), keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+	if err != nil {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, codeRequestTooLarge,
+			fmt.Sprintf("signed request bodies are limited to %d bytes", maxSignedBodySize), nil, err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	signingSecret := apikey.SigningSecret(ah.signingKey, client.KeyID)
+	if !apikey.Verify(signingSecret, r.Method, r.URL.RequestURI(), timestamp, requestNonce, body, signature) {
+		return nil, unauthorized(errors.New("wrong signature"))
+	}
+
+	fresh, err := ah.clients.UseNonce(r.Context(), client.KeyID, requestNonce, signedAt.Add(maxClockSkew))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, unauthorized(errors.New("nonce was used before"))
+	}
+
+	return client, nil
+}
+
+// client returns the client owning keyID unless the key was revoked.
+// Database errors are returned as they are, so they are not mistaken for bad
+// credentials.
+func (ah *authHandler) client(ctx context.Context, keyID string) (client *models.APIClient, err error) {
+	client, err = ah.clients.GetClientByKeyID(ctx, keyID)
+	if errors.Is(err, repository.ErrClientNotFound) {
+		return nil, unauthorized(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.RevokedAt != nil {
+		return nil, unauthorized(errors.New("api key was revoked"))
+	}
+
+	return client, nil
+}
+
+// clientFrom returns the client the request was authenticated as, nil for
+// public routes.
+func clientFrom(ctx context.Context) *models.APIClient {
+	client, _ := ctx.Value(clientKey{}).(*models.APIClient)
+	return client
+}
+
+// unauthorized reports failed authentication. The reason is only logged, so
+// clients cannot tell which part of their credentials is wrong.
+func unauthorized(err error) *apiError {
+	return newAPIError(http.StatusUnauthorized, codeUnauthorized, "missing or invalid credentials", nil, err)
+}
+
+func requiredScope(r *http.Request) string {
+	if scope, ok := routeScopes[routeTemplate(r)]; ok {
+		return scope
+	}
+	return models.ScopeAdmin
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return ""
+}
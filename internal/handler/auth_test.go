@@ -0,0 +1,327 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/metrics"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/internal/repository/mock_repository"
+	"avito-tech/pkg/apikey"
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_auth(t *testing.T) {
+	type mockBehavior func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount)
+
+	client := func(scopes ...string) *models.APIClient {
+		return &models.APIClient{KeyID: "shop", Name: "shop", KeyHash: apikey.Hash("secret"), Scopes: scopes}
+	}
+	revoked := client(models.ScopeAdmin)
+	revoked.RevokedAt = &time.Time{}
+
+	transfer := `{"receiver_id": 1, "sender_id": 2, "amount": 10, "comment": "for lunch"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	const signingKey = "0123456789abcdef0123456789abcdef"
+	const nonce = "c2f1b6a0d4e84c6f"
+	signingSecret := apikey.SigningSecret([]byte(signingKey), "shop")
+	signedWith := func(secret, method, uri, timestamp, body string) map[string]string {
+		return map[string]string{
+			"Authorization": "HMAC shop:" + apikey.Sign(secret, method, uri, timestamp, nonce, []byte(body)),
+			"X-Timestamp":   timestamp,
+			"X-Nonce":       nonce,
+		}
+	}
+	signed := func(method, uri, timestamp, body string) map[string]string {
+		return signedWith(signingSecret, method, uri, timestamp, body)
+	}
+	unsigned := `{"code":"unauthorized","message":"missing or invalid credentials","request_id":"req-1"}` + "\n"
+	tooLarge := `{"receiver_id": 1, "sender_id": 2, "amount": 10, "comment": "` + strings.Repeat("a", 1<<20) + `"}`
+
+	testTable := []struct {
+		name, method, url, body string
+		headers                 map[string]string
+		signingDisabled         bool
+		mockBehavior            mockBehavior
+		expectedStatusCode      int
+		expectedRequestBody     string
+	}{
+		{
+			name:                "no credentials",
+			method:              "GET",
+			url:                 "/get/all",
+			mockBehavior:        func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "admin key",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeAdmin), nil)
+				a.EXPECT().GetAll(gomock.Any()).Return([]models.Account{}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "[]\n",
+		},
+		{
+			name:    "wrong secret",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "shop.guess"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeAdmin), nil)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "unknown key",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "nobody.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "nobody").Return(nil, repository.ErrClientNotFound)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "revoked key",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(revoked, nil)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "malformed key",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "reader cannot list accounts",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeReadBalance), nil)
+			},
+			expectedStatusCode:  403,
+			expectedRequestBody: "{\"code\":\"forbidden\",\"message\":\"client lacks the admin scope\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "reader reads a balance",
+			method:  "GET",
+			url:     "/get/balance/1",
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeReadBalance), nil)
+				a.EXPECT().GetBalanceByID(gomock.Any(), 1).Return(&models.Account{ID: 1, Balances: map[string]models.Money{}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"account_id\":1,\"balances\":{}}\n",
+		},
+		{
+			name:    "transfer client cannot credit",
+			method:  "POST",
+			url:     "/changeBalance",
+			body:    `{"account_id": 1, "amount": 1000000, "comment": "free money"}`,
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeReadBalance, models.ScopeTransfer), nil)
+			},
+			expectedStatusCode:  403,
+			expectedRequestBody: "{\"code\":\"forbidden\",\"message\":\"client lacks the admin scope\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "signed transfer",
+			method:  "POST",
+			url:     "/transaction",
+			body:    transfer,
+			headers: signed("POST", "/transaction", now, transfer),
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeTransfer), nil)
+				c.EXPECT().UseNonce(gomock.Any(), "shop", nonce, gomock.Any()).Return(true, nil)
+				a.EXPECT().MoneyTransaction(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Transaction was successful\"\n",
+		},
+		{
+			name:    "replayed signed transfer",
+			method:  "POST",
+			url:     "/transaction",
+			body:    transfer,
+			headers: signed("POST", "/transaction", now, transfer),
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeTransfer), nil)
+				c.EXPECT().UseNonce(gomock.Any(), "shop", nonce, gomock.Any()).Return(false, nil)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: unsigned,
+		},
+		{
+			name:   "signature without nonce",
+			method: "POST",
+			url:    "/transaction",
+			body:   transfer,
+			headers: map[string]string{
+				"Authorization": "HMAC shop:" + apikey.Sign(signingSecret, "POST", "/transaction", now, "", []byte(transfer)),
+				"X-Timestamp":   now,
+			},
+			mockBehavior:        func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {},
+			expectedStatusCode:  401,
+			expectedRequestBody: unsigned,
+		},
+		{
+			name:    "signed with the stored key hash",
+			method:  "POST",
+			url:     "/transaction",
+			body:    transfer,
+			headers: signedWith(string(apikey.Hash("secret")), "POST", "/transaction", now, transfer),
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeTransfer), nil)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: unsigned,
+		},
+		{
+			name:    "signed body too large",
+			method:  "POST",
+			url:     "/transaction",
+			body:    tooLarge,
+			headers: signed("POST", "/transaction", now, tooLarge),
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeTransfer), nil)
+			},
+			expectedStatusCode:  413,
+			expectedRequestBody: "{\"code\":\"request_too_large\",\"message\":\"signed request bodies are limited to 1048576 bytes\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:                "signing disabled",
+			method:              "POST",
+			url:                 "/transaction",
+			body:                transfer,
+			headers:             signed("POST", "/transaction", now, transfer),
+			signingDisabled:     true,
+			mockBehavior:        func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {},
+			expectedStatusCode:  401,
+			expectedRequestBody: unsigned,
+		},
+		{
+			name:    "signature of another body",
+			method:  "POST",
+			url:     "/transaction",
+			body:    `{"receiver_id": 1, "sender_id": 2, "amount": 10000, "comment": "for lunch"}`,
+			headers: signed("POST", "/transaction", now, transfer),
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(client(models.ScopeTransfer), nil)
+			},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:                "stale signature",
+			method:              "POST",
+			url:                 "/transaction",
+			body:                transfer,
+			headers:             signed("POST", "/transaction", stale, transfer),
+			mockBehavior:        func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {},
+			expectedStatusCode:  401,
+			expectedRequestBody: "{\"code\":\"unauthorized\",\"message\":\"missing or invalid credentials\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:    "clients unavailable",
+			method:  "GET",
+			url:     "/get/all",
+			headers: map[string]string{"X-API-Key": "shop.secret"},
+			mockBehavior: func(c *mock_repository.MockAPIClients, a *mock_repository.MockAccount) {
+				c.EXPECT().GetClientByKeyID(gomock.Any(), "shop").Return(nil, errors.New("connection refused"))
+			},
+			expectedStatusCode:  500,
+			expectedRequestBody: "{\"code\":\"internal_error\",\"message\":\"internal server error\",\"request_id\":\"req-1\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			clients := mock_repository.NewMockAPIClients(c)
+			accounts := mock_repository.NewMockAccount(c)
+			testCase.mockBehavior(clients, accounts)
+
+			config := handlerConfig(t)
+			if !testCase.signingDisabled {
+				config.Auth.SigningKey = signingKey
+			}
+			h := handler.NewHandler(log, &repository.Repository{APIClients: clients, Account: accounts}, rates, metrics.New(nil), handler.Readiness{}, config)
+
+			req := httptest.NewRequest(testCase.method, testCase.url, bytes.NewBufferString(testCase.body))
+			req.Header.Set("X-Request-ID", "req-1")
+			for name, value := range testCase.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			h.InitRoutes().ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
+func Test_metricsArePublic(t *testing.T) {
+	h := handler.NewHandler(log, &repository.Repository{}, rates, metrics.New(nil), handler.Readiness{}, handlerConfig(t))
+
+	w := httptest.NewRecorder()
+	h.InitRoutes().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func Test_idempotencyKeysArePerClient(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	clients := mock_repository.NewMockAPIClients(c)
+	keys := mock_repository.NewMockIdempotency(c)
+	clients.EXPECT().GetClientByKeyID(gomock.Any(), "shop").
+		Return(&models.APIClient{KeyID: "shop", KeyHash: apikey.Hash("secret"), Scopes: []string{models.ScopeAdmin}}, nil)
+	keys.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key *models.IdempotencyKey, _ time.Time) (bool, error) {
+		assert.Equal(t, "shop", key.KeyID)
+		return false, nil
+	})
+	keys.EXPECT().GetByKey(gomock.Any(), "shop", "key-1").Return(nil, repository.ErrIdempotencyKeyNotFound)
+
+	h := handler.NewHandler(log, &repository.Repository{APIClients: clients, Idempotency: keys}, rates, metrics.New(nil), handler.Readiness{}, handlerConfig(t))
+
+	req := httptest.NewRequest("POST", "/changeBalance", bytes.NewBufferString(`{"account_id": 1, "amount": 10, "comment": "top up"}`))
+	req.Header.Set("X-API-Key", "shop.secret")
+	req.Header.Set("Idempotency-Key", "key-1")
+	w := httptest.NewRecorder()
+	h.InitRoutes().ServeHTTP(w, req)
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), "request_in_progress")
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Deadline bounds every request by timeout. Repository calls use the request
+// context, so SQL still running when the deadline passes or the client goes
+// away is cancelled. A zero timeout leaves requests unbounded.
+func Deadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Deadline(t *testing.T) {
+	testTable := []struct {
+		name         string
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		{
+			name:         "bounded",
+			timeout:      time.Second,
+			wantDeadline: true,
+		},
+		{
+			name:         "unbounded",
+			timeout:      0,
+			wantDeadline: false,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var (
+				deadline time.Time
+				ok       bool
+			)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			})
+
+			start := time.Now()
+			handler.Deadline(testCase.timeout)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/get/all", nil))
+
+			assert.Equal(t, testCase.wantDeadline, ok)
+			if testCase.wantDeadline {
+				assert.WithinDuration(t, start.Add(testCase.timeout), deadline, 100*time.Millisecond)
+			}
+		})
+	}
+}
+
+func Test_DeadlineCancelsContext(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		assert.ErrorIs(t, r.Context().Err(), context.DeadlineExceeded)
+	})
+
+	handler.Deadline(10*time.Millisecond)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/get/all", nil))
+}
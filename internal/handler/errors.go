@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/exchange"
+	"avito-tech/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Error codes returned in ErrorResponse.Code.
+const (
+	codeInvalidJSON         = "invalid_json"
+	codeInvalidParameter    = "invalid_parameter"
+	codeValidationFailed    = "validation_failed"
+	codeAccountNotFound     = "account_not_found"
+	codeReservationNotFound = "reservation_not_found"
+	codeReportNotFound      = "report_not_found"
+	codeScheduleNotFound    = "schedule_not_found"
+	codeTransactionNotFound = "transaction_not_found"
+	codeAccountExists       = "account_exists"
+	codeReservationExists   = "reservation_exists"
+	codeAccountFrozen       = "account_frozen"
+	codeAccountClosed       = "account_closed"
+	codeAccountNotEmpty     = "account_not_empty"
+	codeScheduleNotActive   = "schedule_not_active"
+	codeNotRefundable       = "not_refundable"
+	codePreconditionFailed  = "precondition_failed"
+	codeBatchFailed         = "batch_failed"
+	codeUnauthorized        = "unauthorized"
+	codeForbidden           = "forbidden"
+	codeRouteNotFound       = "route_not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeInsufficientFunds   = "insufficient_funds"
+	codeAmountTooSmall      = "amount_too_small"
+	codeRefundExceedsAmount = "refund_exceeds_amount"
+	codeUnknownCurrency     = "unknown_currency"
+	codeRatesUnavailable    = "rates_unavailable"
+	codeIdempotencyConflict = "idempotency_conflict"
+	codeRequestInProgress   = "request_in_progress"
+	codeRequestTooLarge     = "request_too_large"
+	codeTimeout             = "timeout"
+	codeInternal            = "internal_error"
+)
+
+// apiError is an error together with how it is reported to the client.
+type apiError struct {
+	status  int
+	code    string
+	message string
+	details interface{}
+	err     error
+}
+
+func (e *apiError) Error() string {
+	if e.err != nil {
+		return e.message + ": " + e.err.Error()
+	}
+	return e.message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func newAPIError(status int, code, message string, details interface{}, err error) *apiError {
+	return &apiError{
+		status:  status,
+		code:    code,
+		message: message,
+		details: details,
+		err:     err,
+	}
+}
+
+func invalidJSON(err error) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidJSON, "request body is not valid JSON", err.Error(), err)
+}
+
+// invalidParameter reports a path or query parameter that is not what, e.g.
+// "a number".
+func invalidParameter(name, what string, err error) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("%s must be %s", name, what), nil, err)
+}
+
+// validationFailed reports the per-field ozzo errors as details.
+func validationFailed(err error) *apiError {
+	var details interface{} = err.Error()
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		details = fieldErrs
+	}
+	return newAPIError(http.StatusBadRequest, codeValidationFailed, "request validation failed", details, err)
+}
+
+// errorStatuses maps repository and provider errors to the status and code
+// they are reported with. Their messages are safe to show to clients.
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{repository.ErrUserDoesntExist, http.StatusNotFound, codeAccountNotFound},
+	{repository.ErrReservationNotFound, http.StatusNotFound, codeReservationNotFound},
+	{repository.ErrScheduleNotFound, http.StatusNotFound, codeScheduleNotFound},
+	{repository.ErrTransactionNotFound, http.StatusNotFound, codeTransactionNotFound},
+	{repository.ErrAccountExists, http.StatusConflict, codeAccountExists},
+	{repository.ErrReservationExists, http.StatusConflict, codeReservationExists},
+	{repository.ErrAccountFrozen, http.StatusConflict, codeAccountFrozen},
+	{repository.ErrAccountClosed, http.StatusConflict, codeAccountClosed},
+	{repository.ErrAccountNotEmpty, http.StatusConflict, codeAccountNotEmpty},
+	{repository.ErrScheduleNotActive, http.StatusConflict, codeScheduleNotActive},
+	{repository.ErrNotRefundable, http.StatusConflict, codeNotRefundable},
+	{repository.ErrRefundPrecision, http.StatusBadRequest, codeValidationFailed},
+	{repository.ErrVersionMismatch, http.StatusPreconditionFailed, codePreconditionFailed},
+	{repository.ErrInsufficientFunds, http.StatusUnprocessableEntity, codeInsufficientFunds},
+	{repository.ErrNewAccNegativeBalance, http.StatusUnprocessableEntity, codeInsufficientFunds},
+	{repository.ErrZeroPosting, http.StatusUnprocessableEntity, codeAmountTooSmall},
+	{repository.ErrRefundExceedsAmount, http.StatusUnprocessableEntity, codeRefundExceedsAmount},
+	{exchange.ErrUnknownCurrency, http.StatusBadRequest, codeUnknownCurrency},
+	{exchange.ErrRateUnavailable, http.StatusServiceUnavailable, codeRatesUnavailable},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, codeTimeout},
+}
+
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var fundsErr *repository.InsufficientFundsError
+	if errors.As(err, &fundsErr) {
+		return newAPIError(http.StatusUnprocessableEntity, codeInsufficientFunds, repository.ErrInsufficientFunds.Error(),
+			&models.InsufficientFundsDetails{
+				AccountID: fundsErr.AccountID,
+				Currency:  fundsErr.Currency,
+				Balance:   fundsErr.Balance,
+				Requested: fundsErr.Requested,
+			}, err)
+	}
+
+	for _, known := range errorStatuses {
+		if errors.Is(err, known.err) {
+			return newAPIError(known.status, known.code, known.err.Error(), nil, err)
+		}
+	}
+
+	return newAPIError(http.StatusInternalServerError, codeInternal, "internal server error", nil, err)
+}
+
+// writeError is the single place errors are written to clients. Unknown
+// errors are logged in full but reported only as an internal error.
+func writeError(w http.ResponseWriter, r *http.Request, logger logger.Logger, operation string, err error) {
+	apiErr := toAPIError(err)
+	logger = logger.WithContext(r.Context())
+	if apiErr.status >= http.StatusInternalServerError {
+		logger.Errorf("error occurred while %s. err:%s ", operation, err)
+	} else {
+		logger.Warnf("error occurred while %s. err:%s ", operation, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.status)
+	json.NewEncoder(w).Encode(&models.ErrorResponse{
+		Code:      apiErr.code,
+		Message:   apiErr.message,
+		Details:   apiErr.details,
+		RequestID: requestIDFrom(r.Context()),
+	})
+}
@@ -0,0 +1,59 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/metrics"
+	"avito-tech/internal/repository"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_routingErrors(t *testing.T) {
+	testTable := []struct {
+		name, method, url   string
+		requestID           string
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name:                "unknown route",
+			method:              "GET",
+			url:                 "/get/nothing",
+			requestID:           "req-1",
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"route_not_found\",\"message\":\"route not found\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:                "wrong method",
+			method:              "DELETE",
+			url:                 "/get/all",
+			requestID:           "req-1",
+			expectedStatusCode:  405,
+			expectedRequestBody: "{\"code\":\"method_not_allowed\",\"message\":\"method not allowed\",\"request_id\":\"req-1\"}\n",
+		},
+		{
+			name:                "request id is echoed",
+			method:              "GET",
+			url:                 "/get/nothing",
+			requestID:           "req-42",
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"route_not_found\",\"message\":\"route not found\",\"request_id\":\"req-42\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			h := handler.NewHandler(log, &repository.Repository{}, rates, metrics.New(nil), handler.Readiness{}, handlerConfig(t))
+
+			req := httptest.NewRequest(testCase.method, testCase.url, nil)
+			req.Header.Set("X-Request-ID", testCase.requestID)
+			w := httptest.NewRecorder()
+			h.InitRoutes().ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	etagHeader    = "ETag"
+	ifMatchHeader = "If-Match"
+)
+
+var errMalformedETag = errors.New("malformed entity tag")
+
+// ifMatch returns the account version a mutation is conditional on, taken
+// from an If-Match header holding an ETag served with the account. Without the
+// header, or with "*", the mutation is unconditional and 0 is returned.
+func ifMatch(r *http.Request) (version int64, err error) {
+	value := strings.TrimSpace(r.Header.Get(ifMatchHeader))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return 0, invalidParameter(ifMatchHeader, "an ETag of the account", errMalformedETag)
+	}
+	version, err = strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, invalidParameter(ifMatchHeader, "an ETag of the account", errMalformedETag)
+	}
+
+	return version, nil
+}
+
+func setETag(w http.ResponseWriter, account *models.Account) {
+	w.Header().Set(etagHeader, account.ETag())
+}
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"avito-tech/internal/config"
+	"avito-tech/internal/metrics"
 	"avito-tech/internal/repository"
+	"avito-tech/pkg/exchange"
 	"avito-tech/pkg/logger"
+	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -12,20 +17,75 @@ type Handler struct {
 	repository         *repository.Repository
 	accountHandler     *accountHandler
 	transactionHandler *transactionHandler
+	reservationHandler *reservationHandler
+	idempotencyHandler *idempotencyHandler
+	authHandler        *authHandler
+	reportHandler      *reportHandler
+	scheduleHandler    *scheduleHandler
+	healthHandler      *healthHandler
+	metrics            *metrics.Metrics
+	requestTimeout     time.Duration
 }
 
-func NewHandler(logger logger.Logger, repository *repository.Repository) *Handler {
+const metricsRoute = "/metrics"
+
+// Config holds the sections of the service configuration the handlers are
+// set up from.
+type Config struct {
+	Server      config.Server
+	Idempotency config.Idempotency
+	Reports     config.Reports
+	Auth        config.Auth
+}
+
+func NewHandler(logger logger.Logger, repository *repository.Repository, rates exchange.RateProvider, metrics *metrics.Metrics, readiness Readiness, config Config) *Handler {
 	return &Handler{
 		logger:             logger,
 		repository:         repository,
-		accountHandler:     NewAccountHandler(logger, repository.Account),
+		accountHandler:     NewAccountHandler(logger, repository.Account, rates),
 		transactionHandler: NewTransactionHandler(logger, repository.TransactionHistory),
+		reservationHandler: NewReservationHandler(logger, repository.Reservation),
+		idempotencyHandler: NewIdempotencyHandler(logger, repository.Idempotency, config.Idempotency.KeyTTL, config.Server.RequestTimeout),
+		authHandler:        NewAuthHandler(logger, repository.APIClients, config.Auth.SigningKey),
+		reportHandler:      NewReportHandler(logger, repository.Report, config.Reports.Dir, config.Reports.BaseURL),
+		scheduleHandler:    NewScheduleHandler(logger, repository.ScheduledTransfers),
+		healthHandler:      NewHealthHandler(logger, readiness),
+		metrics:            metrics,
+		requestTimeout:     config.Server.RequestTimeout,
 	}
 }
 
 func (h *Handler) InitRoutes() *mux.Router {
 	router := mux.NewRouter()
+	// mux runs middleware on matched routes only, so unmatched requests are
+	// given their id and logged by wrapping these handlers.
+	requestLog := RequestLog(h.logger)
+	router.NotFoundHandler = requestLog(http.HandlerFunc(h.notFound))
+	router.MethodNotAllowedHandler = requestLog(http.HandlerFunc(h.methodNotAllowed))
+	router.Use(requestLog)
+	router.Use(h.metrics.Middleware)
+	router.Use(Deadline(h.requestTimeout))
+	router.Use(h.authHandler.Middleware)
+	router.Use(h.idempotencyHandler.Middleware)
 	h.accountHandler.Register(router)
 	h.transactionHandler.Register(router)
+	h.reservationHandler.Register(router)
+	h.reportHandler.Register(router)
+	h.scheduleHandler.Register(router)
+	h.healthHandler.Register(router)
+	router.Handle(metricsRoute, h.metrics.Handler()).Methods("GET")
 	return router
 }
+
+// Drain fails readiness checks from now on, see healthHandler.Drain.
+func (h *Handler) Drain() {
+	h.healthHandler.Drain()
+}
+
+func (h *Handler) notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, h.logger, "routing request", newAPIError(http.StatusNotFound, codeRouteNotFound, "route not found", nil, nil))
+}
+
+func (h *Handler) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, h.logger, "routing request", newAPIError(http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed", nil, nil))
+}
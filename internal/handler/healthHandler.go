@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/exchange"
+	"avito-tech/pkg/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	healthz = "/healthz"
+	readyz  = "/readyz"
+
+	// rateCheckTo is the currency the rates check converts the default
+	// currency into.
+	rateCheckTo = "USD"
+)
+
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type MigrationVersion interface {
+	Version(ctx context.Context) (current, latest int64, err error)
+}
+
+// Readiness are the dependencies /readyz checks, each within Timeout. Rates is
+// optional: when set its state is reported, but a failing rate provider does
+// not make the service unready, as only conversions depend on it.
+type Readiness struct {
+	DB         Pinger
+	Migrations MigrationVersion
+	Rates      exchange.RateProvider
+	Timeout    time.Duration
+}
+
+type healthHandler struct {
+	logger    logger.Logger
+	readiness Readiness
+	draining  int32
+}
+
+func NewHealthHandler(logger logger.Logger, readiness Readiness) *healthHandler {
+	return &healthHandler{
+		logger:    logger,
+		readiness: readiness,
+	}
+}
+
+func (hh *healthHandler) Register(router *mux.Router) {
+	router.HandleFunc(healthz, hh.healthz).Methods("GET")
+	router.HandleFunc(readyz, hh.readyz).Methods("GET")
+}
+
+// Drain makes /readyz fail from now on, so load balancers stop sending
+// traffic before the server shuts down.
+func (hh *healthHandler) Drain() {
+	atomic.StoreInt32(&hh.draining, 1)
+}
+
+// healthz reports that the process is alive. It checks nothing else, so a
+// database outage does not get the service restarted.
+func (hh *healthHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &models.HealthReport{Status: models.HealthOK})
+}
+
+func (hh *healthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&hh.draining) == 1 {
+		writeHealth(w, http.StatusServiceUnavailable, &models.HealthReport{Status: models.HealthDraining})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), hh.readiness.Timeout)
+	defer cancel()
+
+	report := &models.HealthReport{Status: models.HealthOK, Checks: map[string]models.HealthCheck{}}
+	report.Checks["database"] = hh.checkDatabase(ctx)
+	report.Checks["migrations"] = hh.checkMigrations(ctx)
+	if hh.readiness.Rates != nil {
+		report.Checks["exchange_rates"] = hh.checkRates(ctx)
+	}
+
+	for _, check := range report.Checks {
+		if check.Status != models.HealthOK && !check.Optional {
+			report.Status = models.HealthDown
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status != models.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, report)
+}
+
+func (hh *healthHandler) checkDatabase(ctx context.Context) models.HealthCheck {
+	if err := hh.readiness.DB.PingContext(ctx); err != nil {
+		hh.logger.Warnf("readiness: database is down. err:%s ", err)
+		return models.HealthCheck{Status: models.HealthDown}
+	}
+	return models.HealthCheck{Status: models.HealthOK}
+}
+
+// checkMigrations fails while migrations known to this build are pending, as
+// the queries of this build may need them.
+func (hh *healthHandler) checkMigrations(ctx context.Context) models.HealthCheck {
+	current, latest, err := hh.readiness.Migrations.Version(ctx)
+	if err != nil {
+		hh.logger.Warnf("readiness: cannot read the migration version. err:%s ", err)
+		return models.HealthCheck{Status: models.HealthDown, Latest: latest}
+	}
+
+	check := models.HealthCheck{Status: models.HealthOK, Version: current, Latest: latest}
+	if current < latest {
+		check.Status = models.HealthDown
+	}
+	return check
+}
+
+// checkRates asks for a rate, giving up at the readiness deadline.
+func (hh *healthHandler) checkRates(ctx context.Context) models.HealthCheck {
+	if _, err := hh.readiness.Rates.Rate(ctx, models.DefaultCurrency, rateCheckTo); err != nil {
+		hh.logger.Warnf("readiness: exchange rates are unavailable. err:%s ", err)
+		return models.HealthCheck{Status: models.HealthDown, Optional: true}
+	}
+	return models.HealthCheck{Status: models.HealthOK, Optional: true}
+}
+
+func writeHealth(w http.ResponseWriter, status int, report *models.HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,138 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/metrics"
+	"avito-tech/internal/repository"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pinger struct{ err error }
+
+func (p pinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+// hangingPinger answers only when the readiness timeout runs out.
+type hangingPinger struct{}
+
+func (hangingPinger) PingContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type migrations struct {
+	current, latest int64
+	err             error
+}
+
+func (m migrations) Version(ctx context.Context) (int64, int64, error) {
+	return m.current, m.latest, m.err
+}
+
+// hangingRates answers only when the readiness timeout runs out.
+type hangingRates struct{}
+
+func (hangingRates) Rate(ctx context.Context, from, to string) (float64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func Test_readyz(t *testing.T) {
+	testTable := []struct {
+		name                string
+		readiness           handler.Readiness
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name:                "ready",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{current: 8, latest: 8}},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"status\":\"ok\",\"checks\":{\"database\":{\"status\":\"ok\"},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+		{
+			name:                "database down",
+			readiness:           handler.Readiness{DB: pinger{err: errors.New("connection refused")}, Migrations: migrations{current: 8, latest: 8}},
+			expectedStatusCode:  503,
+			expectedRequestBody: "{\"status\":\"down\",\"checks\":{\"database\":{\"status\":\"down\"},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+		{
+			name:                "database timeout",
+			readiness:           handler.Readiness{DB: hangingPinger{}, Migrations: migrations{current: 8, latest: 8}},
+			expectedStatusCode:  503,
+			expectedRequestBody: "{\"status\":\"down\",\"checks\":{\"database\":{\"status\":\"down\"},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+		{
+			name:                "pending migrations",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{current: 7, latest: 8}},
+			expectedStatusCode:  503,
+			expectedRequestBody: "{\"status\":\"down\",\"checks\":{\"database\":{\"status\":\"ok\"},\"migrations\":{\"status\":\"down\",\"version\":7,\"latest\":8}}}\n",
+		},
+		{
+			name:                "migrations unreadable",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{latest: 8, err: errors.New("connection refused")}},
+			expectedStatusCode:  503,
+			expectedRequestBody: "{\"status\":\"down\",\"checks\":{\"database\":{\"status\":\"ok\"},\"migrations\":{\"status\":\"down\",\"latest\":8}}}\n",
+		},
+		{
+			name:                "rates up",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{current: 8, latest: 8}, Rates: rates},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"status\":\"ok\",\"checks\":{\"database\":{\"status\":\"ok\"},\"exchange_rates\":{\"status\":\"ok\",\"optional\":true},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+		{
+			name:                "rates down",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{current: 8, latest: 8}, Rates: unavailableRates{}},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"status\":\"ok\",\"checks\":{\"database\":{\"status\":\"ok\"},\"exchange_rates\":{\"status\":\"down\",\"optional\":true},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+		{
+			name:                "rates timeout",
+			readiness:           handler.Readiness{DB: pinger{}, Migrations: migrations{current: 8, latest: 8}, Rates: hangingRates{}},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"status\":\"ok\",\"checks\":{\"database\":{\"status\":\"ok\"},\"exchange_rates\":{\"status\":\"down\",\"optional\":true},\"migrations\":{\"status\":\"ok\",\"version\":8,\"latest\":8}}}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			readiness := testCase.readiness
+			readiness.Timeout = 50 * time.Millisecond
+			h := handler.NewHandler(log, &repository.Repository{}, rates, metrics.New(nil), readiness, handlerConfig(t))
+
+			w := httptest.NewRecorder()
+			h.InitRoutes().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
+
+func Test_drain(t *testing.T) {
+	readiness := handler.Readiness{DB: pinger{}, Migrations: migrations{current: 8, latest: 8}, Timeout: time.Second}
+	h := handler.NewHandler(log, &repository.Repository{}, rates, metrics.New(nil), readiness, handlerConfig(t))
+	router := h.InitRoutes()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+
+	h.Drain()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "{\"status\":\"draining\"}\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"status\":\"ok\"}\n", w.Body.String())
+}
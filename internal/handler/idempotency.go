@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/logger"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	idempotencySaveTimeout  = 5 * time.Second
+)
+
+type idempotencyHandler struct {
+	logger         logger.Logger
+	repo           repository.Idempotency
+	ttl            time.Duration
+	requestTimeout time.Duration
+}
+
+// NewIdempotencyHandler keeps responses for ttl. Keys claimed by requests
+// that never stored a response are taken over once such a request would have
+// timed out; a zero requestTimeout leaves them claimed until they expire.
+func NewIdempotencyHandler(logger logger.Logger, repo repository.Idempotency, ttl, requestTimeout time.Duration) *idempotencyHandler {
+	return &idempotencyHandler{
+		logger:         logger,
+		repo:           repo,
+		ttl:            ttl,
+		requestTimeout: requestTimeout,
+	}
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key header execute at
+// most once per client and key: a retry of the same request gets the stored
+// response back, a retry of a different one gets 409.
+func (ih *idempotencyHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, r, ih.logger, "checking idempotency key", newAPIError(http.StatusBadRequest, codeValidationFailed,
+				fmt.Sprintf("idempotency key must be at most %d characters", maxIdempotencyKeyLength), nil, nil))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+		if err != nil {
+			writeError(w, r, ih.logger, "reading body", newAPIError(http.StatusRequestEntityTooLarge, codeRequestTooLarge,
+				fmt.Sprintf("request bodies sent with an idempotency key are limited to %d bytes", maxSignedBodySize), nil, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var keyID string
+		if client := clientFrom(r.Context()); client != nil {
+			keyID = client.KeyID
+		}
+
+		now := time.Now()
+		record := &models.IdempotencyKey{
+			KeyID:       keyID,
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ih.ttl),
+		}
+
+		claimed, err := ih.repo.Claim(r.Context(), record, ih.staleBefore(now))
+		if err != nil {
+			writeError(w, r, ih.logger, "checking idempotency key", err)
+			return
+		}
+
+		if !claimed {
+			ih.replay(w, r, record)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The outcome is recorded even when the request context is already
+		// done, otherwise the key would stay claimed until it expires. Only
+		// the log fields of the request are kept.
+		ctx, cancel := context.WithTimeout(logger.NewContext(context.Background(), logger.FieldsFromContext(r.Context())), idempotencySaveTimeout)
+		defer cancel()
+
+		// Server errors are not stored, so the client can retry them with the same key.
+		if rec.statusCode >= http.StatusInternalServerError {
+			if err := ih.repo.Release(ctx, keyID, key); err != nil {
+				ih.logger.WithContext(ctx).Errorf("error occurred while releasing idempotency key. err:%s ", err)
+			}
+			return
+		}
+
+		record.StatusCode = rec.statusCode
+		record.Response = rec.body.Bytes()
+		if err := ih.repo.SaveResponse(ctx, record); err != nil {
+			ih.logger.WithContext(ctx).Errorf("error occurred while saving idempotent response. err:%s ", err)
+		}
+	})
+}
+
+func (ih *idempotencyHandler) replay(w http.ResponseWriter, r *http.Request, record *models.IdempotencyKey) {
+	inProgress := newAPIError(http.StatusConflict, codeRequestInProgress, "request with this idempotency key is still in progress", nil, nil)
+
+	stored, err := ih.repo.GetByKey(r.Context(), record.KeyID, record.Key)
+	if err != nil {
+		if errors.Is(err, repository.ErrIdempotencyKeyNotFound) {
+			writeError(w, r, ih.logger, "checking idempotency key", inProgress)
+			return
+		}
+		writeError(w, r, ih.logger, "checking idempotency key", err)
+		return
+	}
+
+	if stored.RequestHash != record.RequestHash {
+		writeError(w, r, ih.logger, "checking idempotency key",
+			newAPIError(http.StatusConflict, codeIdempotencyConflict, "idempotency key was already used with a different request", nil, nil))
+		return
+	}
+
+	if stored.StatusCode == 0 {
+		writeError(w, r, ih.logger, "checking idempotency key", inProgress)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Response)
+}
+
+// staleBefore returns the time before which a claimed key without a response
+// was claimed by a request that is no longer running: one that timed out and
+// had as long again to store its response.
+func (ih *idempotencyHandler) staleBefore(now time.Time) time.Time {
+	if ih.requestTimeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-ih.requestTimeout - idempotencySaveTimeout)
+}
+
+// requestHash covers everything a request is executed with: the method, the
+// path with its query string, the If-Match precondition and the body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get(ifMatchHeader))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	rr.statusCode = statusCode
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
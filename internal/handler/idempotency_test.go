@@ -0,0 +1,214 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository/mock_repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_idempotency(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockIdempotency)
+
+	const body = `{"account_id": 1, "amount": 10, "comment": "Credit payment"}`
+
+	// hashOf lets a test learn the hash the middleware stores for a request.
+	hashOf := func(s *mock_repository.MockIdempotency) *string {
+		hash := new(string)
+		s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key *models.IdempotencyKey, _ time.Time) (bool, error) {
+			*hash = key.RequestHash
+			return true, nil
+		})
+		s.EXPECT().SaveResponse(gomock.Any(), gomock.Any()).Return(nil)
+		return hash
+	}
+
+	testTable := []struct {
+		name, key, inputBody string
+		url, ifMatch         string
+		primed               bool
+		mockBehavior         mockBehavior
+		expectedCalls        int
+		expectedStatusCode   int
+		expectedRequestBody  string
+	}{
+		{
+			name:      "no key",
+			inputBody: body,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+			},
+			expectedCalls:       1,
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"done\"\n",
+		},
+		{
+			name:      "first request",
+			key:       "key-1",
+			inputBody: body,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key *models.IdempotencyKey, staleBefore time.Time) (bool, error) {
+					// claims older than the request timeout plus the time to save a response are stale.
+					assert.Equal(t, 13*time.Second, key.CreatedAt.Sub(staleBefore))
+					return true, nil
+				})
+				s.EXPECT().SaveResponse(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key *models.IdempotencyKey) error {
+					assert.Equal(t, "key-1", key.Key)
+					assert.Equal(t, 200, key.StatusCode)
+					assert.Equal(t, "\"done\"\n", string(key.Response))
+					assert.Equal(t, 24*time.Hour, key.ExpiresAt.Sub(key.CreatedAt))
+					return nil
+				})
+			},
+			expectedCalls:       1,
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"done\"\n",
+		},
+		{
+			name:      "replayed request",
+			key:       "key-1",
+			inputBody: body,
+			primed:    true,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				hash := hashOf(s)
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				s.EXPECT().GetByKey(gomock.Any(), "", "key-1").DoAndReturn(func(_ context.Context, keyID, key string) (*models.IdempotencyKey, error) {
+					return &models.IdempotencyKey{Key: key, RequestHash: *hash, StatusCode: 200, Response: []byte("\"done\"\n")}, nil
+				})
+			},
+			expectedCalls:       0,
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"done\"\n",
+		},
+		{
+			name:      "same key, different body",
+			key:       "key-1",
+			inputBody: body,
+			primed:    true,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				hashOf(s)
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				s.EXPECT().GetByKey(gomock.Any(), "", "key-1").Return(&models.IdempotencyKey{Key: "key-1", RequestHash: "other", StatusCode: 200}, nil)
+			},
+			expectedCalls:       0,
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"idempotency_conflict\",\"message\":\"idempotency key was already used with a different request\"}\n",
+		},
+		{
+			name:      "same key, different query",
+			key:       "key-1",
+			inputBody: body,
+			url:       "/changeBalance?dry_run=true",
+			primed:    true,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				hash := hashOf(s)
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				s.EXPECT().GetByKey(gomock.Any(), "", "key-1").DoAndReturn(func(_ context.Context, keyID, key string) (*models.IdempotencyKey, error) {
+					return &models.IdempotencyKey{Key: key, RequestHash: *hash, StatusCode: 200}, nil
+				})
+			},
+			expectedCalls:       0,
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"idempotency_conflict\",\"message\":\"idempotency key was already used with a different request\"}\n",
+		},
+		{
+			name:      "same key, different If-Match",
+			key:       "key-1",
+			inputBody: body,
+			ifMatch:   `"3"`,
+			primed:    true,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				hash := hashOf(s)
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				s.EXPECT().GetByKey(gomock.Any(), "", "key-1").DoAndReturn(func(_ context.Context, keyID, key string) (*models.IdempotencyKey, error) {
+					return &models.IdempotencyKey{Key: key, RequestHash: *hash, StatusCode: 200}, nil
+				})
+			},
+			expectedCalls:       0,
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"idempotency_conflict\",\"message\":\"idempotency key was already used with a different request\"}\n",
+		},
+		{
+			name:      "server error releases key",
+			key:       "key-2",
+			inputBody: `{"fail": true}`,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+				s.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil)
+				s.EXPECT().Release(gomock.Any(), "", "key-2").Return(nil)
+			},
+			expectedCalls:       1,
+			expectedStatusCode:  500,
+			expectedRequestBody: "\"failed\"\n",
+		},
+		{
+			name:      "body too large",
+			key:       "key-3",
+			inputBody: `{"comment": "` + strings.Repeat("a", 1<<20) + `"}`,
+			mockBehavior: func(s *mock_repository.MockIdempotency) {
+			},
+			expectedStatusCode:  413,
+			expectedRequestBody: "{\"code\":\"request_too_large\",\"message\":\"request bodies sent with an idempotency key are limited to 1048576 bytes\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockIdempotency(c)
+			testCase.mockBehavior(rep)
+
+			calls := 0
+			router := mux.NewRouter()
+			router.Use(handler.NewIdempotencyHandler(log, rep, 24*time.Hour, 8*time.Second).Middleware)
+			router.HandleFunc("/changeBalance", func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				req := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&req)
+				if req["fail"] == true {
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode("failed")
+					return
+				}
+				json.NewEncoder(w).Encode("done")
+			}).Methods("POST")
+
+			// primed cases run the request once first to record its hash
+			if testCase.primed {
+				req := httptest.NewRequest("POST", "/changeBalance", bytes.NewBufferString(testCase.inputBody))
+				req.Header.Set("Idempotency-Key", testCase.key)
+				router.ServeHTTP(httptest.NewRecorder(), req)
+				calls = 0
+			}
+
+			url := testCase.url
+			if url == "" {
+				url = "/changeBalance"
+			}
+			req := httptest.NewRequest("POST", url, bytes.NewBufferString(testCase.inputBody))
+			if testCase.key != "" {
+				req.Header.Set("Idempotency-Key", testCase.key)
+			}
+			if testCase.ifMatch != "" {
+				req.Header.Set("If-Match", testCase.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, testCase.expectedCalls, calls)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
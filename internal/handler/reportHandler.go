@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/logger"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	revenueReport = "/report/revenue"
+	reportFile    = "/report/files/{name:revenue_[0-9]{4}_[0-9]{2}\\.csv}"
+
+	reportFileLink = "%s/report/files/%s"
+)
+
+type reportHandler struct {
+	logger     logger.Logger
+	reportRepo repository.Report
+	dir        string
+	baseURL    string
+}
+
+// NewReportHandler serves reports written to dir. Links to them start with
+// baseURL rather than the Host header, which the client controls.
+func NewReportHandler(logger logger.Logger, reportRepo repository.Report, dir, baseURL string) *reportHandler {
+	return &reportHandler{
+		logger:     logger,
+		reportRepo: reportRepo,
+		dir:        dir,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+	}
+}
+
+func (rh *reportHandler) Register(router *mux.Router) {
+	router.HandleFunc(revenueReport, rh.revenueReport).Methods("GET")
+	router.HandleFunc(reportFile, rh.reportFile).Methods("GET")
+}
+
+func (rh *reportHandler) revenueReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	year, err := strconv.Atoi(r.URL.Query().Get("year"))
+	if err != nil {
+		writeError(w, r, rh.logger, "parsing query", invalidParameter("year", "a number", err))
+		return
+	}
+	month, err := strconv.Atoi(r.URL.Query().Get("month"))
+	if err != nil {
+		writeError(w, r, rh.logger, "parsing query", invalidParameter("month", "a number", err))
+		return
+	}
+
+	req := &models.RevenueReportReq{
+		Year:  year,
+		Month: month,
+	}
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, rh.logger, "validating data", validationFailed(err))
+		return
+	}
+
+	name := fmt.Sprintf("revenue_%04d_%02d.csv", req.Year, req.Month)
+	err = rh.writeRevenueReport(r.Context(), req, name)
+	if err != nil {
+		writeError(w, r, rh.logger, "making revenue report", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.ReportLink{Link: fmt.Sprintf(reportFileLink, rh.baseURL, name)})
+}
+
+// writeRevenueReport streams the report into a temporary file and renames it
+// into place, so a download never sees a half-written report.
+func (rh *reportHandler) writeRevenueReport(ctx context.Context, req *models.RevenueReportReq, name string) (err error) {
+	err = os.MkdirAll(rh.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(rh.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	cw := csv.NewWriter(f)
+	err = cw.Write([]string{"service_id", "amount"})
+	if err != nil {
+		return err
+	}
+
+	from := time.Date(req.Year, time.Month(req.Month), 1, 0, 0, 0, 0, time.UTC)
+	err = rh.reportRepo.RevenueByService(ctx, from, from.AddDate(0, 1, 0), func(row *models.ServiceRevenue) error {
+		return cw.Write([]string{strconv.Itoa(row.ServiceID), row.Amount.String()})
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(rh.dir, name))
+}
+
+func (rh *reportHandler) reportFile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	path := filepath.Join(rh.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, r, rh.logger, "getting report file",
+			newAPIError(http.StatusNotFound, codeReportNotFound, fmt.Sprintf("report %s not found", name), nil, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,148 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository/mock_repository"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_revenueReport(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockReport)
+
+	from := time.Date(2022, 03, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 04, 1, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name, url           string
+		mockBehavior        mockBehavior
+		expectedStatusCode  int
+		expectedRequestBody string
+		expectedFile        string
+	}{
+		{
+			name: "ok",
+			url:  "/report/revenue?year=2022&month=3",
+			mockBehavior: func(s *mock_repository.MockReport) {
+				s.EXPECT().RevenueByService(gomock.Any(), from, to, gomock.Any()).DoAndReturn(func(_ context.Context, from, to time.Time, fn func(row *models.ServiceRevenue) error) error {
+					fn(&models.ServiceRevenue{ServiceID: 1, Amount: 1005000})
+					fn(&models.ServiceRevenue{ServiceID: 2, Amount: 70000})
+					return nil
+				})
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"link\":\"https://avito.example/report/files/revenue_2022_03.csv\"}\n",
+			expectedFile:        "service_id,amount\n1,100.50\n2,7.00\n",
+		},
+		{
+			name: "invalid month",
+			url:  "/report/revenue?year=2022&month=13",
+			mockBehavior: func(s *mock_repository.MockReport) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"month\":\"must be no greater than 12\"}}\n",
+		},
+		{
+			name: "missing year",
+			url:  "/report/revenue?month=3",
+			mockBehavior: func(s *mock_repository.MockReport) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"year must be a number\"}\n",
+		},
+		{
+			name: "db error",
+			url:  "/report/revenue?year=2022&month=3",
+			mockBehavior: func(s *mock_repository.MockReport) {
+				s.EXPECT().RevenueByService(gomock.Any(), from, to, gomock.Any()).Return(errors.New("connection refused"))
+			},
+			expectedStatusCode:  500,
+			expectedRequestBody: "{\"code\":\"internal_error\",\"message\":\"internal server error\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockReport(c)
+			testCase.mockBehavior(rep)
+
+			dir := t.TempDir()
+			handler := handler.NewReportHandler(log, rep, dir, "https://avito.example/")
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("GET", testCase.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*"))
+			if testCase.expectedFile == "" {
+				assert.Empty(t, files)
+				return
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "revenue_2022_03.csv"))
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedFile, string(b))
+			assert.Len(t, files, 1)
+		})
+	}
+}
+
+func Test_reportFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "revenue_2022_03.csv"), []byte("service_id,amount\n"), 0644))
+
+	handler := handler.NewReportHandler(log, nil, dir, "https://avito.example")
+	router := mux.NewRouter()
+	handler.Register(router)
+
+	testTable := []struct {
+		name, url           string
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name:                "ok",
+			url:                 "/report/files/revenue_2022_03.csv",
+			expectedStatusCode:  200,
+			expectedRequestBody: "service_id,amount\n",
+		},
+		{
+			name:                "not generated",
+			url:                 "/report/files/revenue_2022_04.csv",
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"report_not_found\",\"message\":\"report revenue_2022_04.csv not found\"}\n",
+		},
+		{
+			name:                "not a report",
+			url:                 "/report/files/secret.csv",
+			expectedStatusCode:  404,
+			expectedRequestBody: "404 page not found\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", testCase.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
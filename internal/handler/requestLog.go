@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"avito-tech/pkg/logger"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the ids taken from clients to what is safe to log and
+// echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestLog gives every request an id: the client's X-Request-ID when it
+// sends a usable one, a random one otherwise. The id is echoed in the response
+// and put in the request context, so everything logged for the request through
+// logger.WithContext carries it, down to the repositories. Once served, the
+// request is logged with its status, latency and response size.
+func RequestLog(log logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID.MatchString(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = logger.NewContext(ctx, logger.Fields{"request_id": id})
+			r = r.WithContext(ctx)
+
+			rec := &sizeRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			log.WithContext(ctx).WithFields(logger.Fields{
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"status":     rec.statusCode,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes":      rec.bytes,
+			}).Infof("%s %s %d", r.Method, r.URL.Path, rec.statusCode)
+		})
+	}
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+type sizeRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int
+}
+
+func (sr *sizeRecorder) WriteHeader(statusCode int) {
+	sr.statusCode = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *sizeRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
@@ -0,0 +1,63 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/pkg/logger"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RequestLog(t *testing.T) {
+	testTable := []struct {
+		name, requestID string
+		generated       bool
+	}{
+		{name: "client id", requestID: "req-42"},
+		{name: "no id", generated: true},
+		{name: "unsafe id", requestID: "req 42\n", generated: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log, err := logger.New(logger.Config{Format: logger.FormatJSON, Output: &out})
+			assert.NoError(t, err)
+
+			// stands in for a repository logging with the request context
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.WithContext(r.Context()).Infof("inside")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("created"))
+			})
+
+			req := httptest.NewRequest("POST", "/account", nil)
+			req.Header.Set("X-Request-ID", testCase.requestID)
+			w := httptest.NewRecorder()
+			handler.RequestLog(log)(next).ServeHTTP(w, req)
+
+			id := w.Header().Get("X-Request-ID")
+			if testCase.generated {
+				assert.Len(t, id, 32)
+			} else {
+				assert.Equal(t, testCase.requestID, id)
+			}
+
+			var inside, served map[string]interface{}
+			decoder := json.NewDecoder(&out)
+			assert.NoError(t, decoder.Decode(&inside))
+			assert.NoError(t, decoder.Decode(&served))
+			assert.Equal(t, id, inside["request_id"])
+			assert.Equal(t, id, served["request_id"])
+			assert.Equal(t, "POST", served["method"])
+			assert.Equal(t, "/account", served["path"])
+			assert.Equal(t, float64(201), served["status"])
+			assert.Equal(t, float64(7), served["bytes"])
+			assert.Contains(t, served, "latency_ms")
+		})
+	}
+}
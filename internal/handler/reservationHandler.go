@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	reserve        = "/reserve"
+	reserveConfirm = "/reserve/confirm"
+	reserveCancel  = "/reserve/cancel"
+)
+
+type reservationHandler struct {
+	logger  logger.Logger
+	resRepo repository.Reservation
+}
+
+func NewReservationHandler(logger logger.Logger, resRepo repository.Reservation) *reservationHandler {
+	return &reservationHandler{
+		logger:  logger,
+		resRepo: resRepo,
+	}
+}
+
+func (rh *reservationHandler) Register(router *mux.Router) {
+	router.HandleFunc(reserve, rh.reserve).Methods("POST")
+	router.HandleFunc(reserveConfirm, rh.confirm).Methods("POST")
+	router.HandleFunc(reserveCancel, rh.cancel).Methods("POST")
+}
+
+func (rh *reservationHandler) reserve(w http.ResponseWriter, r *http.Request) {
+	rh.handle(w, r, rh.resRepo.Reserve, "reserving money", "Money successfully reserved")
+}
+
+func (rh *reservationHandler) confirm(w http.ResponseWriter, r *http.Request) {
+	// confirming only charges money already held away from the account, so
+	// there is no account version to check.
+	confirm := func(ctx context.Context, res *models.Reservation) error {
+		if res.ExpectedVersion != 0 {
+			return invalidParameter(ifMatchHeader, "absent when confirming a reservation", nil)
+		}
+		return rh.resRepo.Confirm(ctx, res)
+	}
+	rh.handle(w, r, confirm, "confirming reservation", "Reservation successfully confirmed")
+}
+
+func (rh *reservationHandler) cancel(w http.ResponseWriter, r *http.Request) {
+	rh.handle(w, r, rh.resRepo.Cancel, "cancelling reservation", "Reservation successfully cancelled")
+}
+
+func (rh *reservationHandler) handle(w http.ResponseWriter, r *http.Request, action func(context.Context, *models.Reservation) error, operation, success string) {
+	w.Header().Set("Content-Type", "application/json")
+	req := &models.Reservation{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, r, rh.logger, "parsing json", invalidJSON(err))
+		return
+	}
+
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, rh.logger, "validating data", validationFailed(err))
+		return
+	}
+	req.ExpectedVersion, err = ifMatch(r)
+	if err != nil {
+		writeError(w, r, rh.logger, "getting if-match", err)
+		return
+	}
+
+	err = action(r.Context(), req)
+	if err != nil {
+		writeError(w, r, rh.logger, operation, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(success)
+}
@@ -0,0 +1,130 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/internal/repository/mock_repository"
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reservation(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockReservation, res *models.Reservation)
+
+	res := &models.Reservation{
+		AccountID: 1,
+		ServiceID: 2,
+		OrderID:   3,
+		Amount:    100000,
+	}
+
+	testTable := []struct {
+		name, url, inputBody string
+		ifMatch              string
+		mockBehavior         mockBehavior
+		expectedStatusCode   int
+		expectedRequestBody  string
+	}{
+		{
+			name:      "reserve ok",
+			url:       "/reserve",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+				s.EXPECT().Reserve(gomock.Any(), res).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Money successfully reserved\"\n",
+		},
+		{
+			name:      "reserve an order twice",
+			url:       "/reserve",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+				s.EXPECT().Reserve(gomock.Any(), res).Return(repository.ErrReservationExists)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"reservation_exists\",\"message\":\"order was already reserved\"}\n",
+		},
+		{
+			name:      "confirm ok",
+			url:       "/reserve/confirm",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+				s.EXPECT().Confirm(gomock.Any(), res).Return(nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "\"Reservation successfully confirmed\"\n",
+		},
+		{
+			name:      "confirm with If-Match",
+			url:       "/reserve/confirm",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			ifMatch:   `"4"`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"If-Match must be absent when confirming a reservation\"}\n",
+		},
+		{
+			name:      "cancel at stale version",
+			url:       "/reserve/cancel",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			ifMatch:   `"4"`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+				stale := *res
+				stale.ExpectedVersion = 4
+				s.EXPECT().Cancel(gomock.Any(), &stale).Return(repository.ErrVersionMismatch)
+			},
+			expectedStatusCode:  412,
+			expectedRequestBody: "{\"code\":\"precondition_failed\",\"message\":\"account was changed since the given version\"}\n",
+		},
+		{
+			name:      "cancel unknown reservation",
+			url:       "/reserve/cancel",
+			inputBody: `{"account_id": 1, "service_id": 2, "order_id": 3, "amount": 10}`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+				s.EXPECT().Cancel(gomock.Any(), res).Return(repository.ErrReservationNotFound)
+			},
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"reservation_not_found\",\"message\":\"reservation not found\"}\n",
+		},
+		{
+			name:      "order_id: cannot be blank",
+			url:       "/reserve",
+			inputBody: `{"account_id": 1, "service_id": 2, "amount": 10}`,
+			mockBehavior: func(s *mock_repository.MockReservation, res *models.Reservation) {
+			},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"order_id\":\"cannot be blank\"}}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockReservation(c)
+			testCase.mockBehavior(rep, res)
+
+			handler := handler.NewReservationHandler(log, rep)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("POST", testCase.url, bytes.NewBufferString(testCase.inputBody))
+			if testCase.ifMatch != "" {
+				req.Header.Set("If-Match", testCase.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
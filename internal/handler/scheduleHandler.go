@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/logger"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	schedules    = "/schedules"
+	schedule     = "/schedules/{id:[0-9]+}"
+	scheduleRuns = "/schedules/{id:[0-9]+}/runs"
+)
+
+type scheduleHandler struct {
+	logger    logger.Logger
+	schedRepo repository.ScheduledTransfers
+}
+
+func NewScheduleHandler(logger logger.Logger, schedRepo repository.ScheduledTransfers) *scheduleHandler {
+	return &scheduleHandler{
+		logger:    logger,
+		schedRepo: schedRepo,
+	}
+}
+
+func (sh *scheduleHandler) Register(router *mux.Router) {
+	router.HandleFunc(schedules, sh.create).Methods("POST")
+	router.HandleFunc(schedules, sh.list).Methods("GET")
+	router.HandleFunc(schedule, sh.get).Methods("GET")
+	router.HandleFunc(schedule, sh.update).Methods("PUT")
+	router.HandleFunc(schedule, sh.cancel).Methods("DELETE")
+	router.HandleFunc(scheduleRuns, sh.runs).Methods("GET")
+}
+
+func (sh *scheduleHandler) create(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	req, err := sh.parseSchedule(w, r)
+	if err != nil {
+		return
+	}
+
+	err = sh.schedRepo.CreateSchedule(r.Context(), req)
+	if err != nil {
+		writeError(w, r, sh.logger, "creating scheduled transfer", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req)
+}
+
+// list returns every schedule, or with account_id, the schedules sending from
+// or to that account.
+func (sh *scheduleHandler) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var accountID int
+	if err := intParam(r.URL.Query(), "account_id", &accountID); err != nil {
+		writeError(w, r, sh.logger, "parsing query", err)
+		return
+	}
+
+	schedules, err := sh.schedRepo.ListSchedules(r.Context(), accountID)
+	if err != nil {
+		writeError(w, r, sh.logger, "listing scheduled transfers", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func (sh *scheduleHandler) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, sh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+
+	sh.writeSchedule(w, r, id)
+}
+
+// update replaces an active schedule, which then starts over from its first
+// occurrence from now on.
+func (sh *scheduleHandler) update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, sh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+	req, err := sh.parseSchedule(w, r)
+	if err != nil {
+		return
+	}
+	req.ID = id
+
+	err = sh.schedRepo.UpdateSchedule(r.Context(), req)
+	if err != nil {
+		writeError(w, r, sh.logger, "updating scheduled transfer", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(req)
+}
+
+func (sh *scheduleHandler) cancel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, sh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+
+	err = sh.schedRepo.CancelSchedule(r.Context(), id)
+	if err != nil {
+		writeError(w, r, sh.logger, "cancelling scheduled transfer", err)
+		return
+	}
+
+	sh.writeSchedule(w, r, id)
+}
+
+// runs returns the latest runs of a schedule, newest first, at most limit.
+func (sh *scheduleHandler) runs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, sh.logger, "getting id", invalidParameter("id", "a number", err))
+		return
+	}
+	limit := models.DefaultRunsPageSize
+	if err = intParam(r.URL.Query(), "limit", &limit); err != nil {
+		writeError(w, r, sh.logger, "parsing query", err)
+		return
+	}
+	if limit < 1 || limit > models.MaxRunsPageSize {
+		writeError(w, r, sh.logger, "parsing query", invalidParameter("limit", "between 1 and "+strconv.Itoa(models.MaxRunsPageSize), nil))
+		return
+	}
+
+	runs, err := sh.schedRepo.ListRuns(r.Context(), id, limit)
+	if err != nil {
+		writeError(w, r, sh.logger, "listing scheduled transfer runs", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(runs)
+}
+
+// parseSchedule decodes and validates the schedule in the request body,
+// filling in the defaults. It writes the error response itself.
+func (sh *scheduleHandler) parseSchedule(w http.ResponseWriter, r *http.Request) (req *models.ScheduledTransfer, err error) {
+	req = &models.ScheduledTransfer{}
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, r, sh.logger, "parsing json", invalidJSON(err))
+		return nil, err
+	}
+
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, sh.logger, "validating data", validationFailed(err))
+		return nil, err
+	}
+
+	if req.Currency == "" {
+		req.Currency = models.DefaultCurrency
+	}
+	if req.OnInsufficientFunds == "" {
+		req.OnInsufficientFunds = models.OnInsufficientFundsSkip
+	}
+	return req, nil
+}
+
+// writeSchedule responds with the current state of the schedule.
+func (sh *scheduleHandler) writeSchedule(w http.ResponseWriter, r *http.Request, id int) {
+	schedule, err := sh.schedRepo.GetSchedule(r.Context(), id)
+	if err != nil {
+		writeError(w, r, sh.logger, "getting scheduled transfer", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schedule)
+}
@@ -0,0 +1,164 @@
+package handler_test
+
+import (
+	"avito-tech/internal/handler"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/internal/repository/mock_repository"
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_schedule(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockScheduledTransfers)
+
+	start := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	rent := func() *models.ScheduledTransfer {
+		return &models.ScheduledTransfer{SenderID: 3, ReceiverID: 7, Amount: 5000000, Currency: "RUB", Comment: "monthly rent",
+			Period: models.PeriodMonthly, StartAt: start, OnInsufficientFunds: models.OnInsufficientFundsSkip}
+	}
+	stored := func(status string) *models.ScheduledTransfer {
+		s := rent()
+		s.ID, s.Status, s.CreatedAt = 1, status, created
+		if status == models.ScheduleActive {
+			s.NextRunAt = &start
+		}
+		return s
+	}
+	body := `{"sender_id": 3, "receiver_id": 7, "amount": 500, "comment": "monthly rent", "period": "monthly", "start_at": "2022-04-01T00:00:00Z"}`
+	activeJSON := "{\"schedule_id\":1,\"sender_id\":3,\"receiver_id\":7,\"amount\":500.00,\"currency\":\"RUB\",\"comment\":\"monthly rent\",\"period\":\"monthly\",\"start_at\":\"2022-04-01T00:00:00Z\",\"on_insufficient_funds\":\"skip\",\"status\":\"active\",\"next_run_at\":\"2022-04-01T00:00:00Z\",\"attempts\":0,\"created_at\":\"2022-03-10T12:00:00Z\"}\n"
+
+	testTable := []struct {
+		name, method, url, inputBody string
+		mockBehavior                 mockBehavior
+		expectedStatusCode           int
+		expectedRequestBody          string
+	}{
+		{
+			name:      "create",
+			method:    "POST",
+			url:       "/schedules",
+			inputBody: body,
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().CreateSchedule(gomock.Any(), rent()).DoAndReturn(func(ctx context.Context, schedule *models.ScheduledTransfer) error {
+					*schedule = *stored(models.ScheduleActive)
+					return nil
+				})
+			},
+			expectedStatusCode:  201,
+			expectedRequestBody: activeJSON,
+		},
+		{
+			name:      "create for unknown account",
+			method:    "POST",
+			url:       "/schedules",
+			inputBody: body,
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().CreateSchedule(gomock.Any(), rent()).Return(repository.ErrUserDoesntExist)
+			},
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"account_not_found\",\"message\":\"user doesnt exist\"}\n",
+		},
+		{
+			name:                "create with unknown period",
+			method:              "POST",
+			url:                 "/schedules",
+			inputBody:           `{"sender_id": 3, "receiver_id": 7, "amount": 500, "comment": "monthly rent", "period": "yearly", "start_at": "2022-04-01T00:00:00Z"}`,
+			mockBehavior:        func(s *mock_repository.MockScheduledTransfers) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"period\":\"must be a valid value\"}}\n",
+		},
+		{
+			name:   "list by account",
+			method: "GET",
+			url:    "/schedules?account_id=3",
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().ListSchedules(gomock.Any(), 3).Return([]models.ScheduledTransfer{*stored(models.ScheduleActive)}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "[" + activeJSON[:len(activeJSON)-1] + "]\n",
+		},
+		{
+			name:   "get unknown",
+			method: "GET",
+			url:    "/schedules/2",
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().GetSchedule(gomock.Any(), 2).Return(nil, repository.ErrScheduleNotFound)
+			},
+			expectedStatusCode:  404,
+			expectedRequestBody: "{\"code\":\"schedule_not_found\",\"message\":\"scheduled transfer not found\"}\n",
+		},
+		{
+			name:      "update finished",
+			method:    "PUT",
+			url:       "/schedules/1",
+			inputBody: body,
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				update := rent()
+				update.ID = 1
+				s.EXPECT().UpdateSchedule(gomock.Any(), update).Return(repository.ErrScheduleNotActive)
+			},
+			expectedStatusCode:  409,
+			expectedRequestBody: "{\"code\":\"schedule_not_active\",\"message\":\"scheduled transfer is no longer active\"}\n",
+		},
+		{
+			name:   "cancel",
+			method: "DELETE",
+			url:    "/schedules/1",
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().CancelSchedule(gomock.Any(), 1).Return(nil)
+				s.EXPECT().GetSchedule(gomock.Any(), 1).Return(stored(models.ScheduleCancelled), nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"schedule_id\":1,\"sender_id\":3,\"receiver_id\":7,\"amount\":500.00,\"currency\":\"RUB\",\"comment\":\"monthly rent\",\"period\":\"monthly\",\"start_at\":\"2022-04-01T00:00:00Z\",\"on_insufficient_funds\":\"skip\",\"status\":\"cancelled\",\"attempts\":0,\"created_at\":\"2022-03-10T12:00:00Z\"}\n",
+		},
+		{
+			name:   "runs",
+			method: "GET",
+			url:    "/schedules/1/runs?limit=10",
+			mockBehavior: func(s *mock_repository.MockScheduledTransfers) {
+				s.EXPECT().ListRuns(gomock.Any(), 1, 10).Return([]models.ScheduledTransferRun{
+					{ID: 4, ScheduleID: 1, DueAt: start, RanAt: start, Attempt: 1, Amount: 5000000, Currency: "RUB", Status: models.RunSkipped, Error: "insufficient funds"},
+				}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "[{\"run_id\":4,\"schedule_id\":1,\"due_at\":\"2022-04-01T00:00:00Z\",\"ran_at\":\"2022-04-01T00:00:00Z\",\"attempt\":1,\"amount\":500.00,\"currency\":\"RUB\",\"status\":\"skipped\",\"error\":\"insufficient funds\"}]\n",
+		},
+		{
+			name:                "runs over the limit",
+			method:              "GET",
+			url:                 "/schedules/1/runs?limit=5000",
+			mockBehavior:        func(s *mock_repository.MockScheduledTransfers) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"limit must be between 1 and 1000\"}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockScheduledTransfers(c)
+			testCase.mockBehavior(rep)
+
+			handler := handler.NewScheduleHandler(log, rep)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest(testCase.method, testCase.url, bytes.NewBufferString(testCase.inputBody))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"avito-tech/internal/repository"
 	"avito-tech/pkg/logger"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -31,29 +33,31 @@ func (trh *transactionHandler) Register(router *mux.Router) {
 	router.HandleFunc(getTransactionsByAccountID, trh.getTransactionsByAccountID).Methods("GET")
 }
 
+// getTransactionsByAccountID serves a page of history selected by the query
+// string. Requests without a query string still take the old JSON body with
+// limit and offset.
 func (trh *transactionHandler) getTransactionsByAccountID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if r.URL.RawQuery != "" {
+		trh.getTransactionsPage(w, r)
+		return
+	}
+
 	req := &models.TransactionHistoryReq{}
 
 	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		trh.logger.Errorf("error occurred while parsing json. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while parsing json. err:%s ", err))
+		writeError(w, r, trh.logger, "parsing json", invalidJSON(err))
 		return
 	}
 	err = req.Validate()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		trh.logger.Errorf("error occurred while validating data. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while validating data. err:%s ", err))
+		writeError(w, r, trh.logger, "validating data", validationFailed(err))
 		return
 	}
-	transactions, err := trh.trRepo.GetByAccountID(req)
+	transactions, err := trh.trRepo.GetByAccountID(r.Context(), req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		trh.logger.Errorf("error occurred while getting transactions. err:%s ", err)
-		json.NewEncoder(w).Encode(fmt.Sprintf("error occurred while getting all transactions. err:%s ", err))
+		writeError(w, r, trh.logger, "getting transactions", err)
 		return
 	}
 
@@ -61,3 +65,121 @@ func (trh *transactionHandler) getTransactionsByAccountID(w http.ResponseWriter,
 	json.NewEncoder(w).Encode(transactions)
 
 }
+
+func (trh *transactionHandler) getTransactionsPage(w http.ResponseWriter, r *http.Request) {
+	req, err := parseHistoryPageReq(r.URL.Query())
+	if err != nil {
+		writeError(w, r, trh.logger, "parsing query", err)
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		writeError(w, r, trh.logger, "validating data", validationFailed(err))
+		return
+	}
+
+	page, err := trh.trRepo.GetPageByAccountID(r.Context(), req)
+	if err != nil {
+		writeError(w, r, trh.logger, "getting transactions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseHistoryPageReq(query url.Values) (req *models.TransactionHistoryPageReq, err error) {
+	req = &models.TransactionHistoryPageReq{
+		Limit:     models.DefaultHistoryPageSize,
+		Sort:      models.DefaultHistorySort,
+		Direction: query.Get("direction"),
+	}
+
+	if err = intParam(query, "account_id", &req.AccountID); err != nil {
+		return nil, err
+	}
+	if err = intParam(query, "limit", &req.Limit); err != nil {
+		return nil, err
+	}
+	if v := query.Get("sort"); v != "" {
+		if req.Sort, err = models.ParseSort(v); err != nil {
+			return nil, invalidParameter("sort", "columns to sort by, such as -date_time,amount", err)
+		}
+	}
+	if req.After, err = cursorParam(query, "after"); err != nil {
+		return nil, err
+	}
+	if req.Before, err = cursorParam(query, "before"); err != nil {
+		return nil, err
+	}
+	if req.From, err = timeParam(query, "from"); err != nil {
+		return nil, err
+	}
+	if req.To, err = timeParam(query, "to"); err != nil {
+		return nil, err
+	}
+	if req.MinAmount, err = moneyParam(query, "min_amount"); err != nil {
+		return nil, err
+	}
+	if req.MaxAmount, err = moneyParam(query, "max_amount"); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// intParam leaves dst unchanged when the parameter is missing.
+func intParam(query url.Values, name string, dst *int) error {
+	v := query.Get(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return invalidParameter(name, "a number", err)
+	}
+	*dst = n
+	return nil
+}
+
+func cursorParam(query url.Values, name string) (*models.HistoryCursor, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	cursor, err := models.ParseHistoryCursor(v)
+	if err != nil {
+		return nil, invalidParameter(name, "a cursor returned by a previous page", err)
+	}
+	return cursor, nil
+}
+
+// timeParam accepts a bare date, meaning its midnight in UTC, or a full
+// RFC 3339 time, which is returned in UTC.
+func timeParam(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, v)
+	}
+	if err != nil {
+		return nil, invalidParameter(name, "a date (2006-01-02) or an RFC 3339 time", err)
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+func moneyParam(query url.Values, name string) (*models.Money, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	amount, err := models.ParseMoney(v)
+	if err != nil {
+		return nil, invalidParameter(name, "an amount", err)
+	}
+	return &amount, nil
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
@@ -40,10 +41,10 @@ func Test_getTransactionsByAccountID(t *testing.T) {
 				OrderBy:   "date_time DESC",
 			},
 			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryReq) {
-				s.EXPECT().GetByAccountID(req).Return([]models.TransactionHistory{{TransactionID: 1, AccountID: 2, Amount: 22, Comment: "comment"}}, nil)
+				s.EXPECT().GetByAccountID(gomock.Any(), req).Return([]models.TransactionHistory{{TransactionID: 1, AccountID: 2, Amount: 220000, Comment: "comment"}}, nil)
 			},
 			expectedStatusCode:  200,
-			expectedRequestBody: "[{\"transaction_ID\":1,\"account_id\":2,\"amount\":22,\"date\":\"0001-01-01T00:00:00Z\",\"comment\":\"comment\"}]\n",
+			expectedRequestBody: "[{\"transaction_ID\":1,\"account_id\":2,\"amount\":22.00,\"date\":\"0001-01-01T00:00:00Z\",\"comment\":\"comment\"}]\n",
 		},
 		{
 			name: "Invalid JSON request",
@@ -57,7 +58,7 @@ func Test_getTransactionsByAccountID(t *testing.T) {
 			},
 
 			expectedStatusCode:  400,
-			expectedRequestBody: "\"error occurred while parsing json. err:invalid character 'o' looking for beginning of object key string \"\n",
+			expectedRequestBody: "{\"code\":\"invalid_json\",\"message\":\"request body is not valid JSON\",\"details\":\"invalid character 'o' looking for beginning of object key string\"}\n",
 		},
 		{
 			name: "no such acc",
@@ -74,11 +75,11 @@ func Test_getTransactionsByAccountID(t *testing.T) {
 				"order_by" : "date_time DESC"  
 			}`,
 			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryReq) {
-				s.EXPECT().GetByAccountID(req).Return(nil, errors.New("sql: no rows in result set"))
+				s.EXPECT().GetByAccountID(gomock.Any(), req).Return(nil, errors.New("sql: no rows in result set"))
 
 			},
 			expectedStatusCode:  500,
-			expectedRequestBody: "\"error occurred while getting all transactions. err:sql: no rows in result set \"\n",
+			expectedRequestBody: "{\"code\":\"internal_error\",\"message\":\"internal server error\"}\n",
 		},
 	}
 
@@ -102,3 +103,129 @@ func Test_getTransactionsByAccountID(t *testing.T) {
 		})
 	}
 }
+
+func Test_getTransactionsPage(t *testing.T) {
+	type mockBehavior func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq)
+
+	cursor := &models.HistoryCursor{Sort: "-date_time", Date: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), TransactionID: 7}
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	offsetFrom := time.Date(2023, 12, 31, 21, 0, 0, 0, time.UTC)
+	min := models.Money(105000)
+
+	testTable := []struct {
+		name, query         string
+		req                 *models.TransactionHistoryPageReq
+		mockBehavior        mockBehavior
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			name:  "first page",
+			query: "account_id=1",
+			req:   &models.TransactionHistoryPageReq{AccountID: 1, Limit: models.DefaultHistoryPageSize, Sort: models.DefaultHistorySort},
+			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {
+				s.EXPECT().GetPageByAccountID(gomock.Any(), req).Return(&models.TransactionHistoryPage{
+					Transactions: []models.TransactionHistory{{TransactionID: 8, AccountID: 1, Amount: 220000, Comment: "comment"}},
+					NextCursor:   "next",
+				}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transactions\":[{\"transaction_ID\":8,\"account_id\":1,\"amount\":22.00,\"date\":\"0001-01-01T00:00:00Z\",\"comment\":\"comment\"}],\"next_cursor\":\"next\"}\n",
+		},
+		{
+			name:  "filters",
+			query: "account_id=1&limit=10&after=" + cursor.String() + "&from=2022-03-01&to=2022-04-01T12:00:00Z&min_amount=10.5&direction=debit",
+			req: &models.TransactionHistoryPageReq{AccountID: 1, Limit: 10, Sort: models.DefaultHistorySort, After: cursor, From: &from, To: &to,
+				MinAmount: &min, Direction: models.DirectionDebit},
+			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {
+				s.EXPECT().GetPageByAccountID(gomock.Any(), req).Return(&models.TransactionHistoryPage{Transactions: []models.TransactionHistory{}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transactions\":[]}\n",
+		},
+		{
+			name:  "time with an offset",
+			query: "account_id=1&from=2024-01-01T00:00:00%2B03:00",
+			req:   &models.TransactionHistoryPageReq{AccountID: 1, Limit: models.DefaultHistoryPageSize, Sort: models.DefaultHistorySort, From: &offsetFrom},
+			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {
+				s.EXPECT().GetPageByAccountID(gomock.Any(), req).Return(&models.TransactionHistoryPage{Transactions: []models.TransactionHistory{}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transactions\":[]}\n",
+		},
+		{
+			name:  "sorted",
+			query: "account_id=1&sort=amount,-date_time",
+			req: &models.TransactionHistoryPageReq{AccountID: 1, Limit: models.DefaultHistoryPageSize,
+				Sort: models.Sort{{Column: "amount"}, {Column: "date_time", Desc: true}}},
+			mockBehavior: func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {
+				s.EXPECT().GetPageByAccountID(gomock.Any(), req).Return(&models.TransactionHistoryPage{Transactions: []models.TransactionHistory{}}, nil)
+			},
+			expectedStatusCode:  200,
+			expectedRequestBody: "{\"transactions\":[]}\n",
+		},
+		{
+			name:                "sort column not allowed",
+			query:               "account_id=1&sort=-comment",
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"sort must be columns to sort by, such as -date_time,amount\"}\n",
+		},
+		{
+			name:                "cursor of another sort",
+			query:               "account_id=1&sort=amount&after=" + cursor.String(),
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"after\":\"belongs to a different sort\"}}\n",
+		},
+		{
+			name:                "invalid cursor",
+			query:               "account_id=1&after=garbage",
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"after must be a cursor returned by a previous page\"}\n",
+		},
+		{
+			name:                "invalid date",
+			query:               "account_id=1&from=yesterday",
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"invalid_parameter\",\"message\":\"from must be a date (2006-01-02) or an RFC 3339 time\"}\n",
+		},
+		{
+			name:                "both cursors",
+			query:               "account_id=1&after=" + cursor.String() + "&before=" + cursor.String(),
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"before\":\"cannot be combined with after\"}}\n",
+		},
+		{
+			name:                "no account",
+			query:               "limit=5",
+			mockBehavior:        func(s *mock_repository.MockTransactionHistory, req *models.TransactionHistoryPageReq) {},
+			expectedStatusCode:  400,
+			expectedRequestBody: "{\"code\":\"validation_failed\",\"message\":\"request validation failed\",\"details\":{\"account_id\":\"cannot be blank\"}}\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			rep := mock_repository.NewMockTransactionHistory(c)
+			testCase.mockBehavior(rep, testCase.req)
+
+			handler := handler.NewTransactionHandler(log, rep)
+			router := mux.NewRouter()
+			handler.Register(router)
+
+			req := httptest.NewRequest("GET", "/get/transactions?"+testCase.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedStatusCode, w.Code)
+			assert.Equal(t, testCase.expectedRequestBody, w.Body.String())
+		})
+	}
+}
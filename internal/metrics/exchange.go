@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"avito-tech/pkg/exchange"
+	"context"
+	"time"
+)
+
+type rateProvider struct {
+	next exchange.RateProvider
+	m    *Metrics
+}
+
+// InstrumentRates times the rate requests of an upstream provider and counts
+// its failures. It belongs below any cache, so that only real upstream calls
+// are measured.
+func (m *Metrics) InstrumentRates(next exchange.RateProvider) exchange.RateProvider {
+	return &rateProvider{next: next, m: m}
+}
+
+func (p *rateProvider) Rate(ctx context.Context, from, to string) (rate float64, err error) {
+	start := time.Now()
+	rate, err = p.next.Rate(ctx, from, to)
+	p.m.rateDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		p.m.rateErrors.Inc()
+	}
+	return rate, err
+}
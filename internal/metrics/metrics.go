@@ -0,0 +1,146 @@
+// Package metrics instruments the service for Prometheus: HTTP requests,
+// repository operations, money movements, exchange rate lookups and the
+// database connection pool.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "avitotech"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	operationDuration *prometheus.HistogramVec
+	businessErrors    *prometheus.CounterVec
+
+	transfers           *prometheus.CounterVec
+	transferredAmount   *prometheus.CounterVec
+	balanceChanges      *prometheus.CounterVec
+	balanceChangeAmount *prometheus.CounterVec
+	refunds             *prometheus.CounterVec
+	refundedAmount      *prometheus.CounterVec
+
+	scheduledRuns *prometheus.CounterVec
+
+	rateDuration prometheus.Histogram
+	rateErrors   prometheus.Counter
+}
+
+// New registers every metric in a registry of its own, together with the Go
+// runtime and process metrics and, when db is not nil, its pool stats.
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_operation_duration_seconds",
+			Help:      "Repository operation latency by operation and result: ok, business_error or error.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+		businessErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "business_errors_total",
+			Help:      "Operations refused by business rules, such as insufficient funds.",
+		}, []string{"operation", "reason"}),
+
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfers_total",
+			Help:      "Completed transfers between accounts by sender currency.",
+		}, []string{"currency"}),
+		transferredAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transferred_amount_total",
+			Help:      "Money sent in completed transfers by sender currency.",
+		}, []string{"currency"}),
+		balanceChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "balance_changes_total",
+			Help:      "Completed deposits and withdrawals.",
+		}, []string{"currency", "direction"}),
+		balanceChangeAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "balance_change_amount_total",
+			Help:      "Money deposited and withdrawn.",
+		}, []string{"currency", "direction"}),
+		refunds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "refunds_total",
+			Help:      "Completed refunds of transfers by sender currency.",
+		}, []string{"currency"}),
+		refundedAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "refunded_amount_total",
+			Help:      "Money returned to senders by refunds by sender currency.",
+		}, []string{"currency"}),
+
+		scheduledRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scheduled_transfer_runs_total",
+			Help:      "Runs of scheduled transfers by status: succeeded, retrying or skipped.",
+		}, []string{"status"}),
+
+		rateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "exchange_rate_request_duration_seconds",
+			Help:      "Latency of exchange rate requests to the upstream API.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		rateErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exchange_rate_errors_total",
+			Help:      "Failed exchange rate requests to the upstream API.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.operationDuration,
+		m.businessErrors,
+		m.transfers,
+		m.transferredAmount,
+		m.balanceChanges,
+		m.balanceChangeAmount,
+		m.refunds,
+		m.refundedAmount,
+		m.scheduledRuns,
+		m.rateDuration,
+		m.rateErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
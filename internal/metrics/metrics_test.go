@@ -0,0 +1,151 @@
+package metrics_test
+
+import (
+	"avito-tech/internal/metrics"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/internal/repository/mock_repository"
+	"avito-tech/pkg/exchange"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape returns the metrics as Prometheus would read them.
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func Test_Middleware(t *testing.T) {
+	m := metrics.New(nil)
+	router := mux.NewRouter()
+	router.Use(m.Middleware)
+	router.HandleFunc("/get/balance/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods("GET")
+
+	for _, url := range []string{"/get/balance/1", "/get/balance/2", "/get/balance/0"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `avitotech_http_requests_total{method="GET",route="/get/balance/{id:[0-9]+}",status="200"} 2`)
+	assert.Contains(t, body, `avitotech_http_requests_total{method="GET",route="/get/balance/{id:[0-9]+}",status="404"} 1`)
+	assert.Contains(t, body, `avitotech_http_request_duration_seconds_count{method="GET",route="/get/balance/{id:[0-9]+}",status="200"} 2`)
+}
+
+func Test_Instrument(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	accounts := mock_repository.NewMockAccount(c)
+	reservations := mock_repository.NewMockReservation(c)
+	m := metrics.New(nil)
+	repo := m.Instrument(&repository.Repository{Account: accounts, Reservation: reservations})
+	ctx := context.Background()
+
+	deposit := &models.AccountDebit{AccountID: 1, Amount: 105000, Currency: "RUB"}
+	withdrawal := &models.AccountDebit{AccountID: 1, Amount: -25000, Currency: "RUB"}
+	tr := &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 30000, Currency: "USD"}
+	accounts.EXPECT().ChangeBalance(ctx, deposit).Return(nil).Times(2)
+	accounts.EXPECT().ChangeBalance(ctx, withdrawal).Return(nil)
+	accounts.EXPECT().MoneyTransaction(ctx, tr).Return(nil)
+	accounts.EXPECT().MoneyTransaction(ctx, tr).Return(&repository.InsufficientFundsError{AccountID: 1, Currency: "USD"})
+	reservations.EXPECT().Cancel(ctx, gomock.Any()).Return(repository.ErrReservationNotFound)
+	accounts.EXPECT().GetAll(ctx).Return(nil, context.Canceled)
+	accounts.EXPECT().Refund(ctx, &models.Refund{TransactionID: 4}).DoAndReturn(func(ctx context.Context, refund *models.Refund) error {
+		refund.Amount, refund.Currency = 30000, "USD"
+		return nil
+	})
+	accounts.EXPECT().Refund(ctx, &models.Refund{TransactionID: 5}).Return(repository.ErrRefundExceedsAmount)
+
+	assert.NoError(t, repo.ChangeBalance(ctx, deposit))
+	assert.NoError(t, repo.ChangeBalance(ctx, deposit))
+	assert.NoError(t, repo.ChangeBalance(ctx, withdrawal))
+	assert.NoError(t, repo.MoneyTransaction(ctx, tr))
+	assert.ErrorIs(t, repo.MoneyTransaction(ctx, tr), repository.ErrInsufficientFunds)
+	assert.Equal(t, repository.ErrReservationNotFound, repo.Cancel(ctx, &models.Reservation{}))
+	_, err := repo.GetAll(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.NoError(t, repo.Refund(ctx, &models.Refund{TransactionID: 4}))
+	assert.Equal(t, repository.ErrRefundExceedsAmount, repo.Refund(ctx, &models.Refund{TransactionID: 5}))
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `avitotech_balance_changes_total{currency="RUB",direction="deposit"} 2`)
+	assert.Contains(t, body, `avitotech_balance_change_amount_total{currency="RUB",direction="deposit"} 21`)
+	assert.Contains(t, body, `avitotech_balance_change_amount_total{currency="RUB",direction="withdrawal"} 2.5`)
+	assert.Contains(t, body, `avitotech_transfers_total{currency="USD"} 1`)
+	assert.Contains(t, body, `avitotech_transferred_amount_total{currency="USD"} 3`)
+	assert.Contains(t, body, `avitotech_business_errors_total{operation="transfer",reason="insufficient_funds"} 1`)
+	assert.Contains(t, body, `avitotech_business_errors_total{operation="cancel_reservation",reason="reservation_not_found"} 1`)
+	assert.Contains(t, body, `avitotech_refunds_total{currency="USD"} 1`)
+	assert.Contains(t, body, `avitotech_refunded_amount_total{currency="USD"} 3`)
+	assert.Contains(t, body, `avitotech_business_errors_total{operation="refund",reason="refund_exceeds_amount"} 1`)
+	assert.Contains(t, body, `avitotech_repository_operation_duration_seconds_count{operation="change_balance",result="ok"} 3`)
+	assert.Contains(t, body, `avitotech_repository_operation_duration_seconds_count{operation="transfer",result="business_error"} 1`)
+	assert.Contains(t, body, `avitotech_repository_operation_duration_seconds_count{operation="get_all",result="error"} 1`)
+}
+
+func Test_InstrumentScheduledTransfers(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	schedules := mock_repository.NewMockScheduledTransfers(c)
+	m := metrics.New(nil)
+	repo := m.Instrument(&repository.Repository{ScheduledTransfers: schedules})
+	ctx := context.Background()
+	now := time.Now()
+	policy := models.RetryPolicy{}
+
+	schedules.EXPECT().RunSchedule(ctx, 1, now, policy).Return(&models.ScheduledTransferRun{Amount: 5000000, Currency: "RUB", Status: models.RunSucceeded}, nil)
+	schedules.EXPECT().RunSchedule(ctx, 2, now, policy).Return(&models.ScheduledTransferRun{Amount: 5000000, Currency: "RUB", Status: models.RunRetrying}, nil)
+	schedules.EXPECT().RunSchedule(ctx, 3, now, policy).Return(nil, nil)
+	schedules.EXPECT().ListSchedules(ctx, 0).Return(nil, nil)
+
+	for id := 1; id <= 3; id++ {
+		_, err := repo.RunSchedule(ctx, id, now, policy)
+		assert.NoError(t, err)
+	}
+	_, err := repo.ListSchedules(ctx, 0)
+	assert.NoError(t, err)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `avitotech_scheduled_transfer_runs_total{status="succeeded"} 1`)
+	assert.Contains(t, body, `avitotech_scheduled_transfer_runs_total{status="retrying"} 1`)
+	assert.Contains(t, body, `avitotech_transfers_total{currency="RUB"} 1`)
+	assert.Contains(t, body, `avitotech_transferred_amount_total{currency="RUB"} 500`)
+	assert.Contains(t, body, `avitotech_repository_operation_duration_seconds_count{operation="run_scheduled_transfer",result="ok"} 3`)
+}
+
+type failingRates struct{}
+
+func (failingRates) Rate(ctx context.Context, from, to string) (float64, error) {
+	return 0, exchange.ErrRateUnavailable
+}
+
+func Test_InstrumentRates(t *testing.T) {
+	m := metrics.New(nil)
+	up := m.InstrumentRates(exchange.NewStaticProvider(map[string]map[string]float64{"RUB": {"USD": 0.016}}))
+	down := m.InstrumentRates(failingRates{})
+
+	rate, err := up.Rate(context.Background(), "RUB", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.016, rate)
+	_, err = down.Rate(context.Background(), "RUB", "USD")
+	assert.ErrorIs(t, err, exchange.ErrRateUnavailable)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, "avitotech_exchange_rate_request_duration_seconds_count 2")
+	assert.Contains(t, body, "avitotech_exchange_rate_errors_total 1")
+}
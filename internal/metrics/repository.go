@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"errors"
+	"time"
+)
+
+// businessErrors are the errors by which business rules refuse an
+// operation, by the reason they are counted under.
+var businessErrors = []struct {
+	err    error
+	reason string
+}{
+	{repository.ErrInsufficientFunds, "insufficient_funds"},
+	{repository.ErrNewAccNegativeBalance, "insufficient_funds"},
+	{repository.ErrUserDoesntExist, "account_not_found"},
+	{repository.ErrAccountExists, "account_exists"},
+	{repository.ErrAccountFrozen, "account_frozen"},
+	{repository.ErrAccountClosed, "account_closed"},
+	{repository.ErrAccountNotEmpty, "account_not_empty"},
+	{repository.ErrVersionMismatch, "version_mismatch"},
+	{repository.ErrZeroPosting, "amount_too_small"},
+	{repository.ErrReservationNotFound, "reservation_not_found"},
+	{repository.ErrReservationExists, "reservation_exists"},
+	{repository.ErrTransactionNotFound, "transaction_not_found"},
+	{repository.ErrNotRefundable, "not_refundable"},
+	{repository.ErrRefundExceedsAmount, "refund_exceeds_amount"},
+}
+
+// Instrument wraps the account and reservation repositories, and the runs of
+// scheduled transfers, which move money, in metrics. The other repositories
+// are left as they are.
+func (m *Metrics) Instrument(repo *repository.Repository) *repository.Repository {
+	instrumented := *repo
+	instrumented.Account = &account{next: repo.Account, m: m}
+	instrumented.Reservation = &reservation{next: repo.Reservation, m: m}
+	instrumented.ScheduledTransfers = &scheduledTransfers{ScheduledTransfers: repo.ScheduledTransfers, m: m}
+	return &instrumented
+}
+
+// track starts timing an operation. The returned func, deferred with the
+// operation's error, records how long it took and, when it was refused by a
+// business rule, why.
+func (m *Metrics) track(operation string) func(err *error) {
+	start := time.Now()
+	return func(errp *error) {
+		m.observe(operation, start, *errp)
+	}
+}
+
+func (m *Metrics) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+		if m.countBusinessError(operation, err) {
+			result = "business_error"
+		}
+	}
+	m.operationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+// countBusinessError counts err under its reason when it is a business
+// error, and reports whether it was one.
+func (m *Metrics) countBusinessError(operation string, err error) bool {
+	for _, known := range businessErrors {
+		if errors.Is(err, known.err) {
+			m.businessErrors.WithLabelValues(operation, known.reason).Inc()
+			return true
+		}
+	}
+	return false
+}
+
+type account struct {
+	next repository.Account
+	m    *Metrics
+}
+
+func (a *account) GetBalanceByID(ctx context.Context, id int) (acc *models.Account, err error) {
+	defer a.m.track("get_balance")(&err)
+	return a.next.GetBalanceByID(ctx, id)
+}
+
+func (a *account) ChangeBalance(ctx context.Context, acc *models.AccountDebit) (err error) {
+	defer a.m.track("change_balance")(&err)
+
+	err = a.next.ChangeBalance(ctx, acc)
+	if err == nil {
+		direction, amount := "deposit", acc.Amount
+		if amount < 0 {
+			direction, amount = "withdrawal", -amount
+		}
+		a.m.balanceChanges.WithLabelValues(acc.Currency, direction).Inc()
+		a.m.balanceChangeAmount.WithLabelValues(acc.Currency, direction).Add(amount.Float64())
+	}
+	return err
+}
+
+func (a *account) GetAll(ctx context.Context) (accounts []models.Account, err error) {
+	defer a.m.track("get_all")(&err)
+	return a.next.GetAll(ctx)
+}
+
+func (a *account) MoneyTransaction(ctx context.Context, transaction *models.Transaction) (err error) {
+	defer a.m.track("transfer")(&err)
+
+	err = a.next.MoneyTransaction(ctx, transaction)
+	if err == nil {
+		a.m.transfers.WithLabelValues(transaction.Currency).Inc()
+		a.m.transferredAmount.WithLabelValues(transaction.Currency).Add(transaction.Amount.Float64())
+	}
+	return err
+}
+
+// MoneyTransactions counts every applied transaction of the batch as a
+// transfer, and the business errors of the failed ones.
+func (a *account) MoneyTransactions(ctx context.Context, transactions []models.Transaction, bestEffort bool) (errs []error, err error) {
+	defer a.m.track("transfer_batch")(&err)
+
+	errs, err = a.next.MoneyTransactions(ctx, transactions, bestEffort)
+	for i, itemErr := range errs {
+		if itemErr != nil {
+			a.m.countBusinessError("transfer_batch", itemErr)
+			continue
+		}
+		a.m.transfers.WithLabelValues(transactions[i].Currency).Inc()
+		a.m.transferredAmount.WithLabelValues(transactions[i].Currency).Add(transactions[i].Amount.Float64())
+	}
+	return errs, err
+}
+
+func (a *account) Create(ctx context.Context, id int) (err error) {
+	defer a.m.track("create_account")(&err)
+	return a.next.Create(ctx, id)
+}
+
+func (a *account) Freeze(ctx context.Context, id int, version int64) (err error) {
+	defer a.m.track("freeze_account")(&err)
+	return a.next.Freeze(ctx, id, version)
+}
+
+func (a *account) Unfreeze(ctx context.Context, id int, version int64) (err error) {
+	defer a.m.track("unfreeze_account")(&err)
+	return a.next.Unfreeze(ctx, id, version)
+}
+
+func (a *account) Close(ctx context.Context, id int, version int64) (err error) {
+	defer a.m.track("close_account")(&err)
+	return a.next.Close(ctx, id, version)
+}
+
+func (a *account) Refund(ctx context.Context, refund *models.Refund) (err error) {
+	defer a.m.track("refund")(&err)
+
+	err = a.next.Refund(ctx, refund)
+	if err == nil {
+		a.m.refunds.WithLabelValues(refund.Currency).Inc()
+		a.m.refundedAmount.WithLabelValues(refund.Currency).Add(refund.Amount.Float64())
+	}
+	return err
+}
+
+type reservation struct {
+	next repository.Reservation
+	m    *Metrics
+}
+
+func (r *reservation) Reserve(ctx context.Context, res *models.Reservation) (err error) {
+	defer r.m.track("reserve")(&err)
+	return r.next.Reserve(ctx, res)
+}
+
+func (r *reservation) Confirm(ctx context.Context, res *models.Reservation) (err error) {
+	defer r.m.track("confirm_reservation")(&err)
+	return r.next.Confirm(ctx, res)
+}
+
+func (r *reservation) Cancel(ctx context.Context, res *models.Reservation) (err error) {
+	defer r.m.track("cancel_reservation")(&err)
+	return r.next.Cancel(ctx, res)
+}
+
+// scheduledTransfers only instruments runs; managing schedules moves no money.
+type scheduledTransfers struct {
+	repository.ScheduledTransfers
+	m *Metrics
+}
+
+// RunSchedule counts every run by status, and a succeeded one as a transfer.
+func (s *scheduledTransfers) RunSchedule(ctx context.Context, id int, now time.Time, policy models.RetryPolicy) (run *models.ScheduledTransferRun, err error) {
+	defer s.m.track("run_scheduled_transfer")(&err)
+
+	run, err = s.ScheduledTransfers.RunSchedule(ctx, id, now, policy)
+	if run != nil {
+		s.m.scheduledRuns.WithLabelValues(run.Status).Inc()
+		if run.Status == models.RunSucceeded {
+			s.m.transfers.WithLabelValues(run.Currency).Inc()
+			s.m.transferredAmount.WithLabelValues(run.Currency).Add(run.Amount.Float64())
+		}
+	}
+	return run, err
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// HistoryCursor is the position of a row in an account history sorted by
+// Sort. It holds every column history can be sorted by. Clients only ever
+// see it as an opaque token.
+type HistoryCursor struct {
+	Sort          string    `json:"s"`
+	Date          time.Time `json:"d"`
+	Amount        Money     `json:"a"`
+	TransactionID int       `json:"id"`
+}
+
+// CursorOf returns the cursor pointing at tr in history sorted by sort.
+func CursorOf(tr *TransactionHistory, sort Sort) *HistoryCursor {
+	return &HistoryCursor{
+		Sort:          sort.String(),
+		Date:          tr.Date,
+		Amount:        tr.Amount,
+		TransactionID: tr.TransactionID,
+	}
+}
+
+// Value returns the cursor value of a sort column.
+func (c *HistoryCursor) Value(column string) interface{} {
+	switch column {
+	case "date_time":
+		return c.Date
+	case "amount":
+		return c.Amount
+	default:
+		return c.TransactionID
+	}
+}
+
+// cursorOf checks that a cursor was issued for history sorted by sort.
+func cursorOf(sort Sort) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		if c, _ := value.(*HistoryCursor); c != nil && c.Sort != sort.String() {
+			return errors.New("belongs to a different sort")
+		}
+		return nil
+	})
+}
+
+func (c *HistoryCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseHistoryCursor decodes a token produced by HistoryCursor.String.
+func ParseHistoryCursor(token string) (*HistoryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &HistoryCursor{}
+	if err = json.Unmarshal(data, c); err != nil || c.TransactionID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
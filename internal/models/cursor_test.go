@@ -0,0 +1,22 @@
+package models_test
+
+import (
+	"avito-tech/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryCursor(t *testing.T) {
+	cursor := &models.HistoryCursor{Date: time.Date(2022, 3, 1, 10, 30, 0, 123456000, time.UTC), TransactionID: 42}
+
+	parsed, err := models.ParseHistoryCursor(cursor.String())
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+
+	for _, token := range []string{"", "not base64!", "bm90IGpzb24", "e30"} {
+		_, err = models.ParseHistoryCursor(token)
+		assert.Equal(t, models.ErrInvalidCursor, err, token)
+	}
+}
@@ -3,6 +3,7 @@ package models_test
 import (
 	"avito-tech/internal/models"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -19,7 +20,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "First transaction",
 			},
 			isValid: true,
@@ -29,7 +30,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "First transaction",
 			},
 			isValid: true,
@@ -39,7 +40,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   -2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "First transaction",
 			},
 			isValid: false,
@@ -49,7 +50,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: -10,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "First transaction",
 			},
 			isValid: false,
@@ -59,7 +60,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 1,
 				SenderID:   2,
-				Amount:     -236.5,
+				Amount:     -2365000,
 				Comment:    "First transaction",
 			},
 			isValid: false,
@@ -69,7 +70,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 2,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "First transaction",
 			},
 
@@ -80,7 +81,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 2,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 			},
 
 			isValid: false,
@@ -90,7 +91,7 @@ func TestTransaction_Validate(t *testing.T) {
 			r: &models.Transaction{
 				ReceiverID: 2,
 				SenderID:   2,
-				Amount:     236.5,
+				Amount:     2365000,
 				Comment:    "1",
 			},
 
@@ -120,7 +121,7 @@ func TestAccountDebit_Validate(t *testing.T) {
 			name: "pass",
 			r: &models.AccountDebit{
 				AccountID: 2,
-				Amount:    5,
+				Amount:    50000,
 				Comment:    "First transaction",
 
 			},
@@ -130,7 +131,7 @@ func TestAccountDebit_Validate(t *testing.T) {
 			name: "pass",
 			r: &models.AccountDebit{
 				AccountID: 25,
-				Amount:    551.5,
+				Amount:    5515000,
 				Comment:    "First transaction",
 
 			},
@@ -140,7 +141,7 @@ func TestAccountDebit_Validate(t *testing.T) {
 			name: "invalid account id",
 			r: &models.AccountDebit{
 				AccountID: -2,
-				Amount:    5,
+				Amount:    50000,
 				Comment:    "First transaction",
 
 			},
@@ -160,7 +161,7 @@ func TestAccountDebit_Validate(t *testing.T) {
 			name: "no comm",
 			r: &models.AccountDebit{
 				AccountID: 2,
-				Amount:    -5,
+				Amount:    -50000,
 				Comment:    "",
 
 			},
@@ -170,7 +171,7 @@ func TestAccountDebit_Validate(t *testing.T) {
 			name: "short comment",
 			r: &models.AccountDebit{
 				AccountID: 2,
-				Amount:    -5,
+				Amount:    -50000,
 				Comment:    "3",
 
 			},
@@ -228,6 +229,14 @@ func TestTransactionHistoryReq_Validate(t *testing.T) {
 			},
 			isValid: true,
 		},
+		{
+			name: "pass",
+			r: &models.TransactionHistoryReq{
+				AccountID: 1,
+				OrderBy: "-date_time,amount",
+			},
+			isValid: true,
+		},
 		{
 			name: "wrong order by",
 			r: &models.TransactionHistoryReq{
@@ -235,6 +244,14 @@ func TestTransactionHistoryReq_Validate(t *testing.T) {
 				OrderBy: "id",
 			},
 			isValid: false,
+		},
+		{
+			name: "same column twice",
+			r: &models.TransactionHistoryReq{
+				AccountID: 1,
+				OrderBy: "amount,-amount",
+			},
+			isValid: false,
 		},{
 			name: "wrong id",
 			r: &models.TransactionHistoryReq{
@@ -243,6 +260,72 @@ func TestTransactionHistoryReq_Validate(t *testing.T) {
 			},
 			isValid: false,
 		},
+		{
+			name: "negative limit",
+			r: &models.TransactionHistoryReq{
+				AccountID: 1,
+				Limit: -1,
+			},
+			isValid: false,
+		},
+		{
+			name: "negative offset",
+			r: &models.TransactionHistoryReq{
+				AccountID: 1,
+				Limit: 10,
+				Offset: -10,
+			},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func TestReservation_Validate(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		r       *models.Reservation
+		isValid bool
+	}{
+		{
+			name: "pass",
+			r: &models.Reservation{
+				AccountID: 1,
+				ServiceID: 2,
+				OrderID:   3,
+				Amount:    1000000,
+			},
+			isValid: true,
+		},
+		{
+			name: "no order",
+			r: &models.Reservation{
+				AccountID: 1,
+				ServiceID: 2,
+				Amount:    1000000,
+			},
+			isValid: false,
+		},
+		{
+			name: "invalid amount",
+			r: &models.Reservation{
+				AccountID: 1,
+				ServiceID: 2,
+				OrderID:   3,
+				Amount:    -1000000,
+			},
+			isValid: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -254,4 +337,263 @@ func TestTransactionHistoryReq_Validate(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestRevenueReportReq_Validate(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		r       *models.RevenueReportReq
+		isValid bool
+	}{
+		{
+			name:    "pass",
+			r:       &models.RevenueReportReq{Year: 2022, Month: 3},
+			isValid: true,
+		},
+		{
+			name:    "no month",
+			r:       &models.RevenueReportReq{Year: 2022},
+			isValid: false,
+		},
+		{
+			name:    "invalid month",
+			r:       &models.RevenueReportReq{Year: 2022, Month: 13},
+			isValid: false,
+		},
+		{
+			name:    "invalid year",
+			r:       &models.RevenueReportReq{Year: 22, Month: 3},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func TestTransactionHistoryPageReq_Validate(t *testing.T) {
+	cursor := &models.HistoryCursor{Sort: "-date_time", Date: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), TransactionID: 7}
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	small, large := models.Money(50000), models.Money(100000)
+
+	testCases := []struct {
+		name    string
+		r       *models.TransactionHistoryPageReq
+		isValid bool
+	}{
+		{
+			name:    "pass",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.DefaultHistorySort, After: cursor, From: &from, To: &to, MinAmount: &small, MaxAmount: &large, Direction: "debit"},
+			isValid: true,
+		},
+		{
+			name:    "no limit",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort},
+			isValid: false,
+		},
+		{
+			name:    "limit too big",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 1001, Sort: models.DefaultHistorySort},
+			isValid: false,
+		},
+		{
+			name:    "no sort",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50},
+			isValid: false,
+		},
+		{
+			name:    "cursor of another sort",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.Sort{{Column: "amount"}}, After: cursor},
+			isValid: false,
+		},
+		{
+			name:    "after and before",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.DefaultHistorySort, After: cursor, Before: cursor},
+			isValid: false,
+		},
+		{
+			name:    "to before from",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.DefaultHistorySort, From: &to, To: &from},
+			isValid: false,
+		},
+		{
+			name:    "max below min",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.DefaultHistorySort, MinAmount: &large, MaxAmount: &small},
+			isValid: false,
+		},
+		{
+			name:    "unknown direction",
+			r:       &models.TransactionHistoryPageReq{AccountID: 1, Limit: 50, Sort: models.DefaultHistorySort, Direction: "sideways"},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func TestAPIClient_Validate(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		r       *models.APIClient
+		isValid bool
+	}{
+		{
+			name:    "pass",
+			r:       &models.APIClient{Name: "shop", Scopes: []string{models.ScopeReadBalance, models.ScopeTransfer}},
+			isValid: true,
+		},
+		{
+			name:    "no scopes",
+			r:       &models.APIClient{Name: "shop"},
+			isValid: false,
+		},
+		{
+			name:    "unknown scope",
+			r:       &models.APIClient{Name: "shop", Scopes: []string{"root"}},
+			isValid: false,
+		},
+		{
+			name:    "no name",
+			r:       &models.APIClient{Scopes: []string{models.ScopeAdmin}},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func batchOf(tr models.Transaction, n int) []models.Transaction {
+	batch := make([]models.Transaction, n)
+	for i := range batch {
+		batch[i] = tr
+	}
+	return batch
+}
+
+func TestTransactionBatch_Validate(t *testing.T) {
+	valid := models.Transaction{ReceiverID: 1, SenderID: 2, Amount: 225000, Comment: "salary for March"}
+	invalid := models.Transaction{ReceiverID: 1, SenderID: 1, Amount: 225000, Comment: "salary for March"}
+
+	testCases := []struct {
+		name    string
+		r       *models.TransactionBatch
+		isValid bool
+	}{
+		{
+			name:    "pass",
+			r:       &models.TransactionBatch{Transactions: []models.Transaction{valid, valid}},
+			isValid: true,
+		},
+		{
+			name:    "empty",
+			r:       &models.TransactionBatch{},
+			isValid: false,
+		},
+		{
+			name:    "at the limit",
+			r:       &models.TransactionBatch{Transactions: batchOf(valid, models.MaxBatchTransactions)},
+			isValid: true,
+		},
+		{
+			name:    "too many",
+			r:       &models.TransactionBatch{Transactions: make([]models.Transaction, models.MaxBatchTransactions+1)},
+			isValid: false,
+		},
+		{
+			name:    "invalid item",
+			r:       &models.TransactionBatch{Transactions: []models.Transaction{valid, invalid}, BestEffort: true},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func TestRefund_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		r       *models.Refund
+		isValid bool
+	}{
+		{
+			name:    "full",
+			r:       &models.Refund{TransactionID: 4},
+			isValid: true,
+		},
+		{
+			name:    "partial",
+			r:       &models.Refund{TransactionID: 4, Amount: 5000, Comment: "damaged item"},
+			isValid: true,
+		},
+		{
+			name:    "no transaction",
+			r:       &models.Refund{Amount: 5000},
+			isValid: false,
+		},
+		{
+			name:    "negative amount",
+			r:       &models.Refund{TransactionID: 4, Amount: -5000},
+			isValid: false,
+		},
+		{
+			name:    "short comment",
+			r:       &models.Refund{TransactionID: 4, Comment: "bad"},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.isValid {
+				assert.NoError(t, tc.r.Validate())
+			} else {
+				assert.Error(t, tc.r.Validate())
+			}
+		})
+	}
+}
+
+func TestAPIClient_HasScope(t *testing.T) {
+	reader := &models.APIClient{Scopes: []string{models.ScopeReadBalance}}
+	assert.True(t, reader.HasScope(models.ScopeReadBalance))
+	assert.False(t, reader.HasScope(models.ScopeTransfer))
+	assert.False(t, reader.HasScope(models.ScopeAdmin))
+
+	admin := &models.APIClient{Scopes: []string{models.ScopeAdmin}}
+	assert.True(t, admin.HasScope(models.ScopeTransfer))
+	assert.True(t, admin.HasScope(models.ScopeAdmin))
+}
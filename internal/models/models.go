@@ -1,39 +1,260 @@
 package models
 
 import (
+	"errors"
+	"strconv"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 )
 
+// DefaultCurrency is used when a request does not name a currency.
+const DefaultCurrency = "RUB"
+
+// Account statuses. Frozen accounts accept deposits only, closed accounts
+// accept nothing.
+const (
+	AccountActive = "active"
+	AccountFrozen = "frozen"
+	AccountClosed = "closed"
+)
+
 type Account struct {
-	ID      int     `json:"account_id"`
-	Balance float64 `json:"balance"`
+	ID        int              `json:"account_id"`
+	Status    string           `json:"status,omitempty"`
+	Balances  map[string]Money `json:"balances"`
+	CreatedAt *time.Time       `json:"created_at,omitempty"`
+	FrozenAt  *time.Time       `json:"frozen_at,omitempty"`
+	ClosedAt  *time.Time       `json:"closed_at,omitempty"`
+	// Version grows with every change to the account, see ETag.
+	Version int64 `json:"version,omitempty"`
+}
+
+// ETag returns the version as a strong entity tag.
+func (a *Account) ETag() string {
+	return `"` + strconv.FormatInt(a.Version, 10) + `"`
+}
+
+type AccountCreateReq struct {
+	AccountID int `json:"account_id"`
 }
 
+// Transaction moves Amount of Currency from the sender. When ReceiverCurrency
+// differs, the receiver is credited Amount converted at Rate.
 type Transaction struct {
-	ReceiverID int     `json:"receiver_id"`
-	SenderID   int     `json:"sender_id"`
-	Amount     float64 `json:"amount"`
-	Comment    string  `json:"comment"`
+	ReceiverID       int     `json:"receiver_id"`
+	SenderID         int     `json:"sender_id"`
+	Amount           Money   `json:"amount"`
+	Comment          string  `json:"comment"`
+	Currency         string  `json:"currency"`
+	ReceiverCurrency string  `json:"receiver_currency"`
+	Rate             float64 `json:"-"`
+	// ExpectedVersion, when set, is the version the sender account must
+	// still be at.
+	ExpectedVersion int64 `json:"-"`
+}
+
+// MaxBatchTransactions bounds the transactions of one batch. A batch runs in
+// one database transaction under the request deadline, and a deadlock retry
+// starts it over, so it is kept small enough to run several times within the
+// default REQUEST_TIMEOUT.
+const MaxBatchTransactions = 100
+
+// TransactionBatch is a list of transactions applied in one database
+// transaction. Unless BestEffort is set either all of them are applied or
+// none is; with BestEffort the ones that fail are skipped.
+type TransactionBatch struct {
+	Transactions []Transaction `json:"transactions"`
+	BestEffort   bool          `json:"best_effort"`
+}
+
+// Batch item statuses. Rolled back items were valid, but their batch failed
+// on another item.
+const (
+	BatchItemApplied    = "applied"
+	BatchItemFailed     = "failed"
+	BatchItemRolledBack = "rolled_back"
+)
+
+// TransactionBatchResult reports on every transaction of a batch, in the
+// order of the request.
+type TransactionBatchResult struct {
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is the outcome of one transaction of a batch. Error is only
+// set for failed ones.
+type BatchItemResult struct {
+	Index  int            `json:"index"`
+	Status string         `json:"status"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+// Refund returns Amount of a transfer, in the currency the sender paid, from
+// the receiver to the sender. TransactionID is the history row of either
+// side of the transfer; a zero Amount refunds whatever is left. Currency,
+// EntryID, Refunded and Remaining are set once the refund is made: Refunded
+// is the total refunded so far, this refund included.
+type Refund struct {
+	TransactionID int    `json:"transaction_id"`
+	Amount        Money  `json:"amount"`
+	Comment       string `json:"comment"`
+	Currency      string `json:"currency"`
+	EntryID       int    `json:"entry_id"`
+	Refunded      Money  `json:"refunded"`
+	Remaining     Money  `json:"remaining"`
 }
 
 type AccountDebit struct {
-	AccountID int     `json:"account_id"`
-	Amount    float64 `json:"amount"`
-	Comment   string  `json:"comment"`
+	AccountID       int    `json:"account_id"`
+	Amount          Money  `json:"amount"`
+	Comment         string `json:"comment"`
+	Currency        string `json:"currency"`
+	ExpectedVersion int64  `json:"-"`
+}
+
+type Reservation struct {
+	AccountID       int   `json:"account_id"`
+	ServiceID       int   `json:"service_id"`
+	OrderID         int   `json:"order_id"`
+	Amount          Money `json:"amount"`
+	ExpectedVersion int64 `json:"-"`
+}
+
+// API client scopes. Admin grants every other scope as well.
+const (
+	ScopeReadBalance = "balance:read"
+	ScopeTransfer    = "transfer"
+	ScopeAdmin       = "admin"
+)
+
+var Scopes = []string{ScopeReadBalance, ScopeTransfer, ScopeAdmin}
+
+// APIClient is a caller of the API, identified by its key. KeyHash is the
+// SHA-256 of the key secret.
+type APIClient struct {
+	KeyID     string
+	Name      string
+	KeyHash   []byte
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// HasScope reports whether the client may act with scope.
+func (c *APIClient) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyKey is a key sent by the client with key id KeyID, unique per
+// client.
+type IdempotencyKey struct {
+	KeyID       string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type ServiceRevenue struct {
+	ServiceID int
+	Amount    Money
+}
+
+type RevenueReportReq struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+}
+
+type ReportLink struct {
+	Link string `json:"link"`
 }
 
 type ExchangeResponse struct {
-	Result float64 `json:"result"`
+	Result Money `json:"result"`
+}
+
+// ErrorResponse is the body of every error response. Code is stable and
+// meant for clients to branch on, Message is for humans.
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Health statuses of the service and of each dependency it checks.
+const (
+	HealthOK       = "ok"
+	HealthDown     = "down"
+	HealthDraining = "draining"
+)
+
+// HealthReport is the body of /healthz and /readyz.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the state of one dependency. Version and Latest are only set
+// for migrations.
+type HealthCheck struct {
+	Status   string `json:"status"`
+	Version  int64  `json:"version,omitempty"`
+	Latest   int64  `json:"latest,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// InsufficientFundsDetails are the details of an insufficient_funds error.
+type InsufficientFundsDetails struct {
+	AccountID int    `json:"account_id"`
+	Currency  string `json:"currency"`
+	Balance   Money  `json:"balance"`
+	Requested Money  `json:"requested"`
 }
 
 type TransactionHistory struct {
-	TransactionID int       `json:"transaction_ID"`
-	AccountID     int       `json:"account_id"`
-	Amount        float64   `json:"amount"`
-	Date          time.Time `json:"date"`
-	Comment       string    `json:"comment"`
+	TransactionID  int       `json:"transaction_ID"`
+	AccountID      int       `json:"account_id"`
+	Amount         Money     `json:"amount"`
+	Date           time.Time `json:"date"`
+	Comment        string    `json:"comment"`
+	EntryID        int       `json:"entry_id,omitempty"`
+	CounterpartyID int       `json:"counterparty_id,omitempty"`
+	Currency       string    `json:"currency,omitempty"`
+	Rate           float64   `json:"rate,omitempty"`
+	// RefundOf is the transaction this row refunds.
+	RefundOf int `json:"refund_of,omitempty"`
+}
+
+// JournalEntry is one balanced movement of money in the ledger: the amounts
+// of its postings always sum to zero.
+type JournalEntry struct {
+	ID       int
+	Date     time.Time
+	Comment  string
+	Rate     float64
+	Postings []Posting
+	// ExpectedVersions are the versions accounts must be at for the entry
+	// to be posted, by account ID.
+	ExpectedVersions map[int]int64
+	// RefundOf are the history rows the entry refunds, by account ID.
+	RefundOf map[int]int
+}
+
+type Posting struct {
+	AccountID int
+	Currency  string
+	Amount    Money
 }
 
 type TransactionHistoryReq struct {
@@ -43,13 +264,97 @@ type TransactionHistoryReq struct {
 	OrderBy   string `json:"order_by"`
 }
 
+// History directions filter credits (money in) or debits (money out).
+const (
+	DirectionCredit = "credit"
+	DirectionDebit  = "debit"
+)
+
+const (
+	DefaultHistoryPageSize = 50
+	MaxHistoryPageSize     = 1000
+)
+
+// TransactionHistoryPageReq selects a page of an account history in Sort
+// order. After and Before are the cursors of the previous page's last and
+// first rows; at most one may be set. From is inclusive, To is exclusive,
+// and MinAmount and MaxAmount bound the absolute amount.
+type TransactionHistoryPageReq struct {
+	AccountID int            `json:"account_id"`
+	Limit     int            `json:"limit"`
+	Sort      Sort           `json:"sort"`
+	After     *HistoryCursor `json:"after"`
+	Before    *HistoryCursor `json:"before"`
+	From      *time.Time     `json:"from"`
+	To        *time.Time     `json:"to"`
+	MinAmount *Money         `json:"min_amount"`
+	MaxAmount *Money         `json:"max_amount"`
+	Direction string         `json:"direction"`
+}
+
+type TransactionHistoryPage struct {
+	Transactions []TransactionHistory `json:"transactions"`
+	NextCursor   string               `json:"next_cursor,omitempty"`
+	PrevCursor   string               `json:"prev_cursor,omitempty"`
+}
+
 func (t *Transaction) Validate() error {
 	return validation.ValidateStruct(
 		t,
 		validation.Field(&t.ReceiverID, validation.Required, validation.Min(1)),
 		validation.Field(&t.SenderID, validation.Required, validation.Min(1), validation.NotIn(t.ReceiverID)),
-		validation.Field(&t.Amount, validation.Required, validation.Min(float64(1))),
+		validation.Field(&t.Amount, moneyRequired, moneyMin(Money(minorPerMajor)), moneyIn(t.Currency)),
 		validation.Field(&t.Comment, validation.Required, validation.Length(5, 50)),
+		validation.Field(&t.Currency, knownCurrency),
+		validation.Field(&t.ReceiverCurrency, knownCurrency),
+	)
+}
+
+// Validate checks the batch size and every transaction. The errors of the
+// transactions are reported under their index.
+func (b *TransactionBatch) Validate() error {
+	err := validation.ValidateStruct(
+		b,
+		validation.Field(&b.Transactions, validation.Required, validation.Length(1, MaxBatchTransactions)),
+	)
+	if err != nil {
+		return err
+	}
+
+	items := validation.Errors{}
+	for i := range b.Transactions {
+		if err := b.Transactions[i].Validate(); err != nil {
+			items[strconv.Itoa(i)] = err
+		}
+	}
+	if len(items) > 0 {
+		return validation.Errors{"transactions": items}
+	}
+	return nil
+}
+
+func (a *AccountCreateReq) Validate() error {
+	return validation.ValidateStruct(
+		a,
+		validation.Field(&a.AccountID, validation.Required, validation.Min(1)),
+	)
+}
+
+func (c *APIClient) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&c.Scopes, validation.Required, validation.Each(validation.In(ScopeReadBalance, ScopeTransfer, ScopeAdmin))),
+	)
+}
+
+// Validate checks the request part of the refund.
+func (r *Refund) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.TransactionID, validation.Required, validation.Min(1)),
+		validation.Field(&r.Amount, moneyMin(0)),
+		validation.Field(&r.Comment, validation.Length(5, 50)),
 	)
 }
 
@@ -57,8 +362,27 @@ func (a *AccountDebit) Validate() error {
 	return validation.ValidateStruct(
 		a,
 		validation.Field(&a.AccountID, validation.Required, validation.Min(1)),
-		validation.Field(&a.Amount, validation.Required, validation.NotIn(float64(0))),
+		validation.Field(&a.Amount, moneyRequired, moneyIn(a.Currency)),
 		validation.Field(&a.Comment, validation.Required, validation.Length(5, 50)),
+		validation.Field(&a.Currency, knownCurrency),
+	)
+}
+
+func (r *Reservation) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.AccountID, validation.Required, validation.Min(1)),
+		validation.Field(&r.ServiceID, validation.Required, validation.Min(1)),
+		validation.Field(&r.OrderID, validation.Required, validation.Min(1)),
+		validation.Field(&r.Amount, moneyRequired, moneyMin(Money(minorPerMajor)), moneyIn(DefaultCurrency)),
+	)
+}
+
+func (rr *RevenueReportReq) Validate() error {
+	return validation.ValidateStruct(
+		rr,
+		validation.Field(&rr.Year, validation.Required, validation.Min(1970), validation.Max(9999)),
+		validation.Field(&rr.Month, validation.Required, validation.Min(1), validation.Max(12)),
 	)
 }
 
@@ -66,10 +390,43 @@ func (tr *TransactionHistoryReq) Validate() error {
 	return validation.ValidateStruct(
 		tr,
 		validation.Field(&tr.AccountID, validation.Required, validation.Min(1)),
-		validation.Field(&tr.OrderBy, validation.In("date_time", "amount",
-			"date_time ASC", "amount ASC",
-			"date_time DESC", "amount DESC",
-			"date_time asc", "amount asc",
-			"date_time desc", "amount desc")),
+		validation.Field(&tr.Limit, validation.Min(0)),
+		validation.Field(&tr.Offset, validation.Min(0)),
+		validation.Field(&tr.OrderBy, sortSpec),
+	)
+}
+
+func (tr *TransactionHistoryPageReq) Validate() error {
+	return validation.ValidateStruct(
+		tr,
+		validation.Field(&tr.AccountID, validation.Required, validation.Min(1)),
+		validation.Field(&tr.Limit, validation.Required, validation.Min(1), validation.Max(MaxHistoryPageSize)),
+		validation.Field(&tr.Sort, validation.Required),
+		validation.Field(&tr.After, cursorOf(tr.Sort)),
+		validation.Field(&tr.Before, cursorOf(tr.Sort), validation.By(func(interface{}) error {
+			if tr.After != nil && tr.Before != nil {
+				return errors.New("cannot be combined with after")
+			}
+			return nil
+		})),
+		validation.Field(&tr.To, validation.By(func(interface{}) error {
+			if tr.From != nil && tr.To != nil && !tr.To.After(*tr.From) {
+				return errors.New("must be later than from")
+			}
+			return nil
+		})),
+		validation.Field(&tr.MinAmount, validation.By(func(interface{}) error {
+			if tr.MinAmount != nil && *tr.MinAmount < 0 {
+				return errors.New("must be no less than 0")
+			}
+			return nil
+		})),
+		validation.Field(&tr.MaxAmount, validation.By(func(interface{}) error {
+			if tr.MaxAmount != nil && tr.MinAmount != nil && *tr.MaxAmount < *tr.MinAmount {
+				return errors.New("must be no less than min_amount")
+			}
+			return nil
+		})),
+		validation.Field(&tr.Direction, validation.In(DirectionCredit, DirectionDebit)),
 	)
 }
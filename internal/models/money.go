@@ -0,0 +1,203 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Money is an exact amount of money stored as an integer count of
+// ten-thousandths of the major unit, the finest minor unit of any ISO 4217
+// currency, so 105000 is 10.50. How many of these decimal places an amount
+// may use depends on its currency, see CurrencyExponent.
+type Money int64
+
+const (
+	moneyScale    = 4
+	minorPerMajor = 10000
+
+	// displayScale is the fewest decimal places amounts are written with.
+	displayScale = 2
+)
+
+var (
+	ErrMoneyPrecision = fmt.Errorf("amount must have at most %d decimal places", moneyScale)
+	ErrMoneyFormat    = errors.New("amount is not a valid decimal number")
+	ErrMoneyOverflow  = errors.New("amount is out of range")
+)
+
+// ParseMoney parses a decimal string such as "10.5" or "-3.25" without going
+// through float64. More than four fractional digits are rejected; fewer may
+// still be too many for the currency of the amount, see MoneyIn.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrMoneyFormat
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, ErrMoneyFormat
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, ErrMoneyFormat
+	}
+
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > moneyScale {
+		return 0, ErrMoneyPrecision
+	}
+	fracPart += strings.Repeat("0", moneyScale-len(fracPart))
+
+	major, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || major > math.MaxInt64/minorPerMajor-1 {
+		return 0, ErrMoneyOverflow
+	}
+	minor, _ := strconv.ParseInt(fracPart, 10, 64)
+
+	m := Money(major*minorPerMajor + minor)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert multiplies m by an exchange rate, rounding to the nearest minor unit
+// of currency, the currency converted to.
+func (m Money) Convert(rate float64, currency string) Money {
+	unit := minorUnit(currency)
+	return Money(math.Round(float64(m)*rate/float64(unit))) * Money(unit)
+}
+
+// Float64 returns m in major units. It may be inexact, so it is only meant
+// for reporting, e.g. in metrics.
+func (m Money) Float64() float64 {
+	return float64(m) / minorPerMajor
+}
+
+// String formats m with at least two decimal places and as many more as it
+// uses, e.g. "10.50" or "1.125".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", moneyScale, v%minorPerMajor), "0")
+	if len(frac) < displayScale {
+		frac += strings.Repeat("0", displayScale-len(frac))
+	}
+	return fmt.Sprintf("%s%d.%s", sign, v/minorPerMajor, frac)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and numeric strings.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if strings.ContainsAny(s, "eE") {
+		return ErrMoneyFormat
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value stores m as a decimal string, which postgres casts to numeric exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+	var (
+		v   Money
+		err error
+	)
+	switch src := src.(type) {
+	case []byte:
+		v, err = ParseMoney(string(src))
+	case string:
+		v, err = ParseMoney(src)
+	case int64:
+		v = Money(src * minorPerMajor)
+	case float64:
+		v, err = ParseMoney(strconv.FormatFloat(src, 'f', -1, 64))
+	case nil:
+		v = 0
+	default:
+		err = fmt.Errorf("cannot scan %T into Money", src)
+	}
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// The built-in ozzo rules see Money as a driver.Valuer and check its string
+// form, so amounts are validated with these rules instead.
+var moneyRequired = validation.By(func(value interface{}) error {
+	if m, _ := value.(Money); m == 0 {
+		return errors.New("cannot be blank")
+	}
+	return nil
+})
+
+func moneyMin(min Money) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		if m, _ := value.(Money); m < min {
+			return fmt.Errorf("must be no less than %s", min)
+		}
+		return nil
+	})
+}
+
+// moneyIn checks that an amount has no more decimal places than currency
+// allows, e.g. none for JPY. Unknown currencies are left to knownCurrency.
+func moneyIn(currency string) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		return MoneyIn(value.(Money), currency)
+	})
+}
@@ -0,0 +1,130 @@
+package models_test
+
+import (
+	"avito-tech/internal/models"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected models.Money
+		err      error
+	}{
+		{
+			name:     "integer",
+			input:    "22",
+			expected: 220000,
+		},
+		{
+			name:     "one decimal",
+			input:    "22.5",
+			expected: 225000,
+		},
+		{
+			name:     "two decimals",
+			input:    "11.62",
+			expected: 116200,
+		},
+		{
+			name:     "negative",
+			input:    "-0.05",
+			expected: -500,
+		},
+		{
+			name:     "four decimals",
+			input:    "0.125",
+			expected: 1250,
+		},
+		{
+			name:     "trailing zeros",
+			input:    "1.500",
+			expected: 15000,
+		},
+		{
+			name:  "too precise",
+			input: "11.623413",
+			err:   models.ErrMoneyPrecision,
+		},
+		{
+			name:  "not a number",
+			input: "1a.5",
+			err:   models.ErrMoneyFormat,
+		},
+		{
+			name:  "empty",
+			input: "",
+			err:   models.ErrMoneyFormat,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := models.ParseMoney(tc.input)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, m)
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	acc := &models.AccountDebit{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"account_id": 1, "amount": 0.1}`), acc))
+	assert.Equal(t, models.Money(1000), acc.Amount)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"account_id": 1, "amount": "0.2"}`), acc))
+	assert.Equal(t, models.Money(2000), acc.Amount)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"account_id": 1, "amount": 0.00001}`), acc), models.ErrMoneyPrecision)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"account_id": 1, "amount": 1e3}`), acc), models.ErrMoneyFormat)
+
+	b, err := json.Marshal(models.Account{ID: 1, Balances: map[string]models.Money{"RUB": -100500, "USD": 500, "KWD": 12345}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"account_id":1,"balances":{"KWD":1.2345,"RUB":-10.05,"USD":0.05}}`, string(b))
+}
+
+func TestMoney_Scan(t *testing.T) {
+	var m models.Money
+
+	assert.NoError(t, m.Scan([]byte("11.62")))
+	assert.Equal(t, models.Money(116200), m)
+
+	assert.NoError(t, m.Scan(int64(3)))
+	assert.Equal(t, models.Money(30000), m)
+
+	assert.Error(t, m.Scan([]byte("11.62341")))
+}
+
+func TestMoney_Convert(t *testing.T) {
+	assert.Equal(t, models.Money(11620000), models.Money(1000000).Convert(11.62, "RUB"))
+	assert.Equal(t, models.Money(1550000), models.Money(10000).Convert(154.56, "JPY"))
+	assert.Equal(t, models.Money(3070), models.Money(10000).Convert(0.30703, "KWD"))
+}
+
+func TestMoneyIn(t *testing.T) {
+	assert.NoError(t, models.MoneyIn(10500, "RUB"))
+	assert.NoError(t, models.MoneyIn(10500, ""))
+	assert.EqualError(t, models.MoneyIn(10050, "RUB"), "must have at most 2 decimal places in RUB")
+	assert.NoError(t, models.MoneyIn(1000000, "JPY"))
+	assert.EqualError(t, models.MoneyIn(1005000, "JPY"), "must have at most 0 decimal places in JPY")
+	assert.NoError(t, models.MoneyIn(1230, "KWD"))
+	assert.Error(t, models.MoneyIn(1235, "KWD"))
+}
+
+func TestCurrencyExponent(t *testing.T) {
+	exponent, ok := models.CurrencyExponent("JPY")
+	assert.True(t, ok)
+	assert.Equal(t, 0, exponent)
+
+	exponent, ok = models.CurrencyExponent("")
+	assert.True(t, ok)
+	assert.Equal(t, 2, exponent)
+
+	_, ok = models.CurrencyExponent("ABC")
+	assert.False(t, ok)
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Schedule periods. A once schedule runs at its start only.
+const (
+	PeriodOnce    = "once"
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+)
+
+// What to do with a run that fails for insufficient funds: skip it until the
+// next occurrence, or retry it later.
+const (
+	OnInsufficientFundsSkip  = "skip"
+	OnInsufficientFundsRetry = "retry"
+)
+
+// Schedule statuses. Finished schedules have no occurrence left.
+const (
+	ScheduleActive    = "active"
+	ScheduleFinished  = "finished"
+	ScheduleCancelled = "cancelled"
+)
+
+// Run statuses. A retrying run failed for insufficient funds and will be
+// tried again; a skipped one failed for good.
+const (
+	RunSucceeded = "succeeded"
+	RunRetrying  = "retrying"
+	RunSkipped   = "skipped"
+)
+
+// ScheduledTransfer moves Amount of Currency from the sender to the receiver
+// at StartAt and then every Period. Times are in UTC. NextRunAt is the
+// occurrence due next and RetryAt, when set, the time a failed run of it is
+// tried again, for the Attempts-th time so far.
+type ScheduledTransfer struct {
+	ID                  int        `json:"schedule_id"`
+	SenderID            int        `json:"sender_id"`
+	ReceiverID          int        `json:"receiver_id"`
+	Amount              Money      `json:"amount"`
+	Currency            string     `json:"currency"`
+	Comment             string     `json:"comment"`
+	Period              string     `json:"period"`
+	StartAt             time.Time  `json:"start_at"`
+	OnInsufficientFunds string     `json:"on_insufficient_funds"`
+	Status              string     `json:"status"`
+	NextRunAt           *time.Time `json:"next_run_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	Attempts            int        `json:"attempts"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// ScheduledTransferRun is one attempt at an occurrence of a schedule. EntryID
+// is the journal entry of a succeeded run, Error the reason of a failed one.
+type ScheduledTransferRun struct {
+	ID         int       `json:"run_id"`
+	ScheduleID int       `json:"schedule_id"`
+	DueAt      time.Time `json:"due_at"`
+	RanAt      time.Time `json:"ran_at"`
+	Attempt    int       `json:"attempt"`
+	Amount     Money     `json:"amount"`
+	Currency   string    `json:"currency"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	EntryID    int       `json:"entry_id,omitempty"`
+}
+
+// RetryPolicy bounds the retries of schedules that retry runs failing for
+// insufficient funds: a failed run is tried again after Interval, at most
+// MaxAttempts times in all, and never once the next occurrence is due.
+type RetryPolicy struct {
+	Interval    time.Duration
+	MaxAttempts int
+}
+
+const (
+	DefaultRunsPageSize = 50
+	MaxRunsPageSize     = 1000
+)
+
+// Transaction returns the transfer a run of the schedule makes.
+func (s *ScheduledTransfer) Transaction() *Transaction {
+	return &Transaction{
+		SenderID:   s.SenderID,
+		ReceiverID: s.ReceiverID,
+		Amount:     s.Amount,
+		Currency:   s.Currency,
+		Comment:    s.Comment,
+	}
+}
+
+// Occurrence returns the n-th run time of the schedule, counting StartAt as
+// the 0-th. Monthly schedules keep the day of StartAt, falling back to the
+// last day of shorter months.
+func (s *ScheduledTransfer) Occurrence(n int) time.Time {
+	start := s.StartAt.UTC()
+	switch s.Period {
+	case PeriodDaily:
+		return start.AddDate(0, 0, n)
+	case PeriodWeekly:
+		return start.AddDate(0, 0, 7*n)
+	case PeriodMonthly:
+		month := time.Date(start.Year(), start.Month()+time.Month(n), 1, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+		day := start.Day()
+		if last := month.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return month.AddDate(0, 0, day-1)
+	default:
+		return start
+	}
+}
+
+// NextRunAfter returns the first occurrence later than t, or nil when there
+// is none.
+func (s *ScheduledTransfer) NextRunAfter(t time.Time) *time.Time {
+	return s.firstOccurrence(func(o time.Time) bool { return o.After(t) })
+}
+
+// FirstRun returns the occurrence a new schedule is first due at: StartAt, or
+// for periodic schedules starting in the past, the first occurrence from now
+// on. A once schedule starting in the past is due at once.
+func (s *ScheduledTransfer) FirstRun(now time.Time) time.Time {
+	if s.Period == PeriodOnce {
+		return s.StartAt.UTC()
+	}
+	return *s.firstOccurrence(func(o time.Time) bool { return !o.Before(now) })
+}
+
+func (s *ScheduledTransfer) firstOccurrence(match func(time.Time) bool) *time.Time {
+	if s.Period == PeriodOnce {
+		start := s.StartAt.UTC()
+		if match(start) {
+			return &start
+		}
+		return nil
+	}
+
+	next := s.Occurrence(0)
+	for n := 1; !match(next); n++ {
+		next = s.Occurrence(n)
+	}
+	return &next
+}
+
+func (s *ScheduledTransfer) Validate() error {
+	return validation.ValidateStruct(
+		s,
+		validation.Field(&s.ReceiverID, validation.Required, validation.Min(1)),
+		validation.Field(&s.SenderID, validation.Required, validation.Min(1), validation.NotIn(s.ReceiverID)),
+		validation.Field(&s.Amount, moneyRequired, moneyMin(Money(minorPerMajor)), moneyIn(s.Currency)),
+		validation.Field(&s.Comment, validation.Required, validation.Length(5, 50)),
+		validation.Field(&s.Currency, knownCurrency),
+		validation.Field(&s.Period, validation.Required, validation.In(PeriodOnce, PeriodDaily, PeriodWeekly, PeriodMonthly)),
+		validation.Field(&s.StartAt, validation.Required),
+		validation.Field(&s.OnInsufficientFunds, validation.In(OnInsufficientFundsSkip, OnInsufficientFundsRetry)),
+	)
+}
@@ -0,0 +1,183 @@
+package models_test
+
+import (
+	"avito-tech/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestScheduledTransfer_NextRunAfter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		period   string
+		start    time.Time
+		after    time.Time
+		expected *time.Time
+	}{
+		{
+			name:     "once before start",
+			period:   models.PeriodOnce,
+			start:    date(2022, 3, 1, 9),
+			after:    date(2022, 2, 1, 0),
+			expected: timePtr(date(2022, 3, 1, 9)),
+		},
+		{
+			name:   "once after start",
+			period: models.PeriodOnce,
+			start:  date(2022, 3, 1, 9),
+			after:  date(2022, 3, 1, 9),
+		},
+		{
+			name:     "daily",
+			period:   models.PeriodDaily,
+			start:    date(2022, 3, 1, 9),
+			after:    date(2022, 3, 10, 9),
+			expected: timePtr(date(2022, 3, 11, 9)),
+		},
+		{
+			name:     "weekly",
+			period:   models.PeriodWeekly,
+			start:    date(2022, 3, 1, 9),
+			after:    date(2022, 3, 9, 0),
+			expected: timePtr(date(2022, 3, 15, 9)),
+		},
+		{
+			name:     "monthly",
+			period:   models.PeriodMonthly,
+			start:    date(2022, 1, 1, 0),
+			after:    date(2022, 5, 20, 0),
+			expected: timePtr(date(2022, 6, 1, 0)),
+		},
+		{
+			name:     "monthly on a day shorter months lack",
+			period:   models.PeriodMonthly,
+			start:    date(2022, 1, 31, 0),
+			after:    date(2022, 1, 31, 0),
+			expected: timePtr(date(2022, 2, 28, 0)),
+		},
+		{
+			name:     "monthly keeps the day after a shorter month",
+			period:   models.PeriodMonthly,
+			start:    date(2022, 1, 31, 0),
+			after:    date(2022, 2, 28, 0),
+			expected: timePtr(date(2022, 3, 31, 0)),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := &models.ScheduledTransfer{Period: testCase.period, StartAt: testCase.start}
+			assert.Equal(t, testCase.expected, s.NextRunAfter(testCase.after))
+		})
+	}
+}
+
+func TestScheduledTransfer_FirstRun(t *testing.T) {
+	now := date(2022, 3, 10, 12)
+
+	future := &models.ScheduledTransfer{Period: models.PeriodMonthly, StartAt: date(2022, 4, 1, 0)}
+	assert.Equal(t, date(2022, 4, 1, 0), future.FirstRun(now))
+
+	pastOnce := &models.ScheduledTransfer{Period: models.PeriodOnce, StartAt: date(2022, 3, 1, 0)}
+	assert.Equal(t, date(2022, 3, 1, 0), pastOnce.FirstRun(now))
+
+	pastDaily := &models.ScheduledTransfer{Period: models.PeriodDaily, StartAt: date(2022, 3, 1, 12)}
+	assert.Equal(t, date(2022, 3, 10, 12), pastDaily.FirstRun(now))
+
+	pastWeekly := &models.ScheduledTransfer{Period: models.PeriodWeekly, StartAt: date(2022, 3, 1, 9)}
+	assert.Equal(t, date(2022, 3, 15, 9), pastWeekly.FirstRun(now))
+
+	moscow := time.FixedZone("MSK", 3*60*60)
+	local := &models.ScheduledTransfer{Period: models.PeriodOnce, StartAt: time.Date(2022, 4, 1, 3, 0, 0, 0, moscow)}
+	assert.Equal(t, date(2022, 4, 1, 0), local.FirstRun(now))
+}
+
+func TestScheduledTransfer_Validate(t *testing.T) {
+	valid := func() *models.ScheduledTransfer {
+		return &models.ScheduledTransfer{
+			SenderID:   3,
+			ReceiverID: 7,
+			Amount:     5000000,
+			Comment:    "monthly rent",
+			Period:     models.PeriodMonthly,
+			StartAt:    date(2022, 4, 1, 0),
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		modify  func(s *models.ScheduledTransfer)
+		isValid bool
+	}{
+		{
+			name:    "pass",
+			modify:  func(s *models.ScheduledTransfer) {},
+			isValid: true,
+		},
+		{
+			name: "retry policy",
+			modify: func(s *models.ScheduledTransfer) {
+				s.OnInsufficientFunds = models.OnInsufficientFundsRetry
+			},
+			isValid: true,
+		},
+		{
+			name: "same sender and receiver",
+			modify: func(s *models.ScheduledTransfer) {
+				s.ReceiverID = s.SenderID
+			},
+		},
+		{
+			name: "unknown period",
+			modify: func(s *models.ScheduledTransfer) {
+				s.Period = "yearly"
+			},
+		},
+		{
+			name: "missing period",
+			modify: func(s *models.ScheduledTransfer) {
+				s.Period = ""
+			},
+		},
+		{
+			name: "missing start",
+			modify: func(s *models.ScheduledTransfer) {
+				s.StartAt = time.Time{}
+			},
+		},
+		{
+			name: "unknown policy",
+			modify: func(s *models.ScheduledTransfer) {
+				s.OnInsufficientFunds = "overdraw"
+			},
+		},
+		{
+			name: "amount below one",
+			modify: func(s *models.ScheduledTransfer) {
+				s.Amount = 50
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := valid()
+			testCase.modify(s)
+			if testCase.isValid {
+				assert.NoError(t, s.Validate())
+			} else {
+				assert.Error(t, s.Validate())
+			}
+		})
+	}
+}
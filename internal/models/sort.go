@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// SortKey orders history by one column.
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+// Sort is a history order written as comma separated columns, each prefixed
+// with "-" for descending, e.g. "-date_time,amount". "date_time DESC" is
+// accepted as well for older clients.
+type Sort []SortKey
+
+// sortColumns are the history columns clients may sort by.
+var sortColumns = map[string]bool{
+	"date_time": true,
+	"amount":    true,
+}
+
+// sortTiebreaker is unique, so ending every order with it makes the order
+// total and pages stable.
+const sortTiebreaker = "transaction_id"
+
+// DefaultHistorySort is newest first.
+var DefaultHistorySort = Sort{{Column: "date_time", Desc: true}}
+
+func ParseSort(spec string) (Sort, error) {
+	var sort Sort
+	seen := map[string]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		key, err := parseSortKey(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		if seen[key.Column] {
+			return nil, fmt.Errorf("%s is sorted by twice", key.Column)
+		}
+		seen[key.Column] = true
+		sort = append(sort, key)
+	}
+	return sort, nil
+}
+
+func parseSortKey(item string) (key SortKey, err error) {
+	fields := strings.Fields(item)
+	switch {
+	case len(fields) == 1 && strings.HasPrefix(item, "-"):
+		key = SortKey{Column: item[1:], Desc: true}
+	case len(fields) == 1:
+		key = SortKey{Column: strings.TrimPrefix(item, "+")}
+	case len(fields) == 2 && strings.EqualFold(fields[1], "asc"):
+		key = SortKey{Column: fields[0]}
+	case len(fields) == 2 && strings.EqualFold(fields[1], "desc"):
+		key = SortKey{Column: fields[0], Desc: true}
+	default:
+		return key, fmt.Errorf("invalid sort %q", item)
+	}
+
+	if !sortColumns[key.Column] {
+		return key, fmt.Errorf("cannot sort by %q", key.Column)
+	}
+	return key, nil
+}
+
+func (s Sort) String() string {
+	items := make([]string, len(s))
+	for i, key := range s {
+		items[i] = key.Column
+		if key.Desc {
+			items[i] = "-" + key.Column
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// Total returns s followed by transaction_id, which runs in the direction
+// of the last key.
+func (s Sort) Total() Sort {
+	total := append(Sort{}, s...)
+	desc := len(s) > 0 && s[len(s)-1].Desc
+	return append(total, SortKey{Column: sortTiebreaker, Desc: desc})
+}
+
+// Reverse flips the direction of every key.
+func (s Sort) Reverse() Sort {
+	reversed := make(Sort, len(s))
+	for i, key := range s {
+		reversed[i] = SortKey{Column: key.Column, Desc: !key.Desc}
+	}
+	return reversed
+}
+
+// sortSpec validates a sort specification given as a string.
+var sortSpec = validation.By(func(value interface{}) error {
+	spec, _ := value.(string)
+	if spec == "" {
+		return nil
+	}
+	_, err := ParseSort(spec)
+	return err
+})
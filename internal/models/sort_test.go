@@ -0,0 +1,72 @@
+package models_test
+
+import (
+	"avito-tech/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     string
+		expected models.Sort
+		wantErr  bool
+	}{
+		{
+			name:     "one column",
+			spec:     "amount",
+			expected: models.Sort{{Column: "amount"}},
+		},
+		{
+			name:     "several columns",
+			spec:     "-date_time, +amount",
+			expected: models.Sort{{Column: "date_time", Desc: true}, {Column: "amount"}},
+		},
+		{
+			name:     "old style",
+			spec:     "date_time DESC",
+			expected: models.Sort{{Column: "date_time", Desc: true}},
+		},
+		{
+			name:    "not whitelisted",
+			spec:    "-comment",
+			wantErr: true,
+		},
+		{
+			name:    "injection",
+			spec:    "amount; DROP TABLE transactions",
+			wantErr: true,
+		},
+		{
+			name:    "empty item",
+			spec:    "amount,",
+			wantErr: true,
+		},
+		{
+			name:    "twice",
+			spec:    "amount,-amount",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sort, err := models.ParseSort(tc.spec)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, sort)
+		})
+	}
+}
+
+func TestSort_Total(t *testing.T) {
+	sort := models.Sort{{Column: "amount"}, {Column: "date_time", Desc: true}}
+	assert.Equal(t, "amount,-date_time,-transaction_id", sort.Total().String())
+	assert.Equal(t, "-amount,date_time,transaction_id", sort.Total().Reverse().String())
+	assert.Equal(t, "transaction_id", models.Sort(nil).Total().String())
+}
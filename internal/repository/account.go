@@ -3,285 +3,546 @@ package repository
 import (
 	"avito-tech/internal/models"
 	"avito-tech/pkg/logger"
+	"context"
+	"errors"
 	"time"
 
 	"database/sql"
+
+	"github.com/lib/pq"
 )
 
 type account struct {
-	db     *sql.DB
-	logger logger.Logger
+	db         *sql.DB
+	logger     logger.Logger
+	autoCreate bool
 }
 
-func NewAccountRepository(db *sql.DB, logger logger.Logger) (repository Account) {
+func NewAccountRepository(db *sql.DB, logger logger.Logger, autoCreate bool) (repository Account) {
 	return &account{
-		db:     db,
-		logger: logger,
+		db:         db,
+		logger:     logger,
+		autoCreate: autoCreate,
 	}
 }
 
-func (rep *account) GetBalanceByID(id int) (acc *models.Account, err error) {
-	acc = &models.Account{}
-	query := `SELECT account_id,
-					balance
-			FROM accounts
-			WHERE account_id = $1`
-
-	if err = rep.db.QueryRow(query, id).
-		Scan(
-			&acc.ID,
-			&acc.Balance,
-		); err != nil {
-		rep.logger.Errorf("error occurred while getting message by id, err: %s", err)
-		return nil, err
+// GetBalanceByID returns the account with its balance in every currency it
+// holds, or ErrUserDoesntExist when there is no such account. System accounts
+// keep no balances, so theirs are summed up from their postings.
+func (rep *account) GetBalanceByID(ctx context.Context, id int) (acc *models.Account, err error) {
+	query := `SELECT a.account_id,
+					a.status,
+					a.created_at,
+					a.frozen_at,
+					a.closed_at,
+					a.version,
+					b.currency,
+					b.balance
+			FROM accounts a
+			LEFT JOIN balances b ON b.account_id = a.account_id
+			WHERE a.account_id = $1
+			ORDER BY b.currency`
+	if isSystemAccount(id) {
+		query = `SELECT a.account_id,
+					a.status,
+					a.created_at,
+					a.frozen_at,
+					a.closed_at,
+					a.version,
+					p.currency,
+					p.balance
+			FROM accounts a
+			LEFT JOIN (
+				SELECT account_id, currency, sum(amount) AS balance
+				FROM postings
+				WHERE account_id = $1
+				GROUP BY account_id, currency
+			) p ON p.account_id = a.account_id
+			WHERE a.account_id = $1
+			ORDER BY p.currency`
 	}
 
-	return acc, nil
-}
-
-func (rep *account) ChangeBalance(acc *models.AccountDebit) (err error) {
-	tx, err := rep.db.Begin()
+	accounts, err := rep.queryAccounts(ctx, query, id)
 	if err != nil {
-		return err
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting message by id, err: %s", err)
+		return nil, err
 	}
-	query := `UPDATE accounts
-			SET balance = balance + ($2)
-			WHERE account_id = $1`
 
-	result, err := tx.Exec(query,
-		acc.AccountID,
-		acc.Amount)
-	if err != nil {
-		tx.Rollback()
-		return err
+	if len(accounts) == 0 {
+		return nil, ErrUserDoesntExist
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return &accounts[0], nil
+}
 
-	if rowsAffected != 1 {
-		err = rep.insert(acc.AccountID, acc.Amount, acc.Comment)
-		if err != nil {
+func (rep *account) ChangeBalance(ctx context.Context, acc *models.AccountDebit) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		now := time.Now().UTC()
+
+		if acc.Amount < 0 {
+			entry := transfer(acc.AccountID, WithdrawalsAccountID, acc.Currency, -acc.Amount, acc.Comment, now)
+			entry.ExpectedVersions = expectVersion(acc.AccountID, acc.ExpectedVersion)
+			err := postEntry(ctx, q, entry)
+			if err == ErrUserDoesntExist {
+				return ErrNewAccNegativeBalance
+			}
 			return err
 		}
-		return nil
-	}
 
-	th := &models.TransactionHistory{
-		AccountID: acc.AccountID,
-		Amount:    acc.Amount,
-		Date:      time.Now(),
-		Comment:   acc.Comment,
-	}
-	err = rep.addInTransactionsHistory(th)
+		if rep.autoCreate {
+			err := ensureAccount(ctx, q, acc.AccountID)
+			if err != nil {
+				return err
+			}
+		}
+
+		entry := transfer(DepositsAccountID, acc.AccountID, acc.Currency, acc.Amount, acc.Comment, now)
+		entry.ExpectedVersions = expectVersion(acc.AccountID, acc.ExpectedVersion)
+		return postEntry(ctx, q, entry)
+	})
+}
+
+func (rep *account) GetAll(ctx context.Context) (accounts []models.Account, err error) {
+	query := `SELECT a.account_id,
+					a.status,
+					a.created_at,
+					a.frozen_at,
+					a.closed_at,
+					a.version,
+					b.currency,
+					b.balance
+			FROM accounts a
+			LEFT JOIN balances b ON b.account_id = a.account_id
+			WHERE a.account_id > 0
+			ORDER BY a.account_id, b.currency`
+
+	accounts, err = rep.queryAccounts(ctx, query)
 	if err != nil {
-		tx.Rollback()
-		return err
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting all accounts. err: %s", err)
+		return nil, err
 	}
 
-	return tx.Commit()
+	return accounts, nil
 }
 
-func (rep *account) GetAll() (accounts []models.Account, err error) {
-	query := `SELECT account_id, 
-					balance
-			FROM accounts`
-
-	rows, err := rep.db.Query(query)
+// queryAccounts collects (account_id, status, created_at, frozen_at,
+// closed_at, version, currency, balance) rows ordered by account into
+// accounts with a balance per currency.
+func (rep *account) queryAccounts(ctx context.Context, query string, args ...interface{}) (accounts []models.Account, err error) {
+	rows, err := rep.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		rep.logger.Errorf("error occurred while getting all accounts. err: %s", err)
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		account := models.Account{}
+		var (
+			acc      models.Account
+			currency sql.NullString
+			balance  models.Money
+		)
 		if err = rows.Scan(
-			&account.ID,
-			&account.Balance,
+			&acc.ID,
+			&acc.Status,
+			&acc.CreatedAt,
+			&acc.FrozenAt,
+			&acc.ClosedAt,
+			&acc.Version,
+			&currency,
+			&balance,
 		); err != nil {
-			rep.logger.Errorf("error occurred while getting all accounts. err: %s", err)
 			return nil, err
 		}
 
-		accounts = append(accounts, account)
+		if len(accounts) == 0 || accounts[len(accounts)-1].ID != acc.ID {
+			acc.Balances = map[string]models.Money{}
+			accounts = append(accounts, acc)
+		}
+		if currency.Valid {
+			accounts[len(accounts)-1].Balances[currency.String] = balance
+		}
 	}
 
-	return accounts, nil
+	return accounts, rows.Err()
 }
 
-func (rep *account) MoneyTransaction(transaction *models.Transaction) (err error) {
-	tx, err := rep.db.Begin()
-	if err != nil {
-		return err
-	}
-	err = func() error {
-		query := `UPDATE accounts 
- 				SET balance = balance - $2 
-  				WHERE account_id = $1;`
+// MoneyTransaction moves money between two accounts. When the receiver
+// currency differs, the amount is converted at transaction.Rate.
+func (rep *account) MoneyTransaction(ctx context.Context, transaction *models.Transaction) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		return postEntry(ctx, q, transactionEntry(transaction, time.Now().UTC()))
+	})
+}
 
-		result, err := tx.Exec(query,
-			transaction.SenderID,
-			transaction.Amount)
-		if err != nil {
-			tx.Rollback()
-			return err
+// MoneyTransactions applies transactions in order in a single database
+// transaction, locking every account they touch up front. Unless bestEffort
+// is set, the first transaction to fail rolls all of them back and is
+// returned as a *BatchError. With bestEffort every transaction runs in a
+// savepoint: one that fails is rolled back alone and its error is returned at
+// its index in errs, and the others are committed.
+func (rep *account) MoneyTransactions(ctx context.Context, transactions []models.Transaction, bestEffort bool) (errs []error, err error) {
+	err = inTransaction(ctx, rep.db, func(q Querier) error {
+		now := time.Now().UTC()
+		entries := make([]*models.JournalEntry, len(transactions))
+		for i := range transactions {
+			entries[i] = transactionEntry(&transactions[i], now)
 		}
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			tx.Rollback()
+		if _, err := lockAccountIDs(ctx, q, entryAccountIDs(entries...)); err != nil {
 			return err
 		}
 
-		if rowsAffected != 1 {
-			tx.Rollback()
-			return ErrUserDoesntExist
-		}
-
-		th := &models.TransactionHistory{
-			AccountID: transaction.SenderID,
-			Amount:    -(transaction.Amount),
-			Date:      time.Now(),
-			Comment:   transaction.Comment,
+		errs = make([]error, len(entries))
+		for i, entry := range entries {
+			if !bestEffort {
+				if err := postEntry(ctx, q, entry); err != nil {
+					return &BatchError{Index: i, Err: err}
+				}
+				continue
+			}
+
+			var err error
+			errs[i], err = postInSavepoint(ctx, q, entry)
+			if err != nil {
+				return err
+			}
 		}
-		err = rep.addInTransactionsHistory(th)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
 		return nil
-	}()
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = func() error {
-		query := `UPDATE accounts 
- 				SET balance = balance + $2 
-  				WHERE account_id = $1;`
+	return errs, nil
+}
 
-		result, err := tx.Exec(query,
-			transaction.ReceiverID,
-			transaction.Amount)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+// transactionEntry builds the ledger entry of a transfer between two
+// accounts, converting the amount when the receiver currency differs.
+func transactionEntry(transaction *models.Transaction, now time.Time) *models.JournalEntry {
+	entry := transfer(transaction.SenderID, transaction.ReceiverID, transaction.Currency, transaction.Amount, transaction.Comment, now)
+	if transaction.ReceiverCurrency != "" && transaction.ReceiverCurrency != transaction.Currency {
+		entry = exchangeTransfer(transaction.SenderID, transaction.ReceiverID, transaction.Currency, transaction.ReceiverCurrency,
+			transaction.Amount, transaction.Rate, transaction.Comment, now)
+	}
+	entry.ExpectedVersions = expectVersion(transaction.SenderID, transaction.ExpectedVersion)
+
+	return entry
+}
 
-		rowsAffected, err := result.RowsAffected()
+// postInSavepoint posts entry in a savepoint. When posting fails, the
+// savepoint is rolled back, which leaves the transaction usable, and the
+// failure is returned as failed. Failures caused by concurrent transactions
+// or by ctx are returned as err instead, as the transaction cannot go on.
+func postInSavepoint(ctx context.Context, q Querier, entry *models.JournalEntry) (failed, err error) {
+	if _, err = q.ExecContext(ctx, `SAVEPOINT batch_item`); err != nil {
+		return nil, err
+	}
+
+	failed = postEntry(ctx, q, entry)
+	if failed == nil {
+		_, err = q.ExecContext(ctx, `RELEASE SAVEPOINT batch_item`)
+		return nil, err
+	}
+	if isRetryable(failed) || ctx.Err() != nil {
+		return nil, failed
+	}
+
+	if _, err = q.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_item`); err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+// balanceConstraint keeps customer balances from going negative.
+const balanceConstraint = "balance_cannot_be_negative"
+
+// updateBalance adds amount (negative for debits) to the balance of a
+// customer account in currency, opening that balance on first use. A debit
+// larger than the balance fails with an *InsufficientFundsError. The account must be active,
+// or frozen when allowFrozen is set; the account row is share-locked so it
+// cannot be frozen or closed until the transaction ends.
+func updateBalance(ctx context.Context, q Querier, id int, currency string, amount models.Money, allowFrozen bool) (err error) {
+	var balance models.Money
+	if amount < 0 {
+		var found bool
+		balance, found, err = lockBalance(ctx, q, id, currency)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-
-		if rowsAffected != 1 {
-			tx.Rollback()
-			return ErrUserDoesntExist
+		if found && balance < -amount {
+			return &InsufficientFundsError{AccountID: id, Currency: currency, Balance: balance, Requested: -amount}
 		}
+	}
 
-		th := &models.TransactionHistory{
-			AccountID: transaction.ReceiverID,
-			Amount:    transaction.Amount,
-			Date:      time.Now(),
-			Comment:   transaction.Comment,
-		}
-		err = rep.addInTransactionsHistory(th)
-		if err != nil {
-			tx.Rollback()
-			return err
+	query := `INSERT INTO balances (account_id, currency, balance)
+			SELECT account_id, $2, $3
+			FROM accounts
+			WHERE account_id = $1 AND (status = 'active' OR $4 AND status = 'frozen')
+			FOR SHARE
+			ON CONFLICT (account_id, currency) DO UPDATE
+			SET balance = balances.balance + EXCLUDED.balance`
+
+	result, err := q.ExecContext(ctx, query,
+		id,
+		currency,
+		amount,
+		allowFrozen)
+	if err != nil {
+		// a debit from a balance that was never opened is only caught
+		// by the constraint.
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Constraint == balanceConstraint {
+			return &InsufficientFundsError{AccountID: id, Currency: currency, Balance: balance, Requested: -amount}
 		}
+		return err
+	}
 
-		return nil
-	}()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	if rowsAffected != 1 {
+		return statusError(ctx, q, id)
+	}
+
+	return nil
 }
 
-func (rep *account) insert(id int, balance float64, comment string) (err error) {
-	if balance <= 0 {
-		return ErrNewAccNegativeBalance
-	}
-	tx, err := rep.db.Begin()
+// statusError explains why an account took no movement: it does not exist,
+// or it is frozen or closed.
+func statusError(ctx context.Context, q Querier, id int) (err error) {
+	status, err := accountStatus(ctx, q, id)
 	if err != nil {
 		return err
 	}
-	query := `INSERT INTO accounts (account_id, balance)
-			VALUES ($1, $2) ;`
 
-	result, err := tx.Exec(query,
-		id,
-		balance)
+	switch status {
+	case models.AccountFrozen:
+		return ErrAccountFrozen
+	case models.AccountClosed:
+		return ErrAccountClosed
+	default:
+		return ErrNoRowsAffected
+	}
+}
+
+// accountState returns the status and version of an account.
+func accountState(ctx context.Context, q Querier, id int) (status string, version int64, err error) {
+	query := `SELECT status, version FROM accounts WHERE account_id = $1`
+
+	err = q.QueryRowContext(ctx, query, id).Scan(&status, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, ErrUserDoesntExist
+	}
+
+	return status, version, err
+}
+
+func accountStatus(ctx context.Context, q Querier, id int) (status string, err error) {
+	query := `SELECT status FROM accounts WHERE account_id = $1`
+
+	err = q.QueryRowContext(ctx, query, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserDoesntExist
+	}
+
+	return status, err
+}
+
+// lockBalance returns the account balance in currency and locks it until the
+// transaction ends. found is false when that balance was never opened.
+func lockBalance(ctx context.Context, q Querier, id int, currency string) (balance models.Money, found bool, err error) {
+	query := `SELECT balance
+			FROM balances
+			WHERE account_id = $1 AND currency = $2
+			FOR UPDATE`
+
+	err = q.QueryRowContext(ctx, query, id, currency).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return balance, true, nil
+}
+
+// Create opens an empty active account.
+func (rep *account) Create(ctx context.Context, id int) (err error) {
+	query := `INSERT INTO accounts (account_id)
+			VALUES ($1)
+			ON CONFLICT (account_id) DO NOTHING`
+
+	result, err := rep.db.ExecContext(ctx, query, id)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		tx.Rollback()
-		return ErrUserDoesntExist
+		return err
 	}
 
 	if rowsAffected != 1 {
-		tx.Rollback()
-		return ErrNoRowsAffected
+		return ErrAccountExists
+	}
+
+	return nil
+}
+
+// Freeze stops debits and transfers on an active account. Freezing a frozen
+// account does nothing. A non-zero version must match the account's.
+func (rep *account) Freeze(ctx context.Context, id int, version int64) (err error) {
+	query := `UPDATE accounts
+			SET status = 'frozen', frozen_at = $3, version = version + 1
+			WHERE account_id = $1 AND status = 'active' AND ($2::bigint = 0 OR version = $2)`
+
+	return rep.changeStatus(ctx, id, version, models.AccountFrozen, query, id, version, time.Now().UTC())
+}
+
+// Unfreeze makes a frozen account active again. Unfreezing an active account
+// does nothing. A non-zero version must match the account's.
+func (rep *account) Unfreeze(ctx context.Context, id int, version int64) (err error) {
+	query := `UPDATE accounts
+			SET status = 'active', frozen_at = NULL, version = version + 1
+			WHERE account_id = $1 AND status = 'frozen' AND ($2::bigint = 0 OR version = $2)`
+
+	return rep.changeStatus(ctx, id, version, models.AccountActive, query, id, version)
+}
+
+// changeStatus runs a status update and, when it changed nothing, reports
+// whether the account was at another version, already in status or cannot
+// get there.
+func (rep *account) changeStatus(ctx context.Context, id int, version int64, status, query string, args ...interface{}) (err error) {
+	result, err := rep.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
 	}
 
-	err = tx.Commit()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	transactionHistory := &models.TransactionHistory{
-		AccountID: id,
-		Amount:    balance,
-		Date:      time.Now(),
-		Comment:   comment,
+
+	if rowsAffected == 1 {
+		return nil
 	}
-	err = rep.addInTransactionsHistory(transactionHistory)
+
+	current, currentVersion, err := accountState(ctx, rep.db, id)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	if version != 0 && version != currentVersion {
+		return ErrVersionMismatch
+	}
+	if current == models.AccountClosed {
+		return ErrAccountClosed
+	}
+	if current != status {
+		return ErrNoRowsAffected
+	}
 
 	return nil
 }
 
-func (rep *account) addInTransactionsHistory(tr *models.TransactionHistory) (err error) {
+// Close closes an account for good. Every balance must be zero and no money
+// may be reserved. Closing a closed account does nothing. A non-zero version
+// must match the account's.
+func (rep *account) Close(ctx context.Context, id int, version int64) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		// the lock waits for movements in flight and keeps new ones out.
+		var (
+			status         string
+			currentVersion int64
+		)
+		err := q.QueryRowContext(ctx, `SELECT status, version FROM accounts WHERE account_id = $1 FOR UPDATE`, id).
+			Scan(&status, &currentVersion)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserDoesntExist
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && version != currentVersion {
+			return ErrVersionMismatch
+		}
+		if status == models.AccountClosed {
+			return nil
+		}
+
+		query := `SELECT EXISTS (SELECT 1 FROM balances WHERE account_id = $1 AND balance <> 0)
+				OR EXISTS (SELECT 1 FROM reservations WHERE account_id = $1 AND status = 'reserved')`
 
-	tx, err := rep.db.Begin()
-	if err != nil {
+		var holdsMoney bool
+		if err = q.QueryRowContext(ctx, query, id).Scan(&holdsMoney); err != nil {
+			return err
+		}
+		if holdsMoney {
+			return ErrAccountNotEmpty
+		}
+
+		query = `UPDATE accounts
+				SET status = 'closed', closed_at = $2, version = version + 1
+				WHERE account_id = $1`
+
+		_, err = q.ExecContext(ctx, query, id, time.Now().UTC())
 		return err
-	}
-	query := `INSERT INTO transactions (account_id, amount, date_time, comment)
-			VALUES ($1,$2, $3, $4)`
+	})
+}
+
+// ensureAccount creates an empty account the first time money is credited to
+// an unknown ID, when accounts are created implicitly.
+func ensureAccount(ctx context.Context, q Querier, id int) (err error) {
+	query := `INSERT INTO accounts (account_id)
+			VALUES ($1)
+			ON CONFLICT (account_id) DO NOTHING`
+
+	_, err = q.ExecContext(ctx, query, id)
+	return err
+}
 
-	result, err := tx.Exec(query,
+func addInTransactionsHistory(ctx context.Context, q Querier, tr *models.TransactionHistory) (err error) {
+	query := `INSERT INTO transactions (account_id, amount, date_time, comment, entry_id, counterparty_id, currency, rate, refund_of)
+			VALUES ($1,$2, $3, $4, $5, $6, $7, $8, $9)`
+
+	result, err := q.ExecContext(ctx, query,
 		tr.AccountID,
 		tr.Amount,
 		tr.Date,
-		tr.Comment)
+		tr.Comment,
+		nullID(tr.EntryID),
+		nullID(tr.CounterpartyID),
+		tr.Currency,
+		nullRate(tr.Rate),
+		nullID(tr.RefundOf))
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	if rowsAffected != 1 {
-		tx.Rollback()
 		return ErrNoRowsAffected
 	}
 
-	return tx.Commit()
+	return nil
+}
+
+// nullID stores a zero ID as NULL.
+func nullID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullRate stores a missing exchange rate as NULL.
+func nullRate(rate float64) interface{} {
+	if rate == 0 {
+		return nil
+	}
+	return rate
 }
@@ -4,23 +4,38 @@ import (
 	"avito-tech/internal/models"
 	"avito-tech/internal/repository"
 	"avito-tech/pkg/logger"
+	"context"
 	"errors"
+	"fmt"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
 var log = logger.GetLogger()
 
+const (
+	balancesByIDQuery = "SELECT a.account_id, a.status, a.created_at, a.frozen_at, a.closed_at, a.version, b.currency, b.balance FROM accounts a LEFT JOIN balances b ON b.account_id = a.account_id WHERE a.account_id = $1 ORDER BY b.currency"
+	allBalancesQuery  = "SELECT a.account_id, a.status, a.created_at, a.frozen_at, a.closed_at, a.version, b.currency, b.balance FROM accounts a LEFT JOIN balances b ON b.account_id = a.account_id WHERE a.account_id > 0 ORDER BY a.account_id, b.currency"
+)
+
+var (
+	accountColumns = []string{"account_id", "status", "created_at", "frozen_at", "closed_at", "version", "currency", "balance"}
+	createdAt      = time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	changedAt      = time.Date(2022, 11, 2, 10, 0, 0, 0, time.UTC)
+)
+
 func Test_GetBalanceByID(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
-	r := repository.NewAccountRepository(db, log)
+	r := repository.NewAccountRepository(db, log, true)
 
 	testTable := []struct {
 		name           string
@@ -33,21 +48,68 @@ func Test_GetBalanceByID(t *testing.T) {
 			name: "OK",
 			id:   1,
 			mock: func(id int) {
-				rows := sqlmock.NewRows([]string{"account_id", "balance"}).
-					AddRow(1, 11.623413)
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT account_id, balance FROM accounts WHERE account_id = $1")).WithArgs(id).WillReturnRows(rows)
+				rows := sqlmock.NewRows(accountColumns).
+					AddRow(1, "active", createdAt, nil, nil, 2, "RUB", "11.62").AddRow(1, "active", createdAt, nil, nil, 2, "USD", "0.50")
+				mock.ExpectQuery(regexp.QuoteMeta(balancesByIDQuery)).WithArgs(id).WillReturnRows(rows)
 			},
 			expectedResult: &models.Account{
-				ID:      1,
-				Balance: 11.623413,
+				ID:        1,
+				Status:    models.AccountActive,
+				Balances:  map[string]models.Money{"RUB": 116200, "USD": 5000},
+				CreatedAt: &createdAt,
+				Version:   2,
 			},
 			expectedError: false,
 		},
+		{
+			name: "system account",
+			id:   repository.DepositsAccountID,
+			mock: func(id int) {
+				rows := sqlmock.NewRows(accountColumns).AddRow(id, "active", createdAt, nil, nil, 0, "RUB", "-500.00")
+				mock.ExpectQuery(regexp.QuoteMeta("FROM accounts a LEFT JOIN ( SELECT account_id, currency, sum(amount) AS balance FROM postings")).
+					WithArgs(id).WillReturnRows(rows)
+			},
+			expectedResult: &models.Account{
+				ID:        repository.DepositsAccountID,
+				Status:    models.AccountActive,
+				Balances:  map[string]models.Money{"RUB": -5000000},
+				CreatedAt: &createdAt,
+			},
+		},
+		{
+			name: "no balances yet",
+			id:   1,
+			mock: func(id int) {
+				rows := sqlmock.NewRows(accountColumns).
+					AddRow(1, "frozen", createdAt, changedAt, nil, 2, nil, nil)
+				mock.ExpectQuery(regexp.QuoteMeta(balancesByIDQuery)).WithArgs(id).WillReturnRows(rows)
+			},
+			expectedResult: &models.Account{
+				ID:        1,
+				Status:    models.AccountFrozen,
+				Balances:  map[string]models.Money{},
+				CreatedAt: &createdAt,
+				Version:   2,
+				FrozenAt:  &changedAt,
+			},
+			expectedError: false,
+		},
+		{
+			name: "no such account",
+			id:   1,
+			mock: func(id int) {
+				rows := sqlmock.NewRows(accountColumns)
+				mock.ExpectQuery(regexp.QuoteMeta(balancesByIDQuery)).WithArgs(id).WillReturnRows(rows)
+			},
+
+			expectedResult: nil,
+			expectedError:  true,
+		},
 		{
 			name: "no rows",
 			id:   1,
 			mock: func(id int) {
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT account_id, balance FROM accounts WHERE account_id = $1")).WithArgs(id).WillReturnError(errors.New("no rows"))
+				mock.ExpectQuery(regexp.QuoteMeta(balancesByIDQuery)).WithArgs(id).WillReturnError(errors.New("no rows"))
 			},
 
 			expectedResult: nil,
@@ -57,7 +119,7 @@ func Test_GetBalanceByID(t *testing.T) {
 	for _, tt := range testTable {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock(tt.id)
-			result, err := r.GetBalanceByID(tt.id)
+			result, err := r.GetBalanceByID(context.Background(), tt.id)
 			if tt.expectedError {
 				assert.Error(t, err)
 			} else {
@@ -75,7 +137,7 @@ func Test_GetAll(t *testing.T) {
 		log.Fatal(err)
 	}
 	defer db.Close()
-	r := repository.NewAccountRepository(db, log)
+	r := repository.NewAccountRepository(db, log, true)
 
 	testTable := []struct {
 		name           string
@@ -86,18 +148,35 @@ func Test_GetAll(t *testing.T) {
 		{
 			name: "OK",
 			mock: func() {
-				rows := sqlmock.NewRows([]string{"account_id", "balance"}).
-					AddRow(1, 11.623413).AddRow(2, 22)
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT account_id, balance FROM accounts")).WillReturnRows(rows)
+				rows := sqlmock.NewRows(accountColumns).
+					AddRow(1, "active", createdAt, nil, nil, 2, "RUB", "11.62").
+					AddRow(1, "active", createdAt, nil, nil, 2, "USD", "3.00").
+					AddRow(2, "active", createdAt, nil, nil, 2, "RUB", 22).
+					AddRow(3, "closed", createdAt, nil, changedAt, 2, nil, nil)
+				mock.ExpectQuery(regexp.QuoteMeta(allBalancesQuery)).WillReturnRows(rows)
 			},
 			expectedResult: []models.Account{
 				{
-					ID:      1,
-					Balance: 11.623413,
+					ID:        1,
+					Status:    models.AccountActive,
+					Balances:  map[string]models.Money{"RUB": 116200, "USD": 30000},
+					CreatedAt: &createdAt,
+					Version:   2,
 				},
 				{
-					ID:      2,
-					Balance: 22,
+					ID:        2,
+					Status:    models.AccountActive,
+					Balances:  map[string]models.Money{"RUB": 220000},
+					CreatedAt: &createdAt,
+					Version:   2,
+				},
+				{
+					ID:        3,
+					Status:    models.AccountClosed,
+					Balances:  map[string]models.Money{},
+					CreatedAt: &createdAt,
+					Version:   2,
+					ClosedAt:  &changedAt,
 				},
 			},
 
@@ -106,7 +185,7 @@ func Test_GetAll(t *testing.T) {
 		{
 			name: "no rows",
 			mock: func() {
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT account_id, balance FROM accounts")).WillReturnError(errors.New("no rows"))
+				mock.ExpectQuery(regexp.QuoteMeta(allBalancesQuery)).WillReturnError(errors.New("no rows"))
 			},
 
 			expectedResult: nil,
@@ -116,7 +195,7 @@ func Test_GetAll(t *testing.T) {
 	for _, tt := range testTable {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock()
-			result, err := r.GetAll()
+			result, err := r.GetAll(context.Background())
 			if tt.expectedError {
 				assert.Error(t, err)
 			} else {
@@ -128,49 +207,808 @@ func Test_GetAll(t *testing.T) {
 	}
 }
 
+func Test_MoneyTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, true)
+
+	tr := &models.Transaction{
+		ReceiverID: 1,
+		SenderID:   2,
+		Amount:     20000,
+		Comment:    "2",
+		Currency:   "RUB",
+	}
+	steps := transferSteps(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+
+	// fail at every statement in turn; the transaction must always roll back.
+	for failAt := range steps {
+		t.Run(fmt.Sprintf("step %d fails", failAt), func(t *testing.T) {
+			mock.ExpectBegin()
+			for i := 0; i <= failAt; i++ {
+				steps[i](i == failAt)
+			}
+			mock.ExpectRollback()
+
+			err := r.MoneyTransaction(context.Background(), tr)
+			assert.Equal(t, errStep, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectTransfer(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+		mock.ExpectCommit()
+
+		err := r.MoneyTransaction(context.Background(), tr)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("sender at expected version", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectTransfer(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+		mock.ExpectCommit()
+
+		at := *tr
+		at.ExpectedVersion = lockedVersion
+		err := r.MoneyTransaction(context.Background(), &at)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("sender changed", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "version"}).AddRow(1, lockedVersion).AddRow(2, lockedVersion+1))
+		mock.ExpectRollback()
+
+		stale := *tr
+		stale.ExpectedVersion = lockedVersion
+		err := r.MoneyTransaction(context.Background(), &stale)
+		assert.Equal(t, repository.ErrVersionMismatch, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("receiver does not exist", func(t *testing.T) {
+		mock.ExpectBegin()
+		for _, step := range steps[:6] {
+			step(false)
+		}
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(tr.ReceiverID, tr.Currency, tr.Amount, false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectAccountStatus(mock, tr.ReceiverID, "")
+		mock.ExpectRollback()
+
+		err := r.MoneyTransaction(context.Background(), tr)
+		assert.Equal(t, repository.ErrUserDoesntExist, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("converted through the exchange account", func(t *testing.T) {
+		exchange := &models.Transaction{
+			ReceiverID:       1,
+			SenderID:         2,
+			Amount:           10000000,
+			Comment:          "2",
+			Currency:         "RUB",
+			ReceiverCurrency: "USD",
+			Rate:             0.016,
+		}
+		postings := []struct {
+			accountID, counterpartyID int
+			currency                  string
+			amount                    models.Money
+		}{
+			{exchange.SenderID, exchange.ReceiverID, "RUB", -10000000},
+			{repository.ExchangeAccountID, 0, "RUB", 10000000},
+			{repository.ExchangeAccountID, 0, "USD", -160000},
+			{exchange.ReceiverID, exchange.SenderID, "USD", 160000},
+		}
+
+		mock.ExpectBegin()
+		expectLockAccounts(mock, repository.ExchangeAccountID, exchange.ReceiverID, exchange.SenderID)
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO journal_entries (date_time, comment) VALUES ($1, $2) RETURNING entry_id")).
+			WithArgs(sqlmock.AnyArg(), exchange.Comment).
+			WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(8))
+		for _, p := range postings {
+			if p.accountID == exchange.SenderID {
+				expectLockBalance(mock, p.accountID, p.currency, -p.amount)
+			}
+			if p.accountID > 0 {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).
+					WithArgs(p.accountID, p.currency, p.amount, false).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO postings (entry_id, account_id, currency, amount)")).
+				WithArgs(8, p.accountID, p.currency, p.amount).WillReturnResult(sqlmock.NewResult(1, 1))
+			if p.accountID < 0 {
+				continue
+			}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (account_id, amount, date_time, comment, entry_id, counterparty_id, currency, rate, refund_of)")).
+				WithArgs(p.accountID, p.amount, sqlmock.AnyArg(), exchange.Comment, 8, p.counterpartyID, p.currency, exchange.Rate, nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		mock.ExpectCommit()
+
+		err := r.MoneyTransaction(context.Background(), exchange)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("converted amount rounds to zero", func(t *testing.T) {
+		tiny := &models.Transaction{
+			ReceiverID:       1,
+			SenderID:         2,
+			Amount:           100,
+			Comment:          "2",
+			Currency:         "RUB",
+			ReceiverCurrency: "USD",
+			Rate:             0.016,
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err := r.MoneyTransaction(context.Background(), tiny)
+		assert.Equal(t, repository.ErrZeroPosting, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_MoneyTransactionRetries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, true)
+
+	tr := &models.Transaction{
+		ReceiverID: 1,
+		SenderID:   2,
+		Amount:     20000,
+		Comment:    "2",
+		Currency:   "RUB",
+	}
+	deadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+	serialization := &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	expectDeadlock := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).WillReturnError(deadlock)
+		mock.ExpectRollback()
+	}
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "deadlock",
+			mock: func() {
+				expectDeadlock()
+				mock.ExpectBegin()
+				expectTransfer(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "serialization failure on commit",
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+				mock.ExpectCommit().WillReturnError(serialization)
+				mock.ExpectBegin()
+				expectTransfer(mock, 8, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "gives up",
+			mock: func() {
+				for i := 0; i < 5; i++ {
+					expectDeadlock()
+				}
+			},
+			expectedError: deadlock,
+		},
+		{
+			name: "other errors are not retried",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).WillReturnError(&pq.Error{Code: "57014", Message: "canceling statement due to user request"})
+				mock.ExpectRollback()
+			},
+			expectedError: &pq.Error{Code: "57014", Message: "canceling statement due to user request"},
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.MoneyTransaction(context.Background(), tr)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func Test_ChangeBalance(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
-	r := repository.NewAccountRepository(db, log)
+	r := repository.NewAccountRepository(db, log, true)
+
+	deposit := &models.AccountDebit{
+		AccountID: 1,
+		Amount:    50000,
+		Comment:   "Salary",
+		Currency:  "RUB",
+	}
+	withdrawal := &models.AccountDebit{
+		AccountID: 1,
+		Amount:    -50000,
+		Comment:   "Cash",
+		Currency:  "RUB",
+	}
+	expectEnsure := func() {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO accounts (account_id) VALUES ($1) ON CONFLICT (account_id) DO NOTHING")).
+			WithArgs(deposit.AccountID).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
 
 	testTable := []struct {
 		name          string
-		acc           *models.Transaction
-		mock          func(acc *models.Transaction)
-		expectedError bool
+		acc           *models.AccountDebit
+		mock          func()
+		expectedError error
 	}{
 		{
-			name: "OK",
-			acc: &models.Transaction{
-				ReceiverID: 1,
-				SenderID:   2,
-				Amount:     2,
-				Comment:    "2",
-			},
-			mock: func(acc *models.Transaction) {
-				mock.ExpectBegin()
-				query := `UPDATE accounts
-						SET balance = balance - $2
-						WHERE account_id = $1`
-				mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(acc.SenderID, acc.Amount).WillReturnError(errors.New("transaction has already been committed or rolled back"))
+			name: "deposit",
+			acc:  deposit,
+			mock: func() {
+				mock.ExpectBegin()
+				expectEnsure()
+				expectTransfer(mock, 3, repository.DepositsAccountID, deposit.AccountID, deposit.Currency, deposit.Amount, deposit.Comment)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "deposit history fails",
+			acc:  deposit,
+			mock: func() {
+				mock.ExpectBegin()
+				expectEnsure()
+				steps := transferSteps(mock, 3, repository.DepositsAccountID, deposit.AccountID, deposit.Currency, deposit.Amount, deposit.Comment)
+				for i, step := range steps {
+					step(i == len(steps)-1)
+				}
+				mock.ExpectRollback()
+			},
+			expectedError: errStep,
+		},
+		{
+			name: "withdrawal",
+			acc:  withdrawal,
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(mock, 3, withdrawal.AccountID, repository.WithdrawalsAccountID, withdrawal.Currency, -withdrawal.Amount, withdrawal.Comment)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "withdrawal from unknown account",
+			acc:  withdrawal,
+			mock: func() {
+				mock.ExpectBegin()
+				steps := transferSteps(mock, 3, withdrawal.AccountID, repository.WithdrawalsAccountID, withdrawal.Currency, -withdrawal.Amount, withdrawal.Comment)
+				steps[0](false)
+				steps[1](false)
+				expectLockBalance(mock, withdrawal.AccountID, withdrawal.Currency, nil)
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(withdrawal.AccountID, withdrawal.Currency, withdrawal.Amount, false).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				expectAccountStatus(mock, withdrawal.AccountID, "")
 				mock.ExpectRollback()
 			},
+			expectedError: repository.ErrNewAccNegativeBalance,
+		},
+		{
+			name: "insufficient funds",
+			acc:  withdrawal,
+			mock: func() {
+				mock.ExpectBegin()
+				steps := transferSteps(mock, 3, withdrawal.AccountID, repository.WithdrawalsAccountID, withdrawal.Currency, -withdrawal.Amount, withdrawal.Comment)
+				steps[0](false)
+				steps[1](false)
+				expectLockBalance(mock, withdrawal.AccountID, withdrawal.Currency, "3.50")
+				mock.ExpectRollback()
+			},
+			expectedError: &repository.InsufficientFundsError{AccountID: withdrawal.AccountID, Currency: withdrawal.Currency, Balance: 35000, Requested: -withdrawal.Amount},
+		},
+		{
+			name: "insufficient funds in a balance never opened",
+			acc:  withdrawal,
+			mock: func() {
+				mock.ExpectBegin()
+				steps := transferSteps(mock, 3, withdrawal.AccountID, repository.WithdrawalsAccountID, withdrawal.Currency, -withdrawal.Amount, withdrawal.Comment)
+				steps[0](false)
+				steps[1](false)
+				expectLockBalance(mock, withdrawal.AccountID, withdrawal.Currency, nil)
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(withdrawal.AccountID, withdrawal.Currency, withdrawal.Amount, false).
+					WillReturnError(&pq.Error{Code: "23514", Constraint: "balance_cannot_be_negative"})
+				mock.ExpectRollback()
+			},
+			expectedError: &repository.InsufficientFundsError{AccountID: withdrawal.AccountID, Currency: withdrawal.Currency, Balance: 0, Requested: -withdrawal.Amount},
+		},
+		{
+			name: "commit fails",
+			acc:  deposit,
+			mock: func() {
+				mock.ExpectBegin()
+				expectEnsure()
+				expectTransfer(mock, 3, repository.DepositsAccountID, deposit.AccountID, deposit.Currency, deposit.Amount, deposit.Comment)
+				mock.ExpectCommit().WillReturnError(errStep)
+			},
+			expectedError: errStep,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.ChangeBalance(context.Background(), tt.acc)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_ChangeBalanceWithoutAutoCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, false)
+
+	deposit := &models.AccountDebit{
+		AccountID: 1,
+		Amount:    50000,
+		Comment:   "Salary",
+		Currency:  "RUB",
+	}
+	steps := transferSteps(mock, 3, repository.DepositsAccountID, deposit.AccountID, deposit.Currency, deposit.Amount, deposit.Comment)
 
-			expectedError: true,
+	testTable := []struct {
+		name          string
+		status        string
+		expectedError error
+	}{
+		{
+			name:          "unknown account",
+			expectedError: repository.ErrUserDoesntExist,
+		},
+		{
+			name:          "closed account",
+			status:        models.AccountClosed,
+			expectedError: repository.ErrAccountClosed,
 		},
 	}
 	for _, tt := range testTable {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.mock(tt.acc)
-			err := r.MoneyTransaction(tt.acc)
-			if tt.expectedError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+			mock.ExpectBegin()
+			for _, step := range steps[:3] {
+				step(false)
 			}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(deposit.AccountID, deposit.Currency, deposit.Amount, true).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			expectAccountStatus(mock, deposit.AccountID, tt.status)
+			mock.ExpectRollback()
+
+			err := r.ChangeBalance(context.Background(), deposit)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_MoneyTransactionFrozen(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, true)
+
+	tr := &models.Transaction{
+		ReceiverID: 1,
+		SenderID:   2,
+		Amount:     20000,
+		Comment:    "2",
+		Currency:   "RUB",
+	}
+	steps := transferSteps(mock, 7, tr.SenderID, tr.ReceiverID, tr.Currency, tr.Amount, tr.Comment)
+
+	t.Run("sender frozen", func(t *testing.T) {
+		mock.ExpectBegin()
+		for _, step := range steps[:3] {
+			step(false)
+		}
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(tr.SenderID, tr.Currency, -tr.Amount, false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectAccountStatus(mock, tr.SenderID, models.AccountFrozen)
+		mock.ExpectRollback()
+
+		err := r.MoneyTransaction(context.Background(), tr)
+		assert.Equal(t, repository.ErrAccountFrozen, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// a frozen account takes deposits but not transfers from customers.
+	t.Run("receiver frozen", func(t *testing.T) {
+		mock.ExpectBegin()
+		for _, step := range steps[:6] {
+			step(false)
+		}
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).WithArgs(tr.ReceiverID, tr.Currency, tr.Amount, false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectAccountStatus(mock, tr.ReceiverID, models.AccountFrozen)
+		mock.ExpectRollback()
+
+		err := r.MoneyTransaction(context.Background(), tr)
+		assert.Equal(t, repository.ErrAccountFrozen, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, false)
+
+	query := regexp.QuoteMeta("INSERT INTO accounts (account_id) VALUES ($1) ON CONFLICT (account_id) DO NOTHING")
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectExec(query).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "already exists",
+			mock: func() {
+				mock.ExpectExec(query).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedError: repository.ErrAccountExists,
+		},
+		{
+			name: "insert fails",
+			mock: func() {
+				mock.ExpectExec(query).WithArgs(1).WillReturnError(errStep)
+			},
+			expectedError: errStep,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.Create(context.Background(), 1)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_MoneyTransactions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, true)
+
+	salary := func(receiverID int) models.Transaction {
+		return models.Transaction{ReceiverID: receiverID, SenderID: 1, Amount: 1000000, Comment: "salary", Currency: "RUB"}
+	}
+	batch := []models.Transaction{salary(2), salary(3), salary(4)}
+	savepoint := func(statement string) {
+		mock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	failTransfer := func(entryID, to int) {
+		steps := transferSteps(mock, entryID, 1, to, "RUB", 1000000, "salary")
+		steps[0](false)
+		steps[1](true)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectLockAccountRows(mock, 1, 2, 3, 4)
+		expectTransfer(mock, 7, 1, 2, "RUB", 1000000, "salary")
+		expectTransfer(mock, 8, 1, 3, "RUB", 1000000, "salary")
+		expectTransfer(mock, 9, 1, 4, "RUB", 1000000, "salary")
+		mock.ExpectCommit()
+
+		errs, err := r.MoneyTransactions(context.Background(), batch, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectLockAccountRows(mock, 1, 2, 3, 4)
+		expectTransfer(mock, 7, 1, 2, "RUB", 1000000, "salary")
+		failTransfer(8, 3)
+		mock.ExpectRollback()
+
+		errs, err := r.MoneyTransactions(context.Background(), batch, false)
+		assert.Equal(t, &repository.BatchError{Index: 1, Err: errStep}, err)
+		assert.Nil(t, errs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectLockAccountRows(mock, 1, 2, 3, 4)
+		savepoint("SAVEPOINT batch_item")
+		expectTransfer(mock, 7, 1, 2, "RUB", 1000000, "salary")
+		savepoint("RELEASE SAVEPOINT batch_item")
+		savepoint("SAVEPOINT batch_item")
+		failTransfer(8, 3)
+		savepoint("ROLLBACK TO SAVEPOINT batch_item")
+		savepoint("SAVEPOINT batch_item")
+		expectTransfer(mock, 9, 1, 4, "RUB", 1000000, "salary")
+		savepoint("RELEASE SAVEPOINT batch_item")
+		mock.ExpectCommit()
+
+		errs, err := r.MoneyTransactions(context.Background(), batch, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []error{nil, errStep, nil}, errs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("best effort savepoint fails", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectLockAccountRows(mock, 1, 2, 3, 4)
+		mock.ExpectExec(regexp.QuoteMeta("SAVEPOINT batch_item")).WillReturnError(errStep)
+		mock.ExpectRollback()
+
+		errs, err := r.MoneyTransactions(context.Background(), batch, true)
+		assert.Equal(t, errStep, err)
+		assert.Nil(t, errs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_FreezeUnfreeze(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, false)
+
+	freezeQuery := regexp.QuoteMeta("UPDATE accounts SET status = 'frozen', frozen_at = $3, version = version + 1 WHERE account_id = $1 AND status = 'active' AND ($2::bigint = 0 OR version = $2)")
+	unfreezeQuery := regexp.QuoteMeta("UPDATE accounts SET status = 'active', frozen_at = NULL, version = version + 1 WHERE account_id = $1 AND status = 'frozen' AND ($2::bigint = 0 OR version = $2)")
+	expectState := func(status string, version int64) {
+		rows := sqlmock.NewRows([]string{"status", "version"})
+		if status != "" {
+			rows.AddRow(status, version)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT status, version FROM accounts WHERE account_id = $1")).WithArgs(1).WillReturnRows(rows)
+	}
+
+	testTable := []struct {
+		name          string
+		unfreeze      bool
+		version       int64
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "freeze",
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:    "freeze at version",
+			version: 3,
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:    "freeze at stale version",
+			version: 2,
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountActive, 3)
+			},
+			expectedError: repository.ErrVersionMismatch,
+		},
+		{
+			name: "freeze frozen",
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountFrozen, 3)
+			},
+		},
+		{
+			name: "freeze closed",
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountClosed, 3)
+			},
+			expectedError: repository.ErrAccountClosed,
+		},
+		{
+			name: "freeze unknown",
+			mock: func() {
+				mock.ExpectExec(freezeQuery).WithArgs(1, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState("", 0)
+			},
+			expectedError: repository.ErrUserDoesntExist,
+		},
+		{
+			name:     "unfreeze",
+			unfreeze: true,
+			mock: func() {
+				mock.ExpectExec(unfreezeQuery).WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:     "unfreeze active",
+			unfreeze: true,
+			mock: func() {
+				mock.ExpectExec(unfreezeQuery).WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountActive, 3)
+			},
+		},
+		{
+			name:     "unfreeze active at its version",
+			unfreeze: true,
+			version:  3,
+			mock: func() {
+				mock.ExpectExec(unfreezeQuery).WithArgs(1, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountActive, 3)
+			},
+		},
+		{
+			name:     "unfreeze closed",
+			unfreeze: true,
+			mock: func() {
+				mock.ExpectExec(unfreezeQuery).WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectState(models.AccountClosed, 3)
+			},
+			expectedError: repository.ErrAccountClosed,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			action := r.Freeze
+			if tt.unfreeze {
+				action = r.Unfreeze
+			}
+			err := action(context.Background(), 1, tt.version)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_Close(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, false)
+
+	lockQuery := regexp.QuoteMeta("SELECT status, version FROM accounts WHERE account_id = $1 FOR UPDATE")
+	emptyQuery := regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM balances WHERE account_id = $1 AND balance <> 0)")
+	closeQuery := regexp.QuoteMeta("UPDATE accounts SET status = 'closed', closed_at = $2, version = version + 1 WHERE account_id = $1")
+	expectLock := func(status string) {
+		rows := sqlmock.NewRows([]string{"status", "version"})
+		if status != "" {
+			rows.AddRow(status, 3)
+		}
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(rows)
+	}
+
+	testTable := []struct {
+		name          string
+		version       int64
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountFrozen)
+				mock.ExpectQuery(emptyQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(closeQuery).WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:    "at version",
+			version: 3,
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountFrozen)
+				mock.ExpectQuery(emptyQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(closeQuery).WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:    "stale version",
+			version: 2,
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountFrozen)
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrVersionMismatch,
+		},
+		{
+			name: "already closed",
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountClosed)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "unknown account",
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock("")
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrUserDoesntExist,
+		},
+		{
+			name: "money left",
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountActive)
+				mock.ExpectQuery(emptyQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrAccountNotEmpty,
+		},
+		{
+			name: "update fails",
+			mock: func() {
+				mock.ExpectBegin()
+				expectLock(models.AccountActive)
+				mock.ExpectQuery(emptyQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(closeQuery).WithArgs(1, sqlmock.AnyArg()).WillReturnError(errStep)
+				mock.ExpectRollback()
+			},
+			expectedError: errStep,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.Close(context.Background(), 1, tt.version)
+			assert.Equal(t, tt.expectedError, err)
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
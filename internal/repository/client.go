@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"time"
+
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+type apiClients struct {
+	db     *sql.DB
+	logger logger.Logger
+}
+
+func NewAPIClientRepository(db *sql.DB, logger logger.Logger) (repository APIClients) {
+	return &apiClients{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (rep *apiClients) CreateClient(ctx context.Context, client *models.APIClient) (err error) {
+	query := `INSERT INTO api_clients (key_id, name, key_hash, scopes, created_at)
+			VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = rep.db.ExecContext(ctx, query,
+		client.KeyID,
+		client.Name,
+		client.KeyHash,
+		pq.Array(client.Scopes),
+		client.CreatedAt)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while creating api client. err: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetClientByKeyID returns the client owning a key, revoked or not.
+func (rep *apiClients) GetClientByKeyID(ctx context.Context, keyID string) (client *models.APIClient, err error) {
+	client = &models.APIClient{}
+	query := `SELECT key_id,
+					name,
+					key_hash,
+					scopes,
+					created_at,
+					revoked_at
+			FROM api_clients
+			WHERE key_id = $1`
+
+	if err = rep.db.QueryRowContext(ctx, query, keyID).
+		Scan(
+			&client.KeyID,
+			&client.Name,
+			&client.KeyHash,
+			pq.Array(&client.Scopes),
+			&client.CreatedAt,
+			&client.RevokedAt,
+		); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrClientNotFound
+		}
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting api client. err: %s", err)
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// RevokeClient disables a key for good. Revoking a revoked key does nothing.
+func (rep *apiClients) RevokeClient(ctx context.Context, keyID string) (err error) {
+	query := `UPDATE api_clients
+			SET revoked_at = COALESCE(revoked_at, $2)
+			WHERE key_id = $1`
+
+	result, err := rep.db.ExecContext(ctx, query, keyID, time.Now().UTC())
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while revoking api client. err: %s", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrClientNotFound
+	}
+
+	return nil
+}
+
+// UseNonce records a nonce of a signed request of keyID and reports whether
+// it is fresh, that is not recorded yet or recorded but expired. A nonce is
+// only recorded until expiresAt, after which the request it came with is
+// refused for its timestamp anyway.
+func (rep *apiClients) UseNonce(ctx context.Context, keyID, nonce string, expiresAt time.Time) (fresh bool, err error) {
+	query := `INSERT INTO api_nonces (key_id, nonce, expires_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (key_id, nonce) DO UPDATE
+			SET expires_at = EXCLUDED.expires_at
+			WHERE api_nonces.expires_at < $4`
+
+	result, err := rep.db.ExecContext(ctx, query, keyID, nonce, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while using api nonce. err: %s", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// DeleteExpiredNonces deletes the nonces that expired before now.
+func (rep *apiClients) DeleteExpiredNonces(ctx context.Context, now time.Time) (deleted int64, err error) {
+	query := `DELETE FROM api_nonces WHERE expires_at < $1`
+
+	result, err := rep.db.ExecContext(ctx, query, now.UTC())
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while deleting expired api nonces. err: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,157 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CreateClient(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAPIClientRepository(db, log)
+
+	client := &models.APIClient{
+		KeyID:     "0123456789abcdef",
+		Name:      "shop",
+		KeyHash:   []byte{1, 2, 3},
+		Scopes:    []string{models.ScopeReadBalance, models.ScopeTransfer},
+		CreatedAt: time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_clients (key_id, name, key_hash, scopes, created_at)")).
+		WithArgs(client.KeyID, client.Name, client.KeyHash, pq.Array(client.Scopes), client.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = r.CreateClient(context.Background(), client)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_GetClientByKeyID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAPIClientRepository(db, log)
+
+	query := regexp.QuoteMeta("SELECT key_id, name, key_hash, scopes, created_at, revoked_at FROM api_clients WHERE key_id = $1")
+	columns := []string{"key_id", "name", "key_hash", "scopes", "created_at", "revoked_at"}
+	createdAt := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name           string
+		mock           func()
+		expectedResult *models.APIClient
+		expectedError  error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				rows := sqlmock.NewRows(columns).AddRow("key", "shop", []byte{1, 2, 3}, "{balance:read,transfer}", createdAt, nil)
+				mock.ExpectQuery(query).WithArgs("key").WillReturnRows(rows)
+			},
+			expectedResult: &models.APIClient{
+				KeyID:     "key",
+				Name:      "shop",
+				KeyHash:   []byte{1, 2, 3},
+				Scopes:    []string{models.ScopeReadBalance, models.ScopeTransfer},
+				CreatedAt: createdAt,
+			},
+		},
+		{
+			name: "unknown key",
+			mock: func() {
+				mock.ExpectQuery(query).WithArgs("key").WillReturnRows(sqlmock.NewRows(columns))
+			},
+			expectedError: repository.ErrClientNotFound,
+		},
+		{
+			name: "query fails",
+			mock: func() {
+				mock.ExpectQuery(query).WithArgs("key").WillReturnError(errors.New("connection reset"))
+			},
+			expectedError: errors.New("connection reset"),
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			result, err := r.GetClientByKeyID(context.Background(), "key")
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.expectedResult, result)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_RevokeClient(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAPIClientRepository(db, log)
+
+	query := regexp.QuoteMeta("UPDATE api_clients SET revoked_at = COALESCE(revoked_at, $2) WHERE key_id = $1")
+
+	mock.ExpectExec(query).WithArgs("key", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, r.RevokeClient(context.Background(), "key"))
+
+	mock.ExpectExec(query).WithArgs("nokey", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, repository.ErrClientNotFound, r.RevokeClient(context.Background(), "nokey"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_UseNonce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAPIClientRepository(db, log)
+
+	query := regexp.QuoteMeta("INSERT INTO api_nonces (key_id, nonce, expires_at) VALUES ($1, $2, $3) ON CONFLICT (key_id, nonce) DO UPDATE")
+	expiresAt := time.Date(2022, 11, 1, 10, 5, 0, 0, time.UTC)
+
+	mock.ExpectExec(query).WithArgs("key", "nonce", expiresAt, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	fresh, err := r.UseNonce(context.Background(), "key", "nonce", expiresAt)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	// seen and not expired yet.
+	mock.ExpectExec(query).WithArgs("key", "nonce", expiresAt, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	fresh, err = r.UseNonce(context.Background(), "key", "nonce", expiresAt)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_DeleteExpiredNonces(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAPIClientRepository(db, log)
+
+	now := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM api_nonces WHERE expires_at < $1")).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, err := r.DeleteExpiredNonces(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"time"
+
+	"database/sql"
+)
+
+type idempotency struct {
+	db     *sql.DB
+	logger logger.Logger
+}
+
+func NewIdempotencyRepository(db *sql.DB, logger logger.Logger) (repository Idempotency) {
+	return &idempotency{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Claim stores a new key without a response. An existing key is taken over
+// only when it has already expired, or when it was claimed before
+// staleBefore and still has no response, because the request that claimed it
+// can no longer be running. This way exactly one request executes per key,
+// and a key is not left claimed by a request that never finished.
+func (rep *idempotency) Claim(ctx context.Context, key *models.IdempotencyKey, staleBefore time.Time) (claimed bool, err error) {
+	query := `INSERT INTO idempotency_keys (key_id, idempotency_key, request_hash, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (key_id, idempotency_key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash,
+				status_code = NULL,
+				response = NULL,
+				created_at = EXCLUDED.created_at,
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < EXCLUDED.created_at
+				OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $6)`
+
+	result, err := rep.db.ExecContext(ctx, query,
+		key.KeyID,
+		key.Key,
+		key.RequestHash,
+		key.CreatedAt.UTC(),
+		key.ExpiresAt.UTC(),
+		staleBefore.UTC())
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while claiming idempotency key. err: %s", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (rep *idempotency) GetByKey(ctx context.Context, keyID, key string) (stored *models.IdempotencyKey, err error) {
+	stored = &models.IdempotencyKey{}
+	var (
+		statusCode sql.NullInt64
+		response   []byte
+	)
+	query := `SELECT key_id,
+					idempotency_key,
+					request_hash,
+					status_code,
+					response,
+					created_at,
+					expires_at
+			FROM idempotency_keys
+			WHERE key_id = $1 AND idempotency_key = $2`
+
+	if err = rep.db.QueryRowContext(ctx, query, keyID, key).
+		Scan(
+			&stored.KeyID,
+			&stored.Key,
+			&stored.RequestHash,
+			&statusCode,
+			&response,
+			&stored.CreatedAt,
+			&stored.ExpiresAt,
+		); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting idempotency key. err: %s", err)
+		return nil, err
+	}
+	stored.StatusCode = int(statusCode.Int64)
+	stored.Response = response
+
+	return stored, nil
+}
+
+func (rep *idempotency) SaveResponse(ctx context.Context, key *models.IdempotencyKey) (err error) {
+	query := `UPDATE idempotency_keys
+			SET status_code = $3, response = $4
+			WHERE key_id = $1 AND idempotency_key = $2`
+
+	result, err := rep.db.ExecContext(ctx, query,
+		key.KeyID,
+		key.Key,
+		key.StatusCode,
+		key.Response)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while saving idempotent response. err: %s", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+func (rep *idempotency) Release(ctx context.Context, keyID, key string) (err error) {
+	query := `DELETE FROM idempotency_keys
+			WHERE key_id = $1 AND idempotency_key = $2 AND status_code IS NULL`
+
+	_, err = rep.db.ExecContext(ctx, query, keyID, key)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while releasing idempotency key. err: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes the keys that expired before now.
+func (rep *idempotency) DeleteExpired(ctx context.Context, now time.Time) (deleted int64, err error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < $1`
+
+	result, err := rep.db.ExecContext(ctx, query, now.UTC())
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while deleting expired idempotency keys. err: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
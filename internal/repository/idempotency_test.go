@@ -0,0 +1,167 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Claim(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewIdempotencyRepository(db, log)
+	now := time.Date(2022, 03, 11, 0, 0, 0, 0, time.UTC)
+	staleBefore := now.Add(-time.Minute)
+	key := &models.IdempotencyKey{
+		KeyID:       "shop",
+		Key:         "key",
+		RequestHash: "hash",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	testTable := []struct {
+		name           string
+		mock           func()
+		expectedResult bool
+	}{
+		{
+			name: "new key",
+			mock: func() {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys")).
+					WithArgs(key.KeyID, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt, staleBefore).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedResult: true,
+		},
+		{
+			name: "existing key",
+			mock: func() {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys")).
+					WithArgs(key.KeyID, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt, staleBefore).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedResult: false,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			claimed, err := r.Claim(context.Background(), key, staleBefore)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, claimed)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_GetByKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewIdempotencyRepository(db, log)
+	now := time.Date(2022, 03, 11, 0, 0, 0, 0, time.UTC)
+	columns := []string{"key_id", "idempotency_key", "request_hash", "status_code", "response", "created_at", "expires_at"}
+
+	testTable := []struct {
+		name           string
+		mock           func()
+		expectedResult *models.IdempotencyKey
+		expectedError  error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				rows := sqlmock.NewRows(columns).AddRow("shop", "key", "hash", 200, []byte(`"ok"`), now, now.Add(time.Hour))
+				mock.ExpectQuery(regexp.QuoteMeta("FROM idempotency_keys WHERE key_id = $1 AND idempotency_key = $2")).WithArgs("shop", "key").WillReturnRows(rows)
+			},
+			expectedResult: &models.IdempotencyKey{
+				KeyID:       "shop",
+				Key:         "key",
+				RequestHash: "hash",
+				StatusCode:  200,
+				Response:    []byte(`"ok"`),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(time.Hour),
+			},
+		},
+		{
+			name: "in progress",
+			mock: func() {
+				rows := sqlmock.NewRows(columns).AddRow("shop", "key", "hash", nil, nil, now, now.Add(time.Hour))
+				mock.ExpectQuery(regexp.QuoteMeta("FROM idempotency_keys WHERE key_id = $1 AND idempotency_key = $2")).WithArgs("shop", "key").WillReturnRows(rows)
+			},
+			expectedResult: &models.IdempotencyKey{
+				KeyID:       "shop",
+				Key:         "key",
+				RequestHash: "hash",
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(time.Hour),
+			},
+		},
+		{
+			name: "no rows",
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta("FROM idempotency_keys WHERE key_id = $1 AND idempotency_key = $2")).WithArgs("shop", "key").WillReturnError(sql.ErrNoRows)
+			},
+			expectedError: repository.ErrIdempotencyKeyNotFound,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			result, err := r.GetByKey(context.Background(), "shop", "key")
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.expectedResult, result)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_SaveResponse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewIdempotencyRepository(db, log)
+	key := &models.IdempotencyKey{
+		KeyID:      "shop",
+		Key:        "key",
+		StatusCode: 200,
+		Response:   []byte(`"ok"`),
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET status_code = $3, response = $4 WHERE key_id = $1 AND idempotency_key = $2")).
+		WithArgs(key.KeyID, key.Key, key.StatusCode, key.Response).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, r.SaveResponse(context.Background(), key))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_DeleteExpiredIdempotencyKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewIdempotencyRepository(db, log)
+	now := time.Date(2022, 03, 11, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE expires_at < $1")).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	deleted, err := r.DeleteExpired(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
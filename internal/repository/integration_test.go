@@ -0,0 +1,615 @@
+package repository_test
+
+import (
+	"avito-tech/internal/config"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/migrations"
+	"avito-tech/pkg/migrate"
+	"context"
+	"database/sql"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// integrationDB connects to the database from TEST_POSTGRES_DSN, applies the
+// migrations and empties every table except for the seeded system accounts.
+// Tests using it are skipped when the variable is not set.
+func integrationDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, log, migrations.FS)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	_, err = db.Exec(`TRUNCATE balances, transactions, reservations, revenue, idempotency_keys, journal_entries, postings, scheduled_transfers, scheduled_transfer_runs RESTART IDENTITY`)
+	require.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM accounts WHERE account_id > 0`)
+	require.NoError(t, err)
+
+	return db
+}
+
+// seedAccount creates an account with a RUB balance funded from the deposits
+// account without adding history rows.
+func seedAccount(t *testing.T, db *sql.DB, id int, balance models.Money) {
+	_, err := db.Exec(`INSERT INTO accounts (account_id) VALUES ($1)`, id)
+	require.NoError(t, err)
+	if balance == 0 {
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO balances (account_id, currency, balance) VALUES ($1, 'RUB', $2::numeric)
+		ON CONFLICT (account_id, currency) DO UPDATE SET balance = balances.balance + EXCLUDED.balance`,
+		id, balance)
+	require.NoError(t, err)
+	_, err = db.Exec(`WITH e AS (
+			INSERT INTO journal_entries (date_time, comment) VALUES (now(), 'seed') RETURNING entry_id
+		)
+		INSERT INTO postings (entry_id, account_id, currency, amount)
+		SELECT entry_id, $1::integer, 'RUB', -$3::numeric FROM e
+		UNION ALL
+		SELECT entry_id, $2::integer, 'RUB', $3::numeric FROM e`, repository.DepositsAccountID, id, balance)
+	require.NoError(t, err)
+}
+
+// balanceOf returns the RUB balance of an account.
+func balanceOf(t *testing.T, db *sql.DB, id int) models.Money {
+	return balanceIn(t, db, id, models.DefaultCurrency)
+}
+
+// queryRower is a *sql.DB or a *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// balanceIn returns the balance of an account in currency. System accounts
+// keep no balances, theirs is the sum of their postings.
+func balanceIn(t *testing.T, db queryRower, id int, currency string) models.Money {
+	query := `SELECT COALESCE(sum(balance), 0) FROM balances WHERE account_id = $1 AND currency = $2`
+	if id < 0 {
+		query = `SELECT COALESCE(sum(amount), 0) FROM postings WHERE account_id = $1 AND currency = $2`
+	}
+	var balance models.Money
+	require.NoError(t, db.QueryRow(query, id, currency).Scan(&balance))
+	return balance
+}
+
+func historyCount(t *testing.T, db *sql.DB) int {
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM transactions`).Scan(&count))
+	return count
+}
+
+func Test_Integration_MoneyTransactionIsAtomic(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	seedAccount(t, db, 1, 1000000)
+
+	// the sender is debited and its history row written before the missing
+	// receiver is detected; none of it may survive.
+	err := r.MoneyTransaction(context.Background(), &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 250000, Comment: "to nobody", Currency: "RUB"})
+	assert.Equal(t, repository.ErrUserDoesntExist, err)
+	assert.Equal(t, models.Money(1000000), balanceOf(t, db, 1))
+	assert.Equal(t, 0, historyCount(t, db))
+
+	// an overdraft is rejected before any balance changes.
+	seedAccount(t, db, 2, 0)
+	err = r.MoneyTransaction(context.Background(), &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 2000000, Comment: "too much", Currency: "RUB"})
+	assert.Equal(t, &repository.InsufficientFundsError{AccountID: 1, Currency: "RUB", Balance: 1000000, Requested: 2000000}, err)
+	assert.Equal(t, models.Money(1000000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 2))
+	assert.Equal(t, 0, historyCount(t, db))
+
+	err = r.MoneyTransaction(context.Background(), &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 250000, Comment: "lunch", Currency: "RUB"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Money(750000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(250000), balanceOf(t, db, 2))
+	assert.Equal(t, 2, historyCount(t, db))
+
+	history, err := repository.NewTransactionRepository(db, log).GetByAccountID(context.Background(), &models.TransactionHistoryReq{AccountID: 2})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 1, history[0].CounterpartyID)
+	assert.NotZero(t, history[0].EntryID)
+	assertLedgerBalanced(t, db)
+}
+
+// assertLedgerBalanced checks that every entry sums to zero in each currency
+// and that customer balances match their postings, system accounts keeping
+// none.
+func assertLedgerBalanced(t *testing.T, db queryRower) {
+	var unbalanced int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM (
+			SELECT entry_id FROM postings GROUP BY entry_id, currency HAVING sum(amount) <> 0
+		) e`).Scan(&unbalanced))
+	assert.Zero(t, unbalanced)
+
+	var systemBalances int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM balances WHERE account_id < 0`).Scan(&systemBalances))
+	assert.Zero(t, systemBalances)
+
+	var mismatched int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM balances b
+		WHERE b.balance <> (SELECT COALESCE(sum(p.amount), 0) FROM postings p
+			WHERE p.account_id = b.account_id AND p.currency = b.currency)`).Scan(&mismatched))
+	assert.Zero(t, mismatched)
+}
+
+// Test_Integration_ConcurrentTransfers sends money back and forth between a
+// few accounts from many goroutines at once. Opposite transfers over the same
+// pair of accounts used to deadlock.
+func Test_Integration_ConcurrentTransfers(t *testing.T) {
+	const (
+		accounts  = 4
+		transfers = 2000
+		seeded    = models.Money(10000000000)
+	)
+	db := integrationDB(t)
+	db.SetMaxOpenConns(32)
+	r := repository.NewAccountRepository(db, log, false)
+	for id := 1; id <= accounts; id++ {
+		seedAccount(t, db, id, seeded)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, transfers)
+	for i := 0; i < transfers; i++ {
+		sender, receiver := i%accounts+1, (i/accounts)%accounts+1
+		if sender == receiver {
+			receiver = receiver%accounts + 1
+		}
+		wg.Add(1)
+		go func(sender, receiver int, amount models.Money) {
+			defer wg.Done()
+			errs <- r.MoneyTransaction(context.Background(), &models.Transaction{
+				SenderID:   sender,
+				ReceiverID: receiver,
+				Amount:     amount,
+				Comment:    "concurrent",
+				Currency:   models.DefaultCurrency,
+			})
+		}(sender, receiver, models.Money(100+i%7)*100)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	var total models.Money
+	for id := 1; id <= accounts; id++ {
+		total += balanceOf(t, db, id)
+	}
+	assert.Equal(t, seeded*accounts, total)
+	assert.Equal(t, 2*transfers, historyCount(t, db))
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_ChangeBalanceIsAtomic(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, true)
+
+	// a new account cannot start with a negative balance.
+	err := r.ChangeBalance(context.Background(), &models.AccountDebit{AccountID: 1, Amount: -50000, Comment: "withdraw", Currency: "RUB"})
+	assert.Equal(t, repository.ErrNewAccNegativeBalance, err)
+	assert.Equal(t, 0, historyCount(t, db))
+
+	err = r.ChangeBalance(context.Background(), &models.AccountDebit{AccountID: 1, Amount: 50000, Comment: "deposit", Currency: "RUB"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Money(50000), balanceOf(t, db, 1))
+	assert.Equal(t, 1, historyCount(t, db))
+
+	err = r.ChangeBalance(context.Background(), &models.AccountDebit{AccountID: 1, Amount: -100000, Comment: "withdraw", Currency: "RUB"})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	assert.Equal(t, models.Money(50000), balanceOf(t, db, 1))
+	assert.Equal(t, 1, historyCount(t, db))
+	assert.Equal(t, models.Money(-50000), balanceOf(t, db, repository.DepositsAccountID))
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_AccountLifecycle(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	ctx := context.Background()
+	seedAccount(t, db, 2, 100000)
+
+	// without implicit creation money only reaches existing accounts.
+	err := r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: 50000, Comment: "deposit", Currency: "RUB"})
+	assert.Equal(t, repository.ErrUserDoesntExist, err)
+	require.NoError(t, r.Create(ctx, 1))
+	assert.Equal(t, repository.ErrAccountExists, r.Create(ctx, 1))
+
+	require.NoError(t, r.Freeze(ctx, 1, 0))
+	acc, err := r.GetBalanceByID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.AccountFrozen, acc.Status)
+	assert.NotNil(t, acc.FrozenAt)
+
+	// a frozen account takes deposits, but no transfers either way.
+	err = r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: 50000, Comment: "deposit", Currency: "RUB"})
+	assert.NoError(t, err)
+	err = r.MoneyTransaction(ctx, &models.Transaction{SenderID: 2, ReceiverID: 1, Amount: 10000, Comment: "gift", Currency: "RUB"})
+	assert.Equal(t, repository.ErrAccountFrozen, err)
+	err = r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: -10000, Comment: "withdraw", Currency: "RUB"})
+	assert.Equal(t, repository.ErrAccountFrozen, err)
+	assert.Equal(t, models.Money(50000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(100000), balanceOf(t, db, 2))
+
+	assert.Equal(t, repository.ErrAccountNotEmpty, r.Close(ctx, 1, 0))
+	require.NoError(t, r.Unfreeze(ctx, 1, 0))
+	err = r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: -50000, Comment: "withdraw", Currency: "RUB"})
+	require.NoError(t, err)
+
+	require.NoError(t, r.Close(ctx, 1, 0))
+	require.NoError(t, r.Close(ctx, 1, 0))
+	assert.Equal(t, repository.ErrAccountClosed, r.Freeze(ctx, 1, 0))
+	err = r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: 50000, Comment: "deposit", Currency: "RUB"})
+	assert.Equal(t, repository.ErrAccountClosed, err)
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_AccountVersions(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	ctx := context.Background()
+	seedAccount(t, db, 1, 100000)
+	seedAccount(t, db, 2, 0)
+
+	version := func(id int) int64 {
+		acc, err := r.GetBalanceByID(ctx, id)
+		require.NoError(t, err)
+		return acc.Version
+	}
+	assert.Equal(t, int64(1), version(1))
+
+	// a transfer moves both accounts on.
+	err := r.MoneyTransaction(ctx, &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 10000, Comment: "gift", Currency: "RUB", ExpectedVersion: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version(1))
+	assert.Equal(t, int64(2), version(2))
+
+	err = r.ChangeBalance(ctx, &models.AccountDebit{AccountID: 1, Amount: -10000, Comment: "withdraw", Currency: "RUB", ExpectedVersion: 1})
+	assert.Equal(t, repository.ErrVersionMismatch, err)
+	assert.Equal(t, repository.ErrVersionMismatch, r.Freeze(ctx, 1, 1))
+	require.NoError(t, r.Freeze(ctx, 1, 2))
+	assert.Equal(t, int64(3), version(1))
+	assert.Equal(t, repository.ErrVersionMismatch, r.Close(ctx, 1, 2))
+	assert.Equal(t, models.Money(90000), balanceOf(t, db, 1))
+}
+
+func Test_Integration_BatchTransactions(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	ctx := context.Background()
+	seedAccount(t, db, 1, 25000)
+	seedAccount(t, db, 2, 0)
+	seedAccount(t, db, 3, 0)
+
+	salary := func(receiverID int, amount models.Money) models.Transaction {
+		return models.Transaction{SenderID: 1, ReceiverID: receiverID, Amount: amount, Comment: "salary", Currency: "RUB"}
+	}
+	batch := []models.Transaction{salary(2, 10000), salary(3, 20000), salary(2, 5000)}
+
+	// the second salary overdraws the payer, so none is paid.
+	_, err := r.MoneyTransactions(ctx, batch, false)
+	var batchErr *repository.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	assert.Equal(t, models.Money(25000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 2))
+
+	// best effort pays the others.
+	errs, err := r.MoneyTransactions(ctx, batch, true)
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], repository.ErrInsufficientFunds)
+	assert.NoError(t, errs[2])
+	assert.Equal(t, models.Money(10000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(15000), balanceOf(t, db, 2))
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 3))
+	assertLedgerBalanced(t, db)
+}
+
+// Test_Integration_BatchAtSizeLimit checks that the largest batch accepted
+// runs well within the default request deadline, with room for retries.
+func Test_Integration_BatchAtSizeLimit(t *testing.T) {
+	const receivers = 10
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	seedAccount(t, db, 1, models.Money(models.MaxBatchTransactions)*10000)
+	for id := 2; id <= receivers+1; id++ {
+		seedAccount(t, db, id, 0)
+	}
+
+	batch := make([]models.Transaction, models.MaxBatchTransactions)
+	for i := range batch {
+		batch[i] = models.Transaction{SenderID: 1, ReceiverID: i%receivers + 2, Amount: 10000, Comment: "salary", Currency: "RUB"}
+	}
+
+	timeout := config.Default().Server.RequestTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	_, err := r.MoneyTransactions(ctx, batch, false)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), timeout/5)
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 1))
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_ScheduledTransfers(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewScheduledTransferRepository(db, log)
+	ctx := context.Background()
+	seedAccount(t, db, 3, 60000)
+	seedAccount(t, db, 7, 0)
+	policy := models.RetryPolicy{Interval: time.Hour, MaxAttempts: 2}
+
+	// postgres keeps microseconds.
+	start := time.Now().UTC().Add(time.Minute).Truncate(time.Microsecond)
+	schedule := &models.ScheduledTransfer{SenderID: 3, ReceiverID: 7, Amount: 5000000, Currency: "RUB", Comment: "monthly rent",
+		Period: models.PeriodMonthly, StartAt: start, OnInsufficientFunds: models.OnInsufficientFundsRetry}
+	require.NoError(t, r.CreateSchedule(ctx, schedule))
+
+	run, err := r.RunSchedule(ctx, schedule.ID, start.Add(-time.Second), policy)
+	require.NoError(t, err)
+	assert.Nil(t, run, "not due yet")
+
+	ids, err := r.DueSchedules(ctx, start, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{schedule.ID}, ids)
+
+	run, err = r.RunSchedule(ctx, schedule.ID, start, policy)
+	require.NoError(t, err)
+	assert.Equal(t, models.RunSucceeded, run.Status)
+	assert.Equal(t, models.Money(1000000), balanceOf(t, db, 3))
+	assert.Equal(t, models.Money(5000000), balanceOf(t, db, 7))
+
+	// the next month the sender cannot pay: the run is retried once, then
+	// skipped.
+	next := start.AddDate(0, 1, 0)
+	run, err = r.RunSchedule(ctx, schedule.ID, next, policy)
+	require.NoError(t, err)
+	assert.Equal(t, models.RunRetrying, run.Status)
+	run, err = r.RunSchedule(ctx, schedule.ID, next.Add(time.Minute), policy)
+	require.NoError(t, err)
+	assert.Nil(t, run, "retry not due yet")
+	run, err = r.RunSchedule(ctx, schedule.ID, next.Add(time.Hour), policy)
+	require.NoError(t, err)
+	assert.Equal(t, models.RunSkipped, run.Status)
+	assert.Equal(t, 2, run.Attempt)
+
+	stored, err := r.GetSchedule(ctx, schedule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduleActive, stored.Status)
+	assert.True(t, start.AddDate(0, 2, 0).Equal(*stored.NextRunAt))
+	assert.Nil(t, stored.RetryAt)
+
+	runs, err := r.ListRuns(ctx, schedule.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	assert.Equal(t, []string{models.RunSkipped, models.RunRetrying, models.RunSucceeded}, []string{runs[0].Status, runs[1].Status, runs[2].Status})
+	assert.NotZero(t, runs[2].EntryID)
+
+	require.NoError(t, r.CancelSchedule(ctx, schedule.ID))
+	require.NoError(t, r.CancelSchedule(ctx, schedule.ID))
+	assert.Equal(t, repository.ErrScheduleNotActive, r.UpdateSchedule(ctx, schedule))
+	ids, err = r.DueSchedules(ctx, start.AddDate(1, 0, 0), 10)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_MoneyTransactionConvertsCurrency(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	seedAccount(t, db, 1, 10000000)
+	seedAccount(t, db, 2, 0)
+
+	err := r.MoneyTransaction(context.Background(), &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 10000000, Comment: "abroad",
+		Currency: "RUB", ReceiverCurrency: "USD", Rate: 0.016})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(160000), balanceIn(t, db, 2, "USD"))
+
+	acc, err := r.GetBalanceByID(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]models.Money{"USD": 160000}, acc.Balances)
+
+	history, err := repository.NewTransactionRepository(db, log).GetByAccountID(context.Background(), &models.TransactionHistoryReq{AccountID: 2})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "USD", history[0].Currency)
+	assert.Equal(t, 0.016, history[0].Rate)
+	assert.Equal(t, 1, history[0].CounterpartyID)
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_Refunds(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewAccountRepository(db, log, false)
+	history := repository.NewTransactionRepository(db, log)
+	ctx := context.Background()
+	seedAccount(t, db, 1, 10000000)
+	seedAccount(t, db, 2, 0)
+	seedAccount(t, db, 3, 0)
+
+	require.NoError(t, r.MoneyTransaction(ctx, &models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 5000000, Comment: "order 1",
+		Currency: "RUB", ReceiverCurrency: "RUB"}))
+	received, err := history.GetByAccountID(ctx, &models.TransactionHistoryReq{AccountID: 2})
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+
+	partial := &models.Refund{TransactionID: received[0].TransactionID, Amount: 2000000}
+	require.NoError(t, r.Refund(ctx, partial))
+	assert.Equal(t, models.Money(2000000), partial.Refunded)
+	assert.Equal(t, models.Money(3000000), partial.Remaining)
+	assert.Equal(t, models.Money(7000000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(3000000), balanceOf(t, db, 2))
+
+	assert.Equal(t, repository.ErrRefundExceedsAmount, r.Refund(ctx, &models.Refund{TransactionID: received[0].TransactionID, Amount: 3000100}))
+	rest := &models.Refund{TransactionID: received[0].TransactionID}
+	require.NoError(t, r.Refund(ctx, rest))
+	assert.Equal(t, models.Money(3000000), rest.Amount)
+	assert.Equal(t, models.Money(10000000), balanceOf(t, db, 1))
+	assert.Equal(t, models.Money(0), balanceOf(t, db, 2))
+	assert.Equal(t, repository.ErrRefundExceedsAmount, r.Refund(ctx, &models.Refund{TransactionID: received[0].TransactionID}))
+
+	// both sides of every refund point at their side of the transfer.
+	sent, err := history.GetByAccountID(ctx, &models.TransactionHistoryReq{AccountID: 1})
+	require.NoError(t, err)
+	require.Len(t, sent, 3)
+	assert.Equal(t, []int{0, sent[0].TransactionID, sent[0].TransactionID}, []int{sent[0].RefundOf, sent[1].RefundOf, sent[2].RefundOf})
+	received, err = history.GetByAccountID(ctx, &models.TransactionHistoryReq{AccountID: 2})
+	require.NoError(t, err)
+	require.Len(t, received, 3)
+	assert.Equal(t, received[0].TransactionID, received[1].RefundOf)
+	assert.Equal(t, models.Money(-2000000), received[1].Amount)
+	assert.Equal(t, repository.ErrNotRefundable, r.Refund(ctx, &models.Refund{TransactionID: received[1].TransactionID}))
+
+	// a converted transfer is refunded at its own rate, and its last refund
+	// takes back exactly what is left.
+	require.NoError(t, r.MoneyTransaction(ctx, &models.Transaction{SenderID: 1, ReceiverID: 3, Amount: 100000, Comment: "abroad",
+		Currency: "RUB", ReceiverCurrency: "USD", Rate: 0.016}))
+	abroad, err := history.GetByAccountID(ctx, &models.TransactionHistoryReq{AccountID: 3})
+	require.NoError(t, err)
+	require.Len(t, abroad, 1)
+	assert.Equal(t, models.Money(1600), balanceIn(t, db, 3, "USD"))
+
+	require.NoError(t, r.Refund(ctx, &models.Refund{TransactionID: abroad[0].TransactionID, Amount: 30000}))
+	assert.Equal(t, models.Money(1100), balanceIn(t, db, 3, "USD"))
+	require.NoError(t, r.Refund(ctx, &models.Refund{TransactionID: abroad[0].TransactionID}))
+	assert.Equal(t, models.Money(0), balanceIn(t, db, 3, "USD"))
+	assert.Equal(t, models.Money(10000000), balanceOf(t, db, 1))
+	assertLedgerBalanced(t, db)
+}
+
+func Test_Integration_HistoryPages(t *testing.T) {
+	db := integrationDB(t)
+	r := repository.NewTransactionRepository(db, log)
+	seedAccount(t, db, 1, 0)
+
+	// rows sharing a date are told apart by transaction_id.
+	date := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := db.Exec(`INSERT INTO transactions (account_id, amount, date_time, comment, currency) VALUES (1, $1, $2, 'row', 'RUB')`,
+			models.Money(100*(i+1)), date.Add(time.Duration(i/2)*time.Hour))
+		require.NoError(t, err)
+	}
+
+	var (
+		ids  []int
+		page *models.TransactionHistoryPage
+		err  error
+	)
+	req := &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 2}
+	for {
+		page, err = r.GetPageByAccountID(context.Background(), req)
+		require.NoError(t, err)
+		for _, tr := range page.Transactions {
+			ids = append(ids, tr.TransactionID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		req.After, err = models.ParseHistoryCursor(page.NextCursor)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, ids)
+
+	// going back from the last page returns the page before it.
+	req.After = nil
+	req.Before, err = models.ParseHistoryCursor(page.PrevCursor)
+	require.NoError(t, err)
+	page, err = r.GetPageByAccountID(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 2)
+	assert.Equal(t, 3, page.Transactions[0].TransactionID)
+	assert.Equal(t, 2, page.Transactions[1].TransactionID)
+	assert.NotEmpty(t, page.PrevCursor)
+
+	min := models.Money(30000)
+	page, err = r.GetPageByAccountID(context.Background(), &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 10, MinAmount: &min, Direction: models.DirectionCredit})
+	require.NoError(t, err)
+	assert.Len(t, page.Transactions, 3)
+	assert.Empty(t, page.NextCursor)
+
+	// keys running in different directions page through every row once.
+	ids = nil
+	req = &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.Sort{{Column: "date_time"}, {Column: "amount", Desc: true}}, Limit: 2}
+	for {
+		page, err = r.GetPageByAccountID(context.Background(), req)
+		require.NoError(t, err)
+		for _, tr := range page.Transactions {
+			ids = append(ids, tr.TransactionID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		req.After, err = models.ParseHistoryCursor(page.NextCursor)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int{2, 1, 4, 3, 5}, ids)
+}
+
+// Test_Integration_LedgerOpensExistingBalances migrates balances kept before
+// the ledger was added, in a schema of its own that is rolled back afterwards,
+// and checks that each one was opened from the deposits account.
+func Test_Integration_LedgerOpensExistingBalances(t *testing.T) {
+	db := integrationDB(t)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = tx.Exec(`CREATE SCHEMA ledger_opening; SET LOCAL search_path TO ledger_opening`)
+	require.NoError(t, err)
+
+	files, err := fs.Glob(migrations.FS, "*.up.sql")
+	require.NoError(t, err)
+	apply := func(files []string) {
+		for _, name := range files {
+			script, err := fs.ReadFile(migrations.FS, name)
+			require.NoError(t, err)
+			_, err = tx.Exec(string(script))
+			require.NoError(t, err, name)
+		}
+	}
+
+	ledger := 0
+	for ledger < len(files) && !strings.HasPrefix(files[ledger], "0004_") {
+		ledger++
+	}
+	apply(files[:ledger])
+	_, err = tx.Exec(`INSERT INTO accounts (account_id, balance) VALUES (1, 10.50), (2, 0), (3, 3)`)
+	require.NoError(t, err)
+	apply(files[ledger:])
+	// runs the deferred check that every entry is balanced.
+	_, err = tx.Exec(`SET CONSTRAINTS ALL IMMEDIATE`)
+	require.NoError(t, err)
+
+	assertLedgerBalanced(t, tx)
+	var opened int
+	require.NoError(t, tx.QueryRow(`SELECT count(*) FROM journal_entries WHERE comment = 'opening balance'`).Scan(&opened))
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, models.Money(105000), balanceIn(t, tx, 1, models.DefaultCurrency))
+	assert.Equal(t, models.Money(30000), balanceIn(t, tx, 3, models.DefaultCurrency))
+	assert.Equal(t, models.Money(-135000), balanceIn(t, tx, repository.DepositsAccountID, models.DefaultCurrency))
+}
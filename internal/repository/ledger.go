@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// System accounts are the other side of money entering, leaving or being held
+// in the service. They are seeded by the migrations. Nearly every entry posts
+// to one of them, so they keep no balances rows, which every entry would
+// have to update in turn: their totals are the sums of their postings, see
+// GetBalanceByID.
+const (
+	DepositsAccountID    = -1
+	WithdrawalsAccountID = -2
+	RevenueAccountID     = -3
+	HoldsAccountID       = -4
+	ExchangeAccountID    = -5
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry postings do not sum to zero")
+	ErrZeroPosting     = errors.New("posting amount rounds to zero")
+)
+
+func isSystemAccount(id int) bool {
+	return id < 0
+}
+
+// postEntry writes a journal entry with its postings, applies the postings to
+// the balances of customer accounts and adds a history row for every customer
+// account.
+// Postings must sum to zero in every currency.
+func postEntry(ctx context.Context, q Querier, entry *models.JournalEntry) (err error) {
+	sums := map[string]models.Money{}
+	for _, p := range entry.Postings {
+		if p.Amount == 0 {
+			return ErrZeroPosting
+		}
+		sums[p.Currency] += p.Amount
+	}
+	if len(entry.Postings) < 2 {
+		return ErrUnbalancedEntry
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	if err = lockAccounts(ctx, q, entry); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO journal_entries (date_time, comment)
+			VALUES ($1, $2)
+			RETURNING entry_id`
+
+	if err = q.QueryRowContext(ctx, query,
+		entry.Date,
+		entry.Comment).
+		Scan(&entry.ID); err != nil {
+		return err
+	}
+
+	// frozen accounts take deposits and returned reservations, but not
+	// transfers from other customers.
+	customerDebit := false
+	for _, p := range entry.Postings {
+		customerDebit = customerDebit || !isSystemAccount(p.AccountID) && p.Amount < 0
+	}
+
+	for i, p := range entry.Postings {
+		if !isSystemAccount(p.AccountID) {
+			err = updateBalance(ctx, q, p.AccountID, p.Currency, p.Amount, p.Amount > 0 && !customerDebit)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `INSERT INTO postings (entry_id, account_id, currency, amount)
+				VALUES ($1, $2, $3, $4)`
+
+		_, err = q.ExecContext(ctx, query,
+			entry.ID,
+			p.AccountID,
+			p.Currency,
+			p.Amount)
+		if err != nil {
+			return err
+		}
+
+		if isSystemAccount(p.AccountID) {
+			continue
+		}
+
+		th := &models.TransactionHistory{
+			AccountID:      p.AccountID,
+			Amount:         p.Amount,
+			Date:           entry.Date,
+			Comment:        entry.Comment,
+			EntryID:        entry.ID,
+			CounterpartyID: counterparty(entry, i),
+			Currency:       p.Currency,
+			Rate:           entry.Rate,
+			RefundOf:       entry.RefundOf[p.AccountID],
+		}
+		err = addInTransactionsHistory(ctx, q, th)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockAccounts locks the customer accounts the entry touches in account ID
+// order, checks them against entry.ExpectedVersions and increments their
+// versions. Every movement is posted through postEntry and takes these locks
+// before anything else, so concurrent entries over the same accounts, like
+// two opposite transfers, queue up instead of deadlocking on each other's
+// balances. Missing accounts are left for updateBalance to report.
+func lockAccounts(ctx context.Context, q Querier, entry *models.JournalEntry) (err error) {
+	customers := customerAccountIDs(entryAccountIDs(entry))
+	if len(customers) == 0 {
+		return nil
+	}
+
+	versions, err := lockAccountIDs(ctx, q, customers)
+	if err != nil {
+		return err
+	}
+	for id, expected := range entry.ExpectedVersions {
+		if version, ok := versions[id]; ok && version != expected {
+			return ErrVersionMismatch
+		}
+	}
+
+	query := `UPDATE accounts
+			SET version = version + 1
+			WHERE account_id = ANY($1)`
+
+	_, err = q.ExecContext(ctx, query, pq.Array(customers))
+	return err
+}
+
+// entryAccountIDs returns the accounts the entries post to, once each.
+func entryAccountIDs(entries ...*models.JournalEntry) []int64 {
+	seen := map[int]bool{}
+	var ids []int64
+	for _, entry := range entries {
+		for _, p := range entry.Postings {
+			if !seen[p.AccountID] {
+				seen[p.AccountID] = true
+				ids = append(ids, int64(p.AccountID))
+			}
+		}
+	}
+	return ids
+}
+
+// customerAccountIDs returns the customer accounts among ids, in ID order.
+// System accounts are never locked: nearly every entry touches one of them,
+// so locking them would run all movements one at a time.
+func customerAccountIDs(ids []int64) []int64 {
+	var customers []int64
+	for _, id := range ids {
+		if !isSystemAccount(int(id)) {
+			customers = append(customers, id)
+		}
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i] < customers[j] })
+	return customers
+}
+
+// lockAccountIDs locks the customer accounts among ids in ID order and
+// returns their versions by account ID. Missing accounts are left out.
+func lockAccountIDs(ctx context.Context, q Querier, ids []int64) (versions map[int]int64, err error) {
+	ids = customerAccountIDs(ids)
+	if len(ids) == 0 {
+		return map[int]int64{}, nil
+	}
+
+	query := `SELECT account_id, version
+			FROM accounts
+			WHERE account_id = ANY($1)
+			ORDER BY account_id
+			FOR UPDATE`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions = map[int]int64{}
+	for rows.Next() {
+		var (
+			id      int
+			version int64
+		)
+		if err = rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		versions[id] = version
+	}
+
+	return versions, rows.Err()
+}
+
+// expectVersion returns ExpectedVersions requiring account id to be at
+// version, or none when version is zero.
+func expectVersion(id int, version int64) map[int]int64 {
+	if version == 0 {
+		return nil
+	}
+	return map[int]int64{id: version}
+}
+
+// counterparty returns the account on the other side of the i-th posting, or
+// 0 when there is more than one. The exchange account only passes money
+// between currencies, so it is never reported as a counterparty.
+func counterparty(entry *models.JournalEntry, i int) int {
+	id := 0
+	for j, p := range entry.Postings {
+		if j == i || p.AccountID == ExchangeAccountID || (p.Amount > 0) == (entry.Postings[i].Amount > 0) {
+			continue
+		}
+		if id != 0 {
+			return 0
+		}
+		id = p.AccountID
+	}
+	return id
+}
+
+// transfer builds an entry moving amount from one account to another.
+func transfer(from, to int, currency string, amount models.Money, comment string, date time.Time) *models.JournalEntry {
+	return &models.JournalEntry{
+		Date:    date,
+		Comment: comment,
+		Postings: []models.Posting{
+			{AccountID: from, Currency: currency, Amount: -amount},
+			{AccountID: to, Currency: currency, Amount: amount},
+		},
+	}
+}
+
+// exchangeTransfer builds an entry moving amount in one currency from one
+// account and crediting it converted at rate in another currency to the
+// other. The exchange account takes both sides, so each currency balances.
+func exchangeTransfer(from, to int, fromCurrency, toCurrency string, amount models.Money, rate float64, comment string, date time.Time) *models.JournalEntry {
+	converted := amount.Convert(rate, toCurrency)
+	return &models.JournalEntry{
+		Date:    date,
+		Comment: comment,
+		Rate:    rate,
+		Postings: []models.Posting{
+			{AccountID: from, Currency: fromCurrency, Amount: -amount},
+			{AccountID: ExchangeAccountID, Currency: fromCurrency, Amount: amount},
+			{AccountID: ExchangeAccountID, Currency: toCurrency, Amount: -converted},
+			{AccountID: to, Currency: toCurrency, Amount: converted},
+		},
+	}
+}
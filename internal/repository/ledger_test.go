@@ -0,0 +1,177 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"errors"
+	"regexp"
+	"sort"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+var errStep = errors.New("step failed")
+
+// transferSteps returns the statements the ledger runs to post an entry moving
+// amount between two accounts, in order. Each step fails instead of
+// succeeding when called with true.
+func transferSteps(mock sqlmock.Sqlmock, entryID, from, to int, currency string, amount models.Money, comment string) []func(fail bool) {
+	return refundSteps(mock, entryID, from, to, currency, amount, comment, nil)
+}
+
+// refundSteps is transferSteps for an entry whose history rows refund the
+// rows in refundOf, by account ID.
+func refundSteps(mock sqlmock.Sqlmock, entryID, from, to int, currency string, amount models.Money, comment string, refundOf map[int]int) []func(fail bool) {
+	steps := []func(fail bool){
+		func(fail bool) {
+			if fail {
+				mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).WillReturnError(errStep)
+				return
+			}
+			expectLockAccounts(mock, from, to)
+		},
+		func(fail bool) {
+			e := mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO journal_entries (date_time, comment) VALUES ($1, $2) RETURNING entry_id")).
+				WithArgs(sqlmock.AnyArg(), comment)
+			if fail {
+				e.WillReturnError(errStep)
+				return
+			}
+			e.WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(entryID))
+		},
+	}
+
+	postings := []struct {
+		accountID, counterpartyID int
+		amount                    models.Money
+	}{
+		{from, to, -amount},
+		{to, from, amount},
+	}
+	// only money coming from a system account may reach a frozen account.
+	customerDebit := from > 0 && amount > 0
+	for _, p := range postings {
+		p := p
+		allowFrozen := p.amount > 0 && !customerDebit
+		if p.amount < 0 && p.accountID > 0 {
+			steps = append(steps, func(fail bool) {
+				if fail {
+					mock.ExpectQuery(regexp.QuoteMeta(lockBalanceQuery)).WithArgs(p.accountID, currency).WillReturnError(errStep)
+					return
+				}
+				expectLockBalance(mock, p.accountID, currency, -p.amount)
+			})
+		}
+		// system accounts keep no balances.
+		if p.accountID > 0 {
+			steps = append(steps, func(fail bool) {
+				e := mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).
+					WithArgs(p.accountID, currency, p.amount, allowFrozen)
+				if fail {
+					e.WillReturnError(errStep)
+					return
+				}
+				e.WillReturnResult(sqlmock.NewResult(0, 1))
+			})
+		}
+		steps = append(steps, func(fail bool) {
+			e := mock.ExpectExec(regexp.QuoteMeta("INSERT INTO postings (entry_id, account_id, currency, amount)")).
+				WithArgs(entryID, p.accountID, currency, p.amount)
+			if fail {
+				e.WillReturnError(errStep)
+				return
+			}
+			e.WillReturnResult(sqlmock.NewResult(1, 1))
+		})
+		if p.accountID < 0 {
+			continue
+		}
+		steps = append(steps, func(fail bool) {
+			e := mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (account_id, amount, date_time, comment, entry_id, counterparty_id, currency, rate, refund_of)")).
+				WithArgs(p.accountID, p.amount, sqlmock.AnyArg(), comment, entryID, p.counterpartyID, currency, nil, nullArg(refundOf[p.accountID]))
+			if fail {
+				e.WillReturnError(errStep)
+				return
+			}
+			e.WillReturnResult(sqlmock.NewResult(1, 1))
+		})
+	}
+
+	return steps
+}
+
+const (
+	lockAccountsQuery = "SELECT account_id, version FROM accounts WHERE account_id = ANY($1) ORDER BY account_id FOR UPDATE"
+	bumpVersionsQuery = "UPDATE accounts SET version = version + 1 WHERE account_id = ANY($1)"
+	lockedVersion     = 1
+)
+
+// expectLockAccounts registers the locking of the customer accounts of an
+// entry and the increment of their versions.
+func expectLockAccounts(mock sqlmock.Sqlmock, ids ...int) {
+	if customers := expectLockAccountRows(mock, ids...); len(customers) > 0 {
+		mock.ExpectExec(regexp.QuoteMeta(bumpVersionsQuery)).WithArgs(pq.Array(customers)).WillReturnResult(sqlmock.NewResult(0, int64(len(customers))))
+	}
+}
+
+// expectLockAccountRows registers the locking of the customer accounts among
+// ids, which are locked in ID order at lockedVersion, and returns their sorted
+// IDs. System accounts are not locked.
+func expectLockAccountRows(mock sqlmock.Sqlmock, ids ...int) []int64 {
+	var customers []int64
+	for _, id := range ids {
+		if id > 0 {
+			customers = append(customers, int64(id))
+		}
+	}
+	if len(customers) == 0 {
+		return nil
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i] < customers[j] })
+
+	rows := sqlmock.NewRows([]string{"account_id", "version"})
+	for _, id := range customers {
+		rows.AddRow(id, lockedVersion)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).WithArgs(pq.Array(customers)).WillReturnRows(rows)
+	return customers
+}
+
+const lockBalanceQuery = "SELECT balance FROM balances WHERE account_id = $1 AND currency = $2 FOR UPDATE"
+
+// expectLockBalance registers the balance check of a debit. A nil balance
+// means the account has never held currency.
+func expectLockBalance(mock sqlmock.Sqlmock, accountID int, currency string, balance interface{}) {
+	rows := sqlmock.NewRows([]string{"balance"})
+	if balance != nil {
+		rows.AddRow(balance)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(lockBalanceQuery)).WithArgs(accountID, currency).WillReturnRows(rows)
+}
+
+const accountStatusQuery = "SELECT status FROM accounts WHERE account_id = $1"
+
+// expectAccountStatus registers the status lookup that explains a balance
+// update which changed nothing. An empty status means there is no account.
+func expectAccountStatus(mock sqlmock.Sqlmock, accountID int, status string) {
+	rows := sqlmock.NewRows([]string{"status"})
+	if status != "" {
+		rows.AddRow(status)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(accountStatusQuery)).WithArgs(accountID).WillReturnRows(rows)
+}
+
+// nullArg is the argument a zero id is stored as.
+func nullArg(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// expectTransfer registers a successful ledger transfer.
+func expectTransfer(mock sqlmock.Sqlmock, entryID, from, to int, currency string, amount models.Money, comment string) {
+	for _, step := range transferSteps(mock, entryID, from, to, currency, amount, comment) {
+		step(false)
+	}
+}
@@ -6,11 +6,96 @@ package mock_repository
 
 import (
 	models "avito-tech/internal/models"
+	context "context"
+	sql "database/sql"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
 
+// MockQuerier is a mock of Querier interface.
+type MockQuerier struct {
+	ctrl     *gomock.Controller
+	recorder *MockQuerierMockRecorder
+}
+
+// MockQuerierMockRecorder is the mock recorder for MockQuerier.
+type MockQuerierMockRecorder struct {
+	mock *MockQuerier
+}
+
+// NewMockQuerier creates a new mock instance.
+func NewMockQuerier(ctrl *gomock.Controller) *MockQuerier {
+	mock := &MockQuerier{ctrl: ctrl}
+	mock.recorder = &MockQuerierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockQuerier) EXPECT() *MockQuerierMockRecorder {
+	return m.recorder
+}
+
+// ExecContext mocks base method.
+func (m *MockQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
+	ret0, _ := ret[0].(sql.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExecContext indicates an expected call of ExecContext.
+func (mr *MockQuerierMockRecorder) ExecContext(ctx interface{}, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockQuerier)(nil).ExecContext), varargs...)
+}
+
+// QueryContext mocks base method.
+func (m *MockQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
+	ret0, _ := ret[0].(*sql.Rows)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryContext indicates an expected call of QueryContext.
+func (mr *MockQuerierMockRecorder) QueryContext(ctx interface{}, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockQuerier)(nil).QueryContext), varargs...)
+}
+
+// QueryRowContext mocks base method.
+func (m *MockQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryRowContext", varargs...)
+	ret0, _ := ret[0].(*sql.Row)
+	return ret0
+}
+
+// QueryRowContext indicates an expected call of QueryRowContext.
+func (mr *MockQuerierMockRecorder) QueryRowContext(ctx interface{}, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowContext", reflect.TypeOf((*MockQuerier)(nil).QueryRowContext), varargs...)
+}
+
 // MockAccount is a mock of Account interface.
 type MockAccount struct {
 	ctrl     *gomock.Controller
@@ -35,61 +120,146 @@ func (m *MockAccount) EXPECT() *MockAccountMockRecorder {
 }
 
 // ChangeBalance mocks base method.
-func (m *MockAccount) ChangeBalance(acc *models.AccountDebit) error {
+func (m *MockAccount) ChangeBalance(ctx context.Context, acc *models.AccountDebit) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ChangeBalance", acc)
+	ret := m.ctrl.Call(m, "ChangeBalance", ctx, acc)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ChangeBalance indicates an expected call of ChangeBalance.
-func (mr *MockAccountMockRecorder) ChangeBalance(acc interface{}) *gomock.Call {
+func (mr *MockAccountMockRecorder) ChangeBalance(ctx interface{}, acc interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeBalance", reflect.TypeOf((*MockAccount)(nil).ChangeBalance), ctx, acc)
+}
+
+// Close mocks base method.
+func (m *MockAccount) Close(ctx context.Context, id int, version int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockAccountMockRecorder) Close(ctx interface{}, id interface{}, version interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeBalance", reflect.TypeOf((*MockAccount)(nil).ChangeBalance), acc)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockAccount)(nil).Close), ctx, id, version)
+}
+
+// Create mocks base method.
+func (m *MockAccount) Create(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAccountMockRecorder) Create(ctx interface{}, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAccount)(nil).Create), ctx, id)
+}
+
+// Freeze mocks base method.
+func (m *MockAccount) Freeze(ctx context.Context, id int, version int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Freeze", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Freeze indicates an expected call of Freeze.
+func (mr *MockAccountMockRecorder) Freeze(ctx interface{}, id interface{}, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Freeze", reflect.TypeOf((*MockAccount)(nil).Freeze), ctx, id, version)
 }
 
 // GetAll mocks base method.
-func (m *MockAccount) GetAll() ([]models.Account, error) {
+func (m *MockAccount) GetAll(ctx context.Context) ([]models.Account, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAll")
+	ret := m.ctrl.Call(m, "GetAll", ctx)
 	ret0, _ := ret[0].([]models.Account)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAll indicates an expected call of GetAll.
-func (mr *MockAccountMockRecorder) GetAll() *gomock.Call {
+func (mr *MockAccountMockRecorder) GetAll(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAccount)(nil).GetAll))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAccount)(nil).GetAll), ctx)
 }
 
 // GetBalanceByID mocks base method.
-func (m *MockAccount) GetBalanceByID(id int) (*models.Account, error) {
+func (m *MockAccount) GetBalanceByID(ctx context.Context, id int) (*models.Account, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBalanceByID", id)
+	ret := m.ctrl.Call(m, "GetBalanceByID", ctx, id)
 	ret0, _ := ret[0].(*models.Account)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBalanceByID indicates an expected call of GetBalanceByID.
-func (mr *MockAccountMockRecorder) GetBalanceByID(id interface{}) *gomock.Call {
+func (mr *MockAccountMockRecorder) GetBalanceByID(ctx interface{}, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceByID", reflect.TypeOf((*MockAccount)(nil).GetBalanceByID), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceByID", reflect.TypeOf((*MockAccount)(nil).GetBalanceByID), ctx, id)
 }
 
 // MoneyTransaction mocks base method.
-func (m *MockAccount) MoneyTransaction(transaction *models.Transaction) error {
+func (m *MockAccount) MoneyTransaction(ctx context.Context, transaction *models.Transaction) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "MoneyTransaction", transaction)
+	ret := m.ctrl.Call(m, "MoneyTransaction", ctx, transaction)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // MoneyTransaction indicates an expected call of MoneyTransaction.
-func (mr *MockAccountMockRecorder) MoneyTransaction(transaction interface{}) *gomock.Call {
+func (mr *MockAccountMockRecorder) MoneyTransaction(ctx interface{}, transaction interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoneyTransaction", reflect.TypeOf((*MockAccount)(nil).MoneyTransaction), ctx, transaction)
+}
+
+// MoneyTransactions mocks base method.
+func (m *MockAccount) MoneyTransactions(ctx context.Context, transactions []models.Transaction, bestEffort bool) ([]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MoneyTransactions", ctx, transactions, bestEffort)
+	ret0, _ := ret[0].([]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MoneyTransactions indicates an expected call of MoneyTransactions.
+func (mr *MockAccountMockRecorder) MoneyTransactions(ctx interface{}, transactions interface{}, bestEffort interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoneyTransactions", reflect.TypeOf((*MockAccount)(nil).MoneyTransactions), ctx, transactions, bestEffort)
+}
+
+// Refund mocks base method.
+func (m *MockAccount) Refund(ctx context.Context, refund *models.Refund) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refund", ctx, refund)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Refund indicates an expected call of Refund.
+func (mr *MockAccountMockRecorder) Refund(ctx interface{}, refund interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refund", reflect.TypeOf((*MockAccount)(nil).Refund), ctx, refund)
+}
+
+// Unfreeze mocks base method.
+func (m *MockAccount) Unfreeze(ctx context.Context, id int, version int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unfreeze", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unfreeze indicates an expected call of Unfreeze.
+func (mr *MockAccountMockRecorder) Unfreeze(ctx interface{}, id interface{}, version interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoneyTransaction", reflect.TypeOf((*MockAccount)(nil).MoneyTransaction), transaction)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unfreeze", reflect.TypeOf((*MockAccount)(nil).Unfreeze), ctx, id, version)
 }
 
 // MockTransactionHistory is a mock of TransactionHistory interface.
@@ -116,16 +286,465 @@ func (m *MockTransactionHistory) EXPECT() *MockTransactionHistoryMockRecorder {
 }
 
 // GetByAccountID mocks base method.
-func (m *MockTransactionHistory) GetByAccountID(req *models.TransactionHistoryReq) ([]models.TransactionHistory, error) {
+func (m *MockTransactionHistory) GetByAccountID(ctx context.Context, req *models.TransactionHistoryReq) ([]models.TransactionHistory, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetByAccountID", req)
+	ret := m.ctrl.Call(m, "GetByAccountID", ctx, req)
 	ret0, _ := ret[0].([]models.TransactionHistory)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetByAccountID indicates an expected call of GetByAccountID.
-func (mr *MockTransactionHistoryMockRecorder) GetByAccountID(req interface{}) *gomock.Call {
+func (mr *MockTransactionHistoryMockRecorder) GetByAccountID(ctx interface{}, req interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByAccountID", reflect.TypeOf((*MockTransactionHistory)(nil).GetByAccountID), ctx, req)
+}
+
+// GetPageByAccountID mocks base method.
+func (m *MockTransactionHistory) GetPageByAccountID(ctx context.Context, req *models.TransactionHistoryPageReq) (*models.TransactionHistoryPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPageByAccountID", ctx, req)
+	ret0, _ := ret[0].(*models.TransactionHistoryPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPageByAccountID indicates an expected call of GetPageByAccountID.
+func (mr *MockTransactionHistoryMockRecorder) GetPageByAccountID(ctx interface{}, req interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPageByAccountID", reflect.TypeOf((*MockTransactionHistory)(nil).GetPageByAccountID), ctx, req)
+}
+
+// MockReservation is a mock of Reservation interface.
+type MockReservation struct {
+	ctrl     *gomock.Controller
+	recorder *MockReservationMockRecorder
+}
+
+// MockReservationMockRecorder is the mock recorder for MockReservation.
+type MockReservationMockRecorder struct {
+	mock *MockReservation
+}
+
+// NewMockReservation creates a new mock instance.
+func NewMockReservation(ctrl *gomock.Controller) *MockReservation {
+	mock := &MockReservation{ctrl: ctrl}
+	mock.recorder = &MockReservationMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReservation) EXPECT() *MockReservationMockRecorder {
+	return m.recorder
+}
+
+// Cancel mocks base method.
+func (m *MockReservation) Cancel(ctx context.Context, res *models.Reservation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cancel", ctx, res)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Cancel indicates an expected call of Cancel.
+func (mr *MockReservationMockRecorder) Cancel(ctx interface{}, res interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockReservation)(nil).Cancel), ctx, res)
+}
+
+// Confirm mocks base method.
+func (m *MockReservation) Confirm(ctx context.Context, res *models.Reservation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Confirm", ctx, res)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Confirm indicates an expected call of Confirm.
+func (mr *MockReservationMockRecorder) Confirm(ctx interface{}, res interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Confirm", reflect.TypeOf((*MockReservation)(nil).Confirm), ctx, res)
+}
+
+// Reserve mocks base method.
+func (m *MockReservation) Reserve(ctx context.Context, res *models.Reservation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reserve", ctx, res)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reserve indicates an expected call of Reserve.
+func (mr *MockReservationMockRecorder) Reserve(ctx interface{}, res interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reserve", reflect.TypeOf((*MockReservation)(nil).Reserve), ctx, res)
+}
+
+// MockIdempotency is a mock of Idempotency interface.
+type MockIdempotency struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyMockRecorder
+}
+
+// MockIdempotencyMockRecorder is the mock recorder for MockIdempotency.
+type MockIdempotencyMockRecorder struct {
+	mock *MockIdempotency
+}
+
+// NewMockIdempotency creates a new mock instance.
+func NewMockIdempotency(ctrl *gomock.Controller) *MockIdempotency {
+	mock := &MockIdempotency{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotency) EXPECT() *MockIdempotencyMockRecorder {
+	return m.recorder
+}
+
+// Claim mocks base method.
+func (m *MockIdempotency) Claim(ctx context.Context, key *models.IdempotencyKey, staleBefore time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Claim", ctx, key, staleBefore)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Claim indicates an expected call of Claim.
+func (mr *MockIdempotencyMockRecorder) Claim(ctx interface{}, key interface{}, staleBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Claim", reflect.TypeOf((*MockIdempotency)(nil).Claim), ctx, key, staleBefore)
+}
+
+// DeleteExpired mocks base method.
+func (m *MockIdempotency) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpired", ctx, now)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpired indicates an expected call of DeleteExpired.
+func (mr *MockIdempotencyMockRecorder) DeleteExpired(ctx interface{}, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpired", reflect.TypeOf((*MockIdempotency)(nil).DeleteExpired), ctx, now)
+}
+
+// GetByKey mocks base method.
+func (m *MockIdempotency) GetByKey(ctx context.Context, keyID string, key string) (*models.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByKey", ctx, keyID, key)
+	ret0, _ := ret[0].(*models.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByKey indicates an expected call of GetByKey.
+func (mr *MockIdempotencyMockRecorder) GetByKey(ctx interface{}, keyID interface{}, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByKey", reflect.TypeOf((*MockIdempotency)(nil).GetByKey), ctx, keyID, key)
+}
+
+// Release mocks base method.
+func (m *MockIdempotency) Release(ctx context.Context, keyID string, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", ctx, keyID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Release indicates an expected call of Release.
+func (mr *MockIdempotencyMockRecorder) Release(ctx interface{}, keyID interface{}, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockIdempotency)(nil).Release), ctx, keyID, key)
+}
+
+// SaveResponse mocks base method.
+func (m *MockIdempotency) SaveResponse(ctx context.Context, key *models.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveResponse", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveResponse indicates an expected call of SaveResponse.
+func (mr *MockIdempotencyMockRecorder) SaveResponse(ctx interface{}, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveResponse", reflect.TypeOf((*MockIdempotency)(nil).SaveResponse), ctx, key)
+}
+
+// MockReport is a mock of Report interface.
+type MockReport struct {
+	ctrl     *gomock.Controller
+	recorder *MockReportMockRecorder
+}
+
+// MockReportMockRecorder is the mock recorder for MockReport.
+type MockReportMockRecorder struct {
+	mock *MockReport
+}
+
+// NewMockReport creates a new mock instance.
+func NewMockReport(ctrl *gomock.Controller) *MockReport {
+	mock := &MockReport{ctrl: ctrl}
+	mock.recorder = &MockReportMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReport) EXPECT() *MockReportMockRecorder {
+	return m.recorder
+}
+
+// RevenueByService mocks base method.
+func (m *MockReport) RevenueByService(ctx context.Context, from time.Time, to time.Time, fn func(row *models.ServiceRevenue) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevenueByService", ctx, from, to, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevenueByService indicates an expected call of RevenueByService.
+func (mr *MockReportMockRecorder) RevenueByService(ctx interface{}, from interface{}, to interface{}, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevenueByService", reflect.TypeOf((*MockReport)(nil).RevenueByService), ctx, from, to, fn)
+}
+
+// MockAPIClients is a mock of APIClients interface.
+type MockAPIClients struct {
+	ctrl     *gomock.Controller
+	recorder *MockAPIClientsMockRecorder
+}
+
+// MockAPIClientsMockRecorder is the mock recorder for MockAPIClients.
+type MockAPIClientsMockRecorder struct {
+	mock *MockAPIClients
+}
+
+// NewMockAPIClients creates a new mock instance.
+func NewMockAPIClients(ctrl *gomock.Controller) *MockAPIClients {
+	mock := &MockAPIClients{ctrl: ctrl}
+	mock.recorder = &MockAPIClientsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAPIClients) EXPECT() *MockAPIClientsMockRecorder {
+	return m.recorder
+}
+
+// CreateClient mocks base method.
+func (m *MockAPIClients) CreateClient(ctx context.Context, client *models.APIClient) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateClient", ctx, client)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateClient indicates an expected call of CreateClient.
+func (mr *MockAPIClientsMockRecorder) CreateClient(ctx interface{}, client interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateClient", reflect.TypeOf((*MockAPIClients)(nil).CreateClient), ctx, client)
+}
+
+// DeleteExpiredNonces mocks base method.
+func (m *MockAPIClients) DeleteExpiredNonces(ctx context.Context, now time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredNonces", ctx, now)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredNonces indicates an expected call of DeleteExpiredNonces.
+func (mr *MockAPIClientsMockRecorder) DeleteExpiredNonces(ctx interface{}, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredNonces", reflect.TypeOf((*MockAPIClients)(nil).DeleteExpiredNonces), ctx, now)
+}
+
+// GetClientByKeyID mocks base method.
+func (m *MockAPIClients) GetClientByKeyID(ctx context.Context, keyID string) (*models.APIClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClientByKeyID", ctx, keyID)
+	ret0, _ := ret[0].(*models.APIClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClientByKeyID indicates an expected call of GetClientByKeyID.
+func (mr *MockAPIClientsMockRecorder) GetClientByKeyID(ctx interface{}, keyID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClientByKeyID", reflect.TypeOf((*MockAPIClients)(nil).GetClientByKeyID), ctx, keyID)
+}
+
+// RevokeClient mocks base method.
+func (m *MockAPIClients) RevokeClient(ctx context.Context, keyID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeClient", ctx, keyID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeClient indicates an expected call of RevokeClient.
+func (mr *MockAPIClientsMockRecorder) RevokeClient(ctx interface{}, keyID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeClient", reflect.TypeOf((*MockAPIClients)(nil).RevokeClient), ctx, keyID)
+}
+
+// UseNonce mocks base method.
+func (m *MockAPIClients) UseNonce(ctx context.Context, keyID string, nonce string, expiresAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseNonce", ctx, keyID, nonce, expiresAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseNonce indicates an expected call of UseNonce.
+func (mr *MockAPIClientsMockRecorder) UseNonce(ctx interface{}, keyID interface{}, nonce interface{}, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseNonce", reflect.TypeOf((*MockAPIClients)(nil).UseNonce), ctx, keyID, nonce, expiresAt)
+}
+
+// MockScheduledTransfers is a mock of ScheduledTransfers interface.
+type MockScheduledTransfers struct {
+	ctrl     *gomock.Controller
+	recorder *MockScheduledTransfersMockRecorder
+}
+
+// MockScheduledTransfersMockRecorder is the mock recorder for MockScheduledTransfers.
+type MockScheduledTransfersMockRecorder struct {
+	mock *MockScheduledTransfers
+}
+
+// NewMockScheduledTransfers creates a new mock instance.
+func NewMockScheduledTransfers(ctrl *gomock.Controller) *MockScheduledTransfers {
+	mock := &MockScheduledTransfers{ctrl: ctrl}
+	mock.recorder = &MockScheduledTransfersMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockScheduledTransfers) EXPECT() *MockScheduledTransfersMockRecorder {
+	return m.recorder
+}
+
+// CancelSchedule mocks base method.
+func (m *MockScheduledTransfers) CancelSchedule(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelSchedule", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelSchedule indicates an expected call of CancelSchedule.
+func (mr *MockScheduledTransfersMockRecorder) CancelSchedule(ctx interface{}, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelSchedule", reflect.TypeOf((*MockScheduledTransfers)(nil).CancelSchedule), ctx, id)
+}
+
+// CreateSchedule mocks base method.
+func (m *MockScheduledTransfers) CreateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSchedule", ctx, schedule)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSchedule indicates an expected call of CreateSchedule.
+func (mr *MockScheduledTransfersMockRecorder) CreateSchedule(ctx interface{}, schedule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSchedule", reflect.TypeOf((*MockScheduledTransfers)(nil).CreateSchedule), ctx, schedule)
+}
+
+// DueSchedules mocks base method.
+func (m *MockScheduledTransfers) DueSchedules(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DueSchedules", ctx, now, limit)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DueSchedules indicates an expected call of DueSchedules.
+func (mr *MockScheduledTransfersMockRecorder) DueSchedules(ctx interface{}, now interface{}, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DueSchedules", reflect.TypeOf((*MockScheduledTransfers)(nil).DueSchedules), ctx, now, limit)
+}
+
+// GetSchedule mocks base method.
+func (m *MockScheduledTransfers) GetSchedule(ctx context.Context, id int) (*models.ScheduledTransfer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSchedule", ctx, id)
+	ret0, _ := ret[0].(*models.ScheduledTransfer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSchedule indicates an expected call of GetSchedule.
+func (mr *MockScheduledTransfersMockRecorder) GetSchedule(ctx interface{}, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSchedule", reflect.TypeOf((*MockScheduledTransfers)(nil).GetSchedule), ctx, id)
+}
+
+// ListRuns mocks base method.
+func (m *MockScheduledTransfers) ListRuns(ctx context.Context, scheduleID int, limit int) ([]models.ScheduledTransferRun, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRuns", ctx, scheduleID, limit)
+	ret0, _ := ret[0].([]models.ScheduledTransferRun)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRuns indicates an expected call of ListRuns.
+func (mr *MockScheduledTransfersMockRecorder) ListRuns(ctx interface{}, scheduleID interface{}, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRuns", reflect.TypeOf((*MockScheduledTransfers)(nil).ListRuns), ctx, scheduleID, limit)
+}
+
+// ListSchedules mocks base method.
+func (m *MockScheduledTransfers) ListSchedules(ctx context.Context, accountID int) ([]models.ScheduledTransfer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSchedules", ctx, accountID)
+	ret0, _ := ret[0].([]models.ScheduledTransfer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSchedules indicates an expected call of ListSchedules.
+func (mr *MockScheduledTransfersMockRecorder) ListSchedules(ctx interface{}, accountID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSchedules", reflect.TypeOf((*MockScheduledTransfers)(nil).ListSchedules), ctx, accountID)
+}
+
+// RunSchedule mocks base method.
+func (m *MockScheduledTransfers) RunSchedule(ctx context.Context, id int, now time.Time, policy models.RetryPolicy) (*models.ScheduledTransferRun, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunSchedule", ctx, id, now, policy)
+	ret0, _ := ret[0].(*models.ScheduledTransferRun)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunSchedule indicates an expected call of RunSchedule.
+func (mr *MockScheduledTransfersMockRecorder) RunSchedule(ctx interface{}, id interface{}, now interface{}, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunSchedule", reflect.TypeOf((*MockScheduledTransfers)(nil).RunSchedule), ctx, id, now, policy)
+}
+
+// UpdateSchedule mocks base method.
+func (m *MockScheduledTransfers) UpdateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateSchedule", ctx, schedule)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateSchedule indicates an expected call of UpdateSchedule.
+func (mr *MockScheduledTransfersMockRecorder) UpdateSchedule(ctx interface{}, schedule interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByAccountID", reflect.TypeOf((*MockTransactionHistory)(nil).GetByAccountID), req)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSchedule", reflect.TypeOf((*MockScheduledTransfers)(nil).UpdateSchedule), ctx, schedule)
 }
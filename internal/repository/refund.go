@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Refund posts an entry returning money of a transfer between two customers
+// from its receiver to its sender, and links the history rows it adds to the
+// rows of the transfer. The rows of the transfer are locked first, so
+// concurrent refunds of it are made one at a time and never return more than
+// was sent. A zero refund.Amount refunds what is left.
+func (rep *account) Refund(ctx context.Context, refund *models.Refund) (err error) {
+	// refund is only filled in once committed, as fn may run more than once.
+	var result models.Refund
+	err = inTransaction(ctx, rep.db, func(q Querier) error {
+		sent, received, err := refundableTransfer(ctx, q, refund.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		sentBack, err := refundedAmount(ctx, q, sent.TransactionID)
+		if err != nil {
+			return err
+		}
+		takenBack, err := refundedAmount(ctx, q, received.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		remaining := -sent.Amount - sentBack
+		amount := refund.Amount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount == 0 || amount > remaining {
+			return ErrRefundExceedsAmount
+		}
+		if models.MoneyIn(amount, sent.Currency) != nil {
+			return ErrRefundPrecision
+		}
+
+		comment := refund.Comment
+		if comment == "" {
+			comment = fmt.Sprintf("refund of transaction %d", refund.TransactionID)
+		}
+
+		entry := refundEntry(sent, received, amount, -takenBack, amount == remaining, comment, time.Now().UTC())
+		if err = postEntry(ctx, q, entry); err != nil {
+			return err
+		}
+
+		result = models.Refund{
+			Amount:    amount,
+			Comment:   comment,
+			Currency:  sent.Currency,
+			EntryID:   entry.ID,
+			Refunded:  sentBack + amount,
+			Remaining: remaining - amount,
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	result.TransactionID = refund.TransactionID
+	*refund = result
+	return nil
+}
+
+// refundableTransfer locks and returns the history rows of the transfer the
+// history row id belongs to: the one debiting the sender and the one
+// crediting the receiver. Rows of anything but a transfer between two
+// customers, refunds included, cannot be refunded.
+func refundableTransfer(ctx context.Context, q Querier, id int) (sent, received *models.TransactionHistory, err error) {
+	var entryID, refundOf int
+	query := `SELECT COALESCE(entry_id, 0), COALESCE(refund_of, 0)
+			FROM transactions
+			WHERE transaction_id = $1`
+
+	err = q.QueryRowContext(ctx, query, id).Scan(&entryID, &refundOf)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if entryID == 0 || refundOf != 0 {
+		return nil, nil, ErrNotRefundable
+	}
+
+	query = `SELECT transaction_id, account_id, amount, currency, COALESCE(rate, 0)
+			FROM transactions
+			WHERE entry_id = $1
+			ORDER BY transaction_id
+			FOR UPDATE`
+
+	rows, err := q.QueryContext(ctx, query, entryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var sides []*models.TransactionHistory
+	for rows.Next() {
+		tr := &models.TransactionHistory{EntryID: entryID}
+		if err = rows.Scan(&tr.TransactionID, &tr.AccountID, &tr.Amount, &tr.Currency, &tr.Rate); err != nil {
+			return nil, nil, err
+		}
+		sides = append(sides, tr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(sides) != 2 {
+		return nil, nil, ErrNotRefundable
+	}
+	sent, received = sides[0], sides[1]
+	if sent.Amount > 0 {
+		sent, received = received, sent
+	}
+	if sent.Amount >= 0 || received.Amount <= 0 {
+		return nil, nil, ErrNotRefundable
+	}
+
+	return sent, received, nil
+}
+
+// refundedAmount returns the sum of the history rows refunding the row id:
+// positive for the sender's row, negative for the receiver's.
+func refundedAmount(ctx context.Context, q Querier, id int) (amount models.Money, err error) {
+	query := `SELECT COALESCE(sum(amount), 0)
+			FROM transactions
+			WHERE refund_of = $1`
+
+	err = q.QueryRowContext(ctx, query, id).Scan(&amount)
+	return amount, err
+}
+
+// refundEntry builds an entry returning amount, in the sender's currency, of
+// a transfer. When the receiver was paid in another currency, the part of
+// what it received that is taken back is amount converted at the rate of the
+// transfer, or, for the last refund, all that is left of it, alreadyTaken
+// being what earlier refunds took back. This way rounding never takes back
+// more than was received.
+func refundEntry(sent, received *models.TransactionHistory, amount, alreadyTaken models.Money, last bool, comment string, date time.Time) *models.JournalEntry {
+	entry := transfer(received.AccountID, sent.AccountID, sent.Currency, amount, comment, date)
+	if received.Currency != sent.Currency {
+		left := received.Amount - alreadyTaken
+		taken := amount.Convert(received.Rate, received.Currency)
+		if last || taken > left {
+			taken = left
+		}
+		entry = &models.JournalEntry{
+			Date:    date,
+			Comment: comment,
+			Rate:    received.Rate,
+			Postings: []models.Posting{
+				{AccountID: received.AccountID, Currency: received.Currency, Amount: -taken},
+				{AccountID: ExchangeAccountID, Currency: received.Currency, Amount: taken},
+				{AccountID: ExchangeAccountID, Currency: sent.Currency, Amount: -amount},
+				{AccountID: sent.AccountID, Currency: sent.Currency, Amount: amount},
+			},
+		}
+	}
+	entry.RefundOf = map[int]int{
+		sent.AccountID:     sent.TransactionID,
+		received.AccountID: received.TransactionID,
+	}
+
+	return entry
+}
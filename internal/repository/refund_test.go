@@ -0,0 +1,153 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Refund(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewAccountRepository(db, log, false)
+
+	originalQuery := regexp.QuoteMeta("SELECT COALESCE(entry_id, 0), COALESCE(refund_of, 0) FROM transactions WHERE transaction_id = $1")
+	sidesQuery := regexp.QuoteMeta("SELECT transaction_id, account_id, amount, currency, COALESCE(rate, 0) FROM transactions WHERE entry_id = $1 ORDER BY transaction_id FOR UPDATE")
+	refundedQuery := regexp.QuoteMeta("SELECT COALESCE(sum(amount), 0) FROM transactions WHERE refund_of = $1")
+
+	// transaction 11 sent 500.00 from account 1 to account 2, which got it
+	// as transaction 12; 200.00 of it was refunded already.
+	expectTransfer := func(id int) {
+		mock.ExpectQuery(originalQuery).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"entry_id", "refund_of"}).AddRow(5, 0))
+		mock.ExpectQuery(sidesQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "account_id", "amount", "currency", "rate"}).
+			AddRow(11, 1, "-500.00", "RUB", 0).
+			AddRow(12, 2, "500.00", "RUB", 0))
+		mock.ExpectQuery(refundedQuery).WithArgs(11).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("200.00"))
+		mock.ExpectQuery(refundedQuery).WithArgs(12).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("-200.00"))
+	}
+	refundOf := map[int]int{1: 11, 2: 12}
+
+	testTable := []struct {
+		name           string
+		refund         *models.Refund
+		mock           func()
+		expectedRefund *models.Refund
+		expectedError  error
+	}{
+		{
+			name:   "the rest",
+			refund: &models.Refund{TransactionID: 12},
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(12)
+				for _, step := range refundSteps(mock, 9, 2, 1, "RUB", 3000000, "refund of transaction 12", refundOf) {
+					step(false)
+				}
+				mock.ExpectCommit()
+			},
+			expectedRefund: &models.Refund{TransactionID: 12, Amount: 3000000, Comment: "refund of transaction 12", Currency: "RUB",
+				EntryID: 9, Refunded: 5000000, Remaining: 0},
+		},
+		{
+			name:   "partial",
+			refund: &models.Refund{TransactionID: 11, Amount: 500000, Comment: "damaged item"},
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(11)
+				for _, step := range refundSteps(mock, 9, 2, 1, "RUB", 500000, "damaged item", refundOf) {
+					step(false)
+				}
+				mock.ExpectCommit()
+			},
+			expectedRefund: &models.Refund{TransactionID: 11, Amount: 500000, Comment: "damaged item", Currency: "RUB",
+				EntryID: 9, Refunded: 2500000, Remaining: 2500000},
+		},
+		{
+			name:   "more than is left",
+			refund: &models.Refund{TransactionID: 11, Amount: 3000100},
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(11)
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrRefundExceedsAmount,
+		},
+		{
+			name:   "finer than kopecks",
+			refund: &models.Refund{TransactionID: 11, Amount: 500050},
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(11)
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrRefundPrecision,
+		},
+		{
+			name:   "receiver spent it",
+			refund: &models.Refund{TransactionID: 11},
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(11)
+				steps := refundSteps(mock, 9, 2, 1, "RUB", 3000000, "refund of transaction 11", refundOf)
+				steps[0](false)
+				steps[1](false)
+				expectLockBalance(mock, 2, "RUB", "10.00")
+				mock.ExpectRollback()
+			},
+			expectedError: &repository.InsufficientFundsError{AccountID: 2, Currency: "RUB", Balance: 100000, Requested: 3000000},
+		},
+		{
+			name:   "unknown transaction",
+			refund: &models.Refund{TransactionID: 13},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(originalQuery).WithArgs(13).WillReturnRows(sqlmock.NewRows([]string{"entry_id", "refund_of"}))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrTransactionNotFound,
+		},
+		{
+			name:   "refund of a refund",
+			refund: &models.Refund{TransactionID: 14},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(originalQuery).WithArgs(14).WillReturnRows(sqlmock.NewRows([]string{"entry_id", "refund_of"}).AddRow(9, 12))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrNotRefundable,
+		},
+		{
+			name:   "deposit",
+			refund: &models.Refund{TransactionID: 3},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(originalQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"entry_id", "refund_of"}).AddRow(2, 0))
+				mock.ExpectQuery(sidesQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "account_id", "amount", "currency", "rate"}).
+					AddRow(3, 1, "500.00", "RUB", 0))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrNotRefundable,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.mock()
+
+			err := r.Refund(context.Background(), testCase.refund)
+			assert.Equal(t, testCase.expectedError, err)
+			if err == nil {
+				assert.Equal(t, testCase.expectedRefund, testCase.refund)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
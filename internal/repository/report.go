@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/logger"
+	"context"
+	"time"
+
+	"database/sql"
+)
+
+type report struct {
+	db     *sql.DB
+	logger logger.Logger
+}
+
+func NewReportRepository(db *sql.DB, logger logger.Logger) (repository Report) {
+	return &report{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// RevenueByService sums confirmed charges per service in [from, to) and
+// passes the rows to fn one at a time, so the result is never held in memory.
+func (rep *report) RevenueByService(ctx context.Context, from, to time.Time, fn func(row *models.ServiceRevenue) error) (err error) {
+	query := `SELECT service_id,
+					sum(amount)
+			FROM revenue
+			WHERE date_time >= $1 AND date_time < $2
+			GROUP BY service_id
+			ORDER BY service_id`
+
+	rows, err := rep.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting revenue report. err: %s", err)
+		return err
+	}
+	defer rows.Close()
+
+	row := &models.ServiceRevenue{}
+	for rows.Next() {
+		if err = rows.Scan(
+			&row.ServiceID,
+			&row.Amount,
+		); err != nil {
+			rep.logger.WithContext(ctx).Errorf("error occurred while getting revenue report. err: %s", err)
+			return err
+		}
+
+		err = fn(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,70 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RevenueByService(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewReportRepository(db, log)
+	from := time.Date(2022, 03, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	query := regexp.QuoteMeta("SELECT service_id, sum(amount) FROM revenue WHERE date_time >= $1 AND date_time < $2 GROUP BY service_id ORDER BY service_id")
+
+	testTable := []struct {
+		name           string
+		mock           func()
+		expectedResult []models.ServiceRevenue
+		expectedError  bool
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				rows := sqlmock.NewRows([]string{"service_id", "sum"}).
+					AddRow(1, "100.50").AddRow(2, "7.00")
+				mock.ExpectQuery(query).WithArgs(from, to).WillReturnRows(rows)
+			},
+			expectedResult: []models.ServiceRevenue{
+				{ServiceID: 1, Amount: 1005000},
+				{ServiceID: 2, Amount: 70000},
+			},
+		},
+		{
+			name: "query fails",
+			mock: func() {
+				mock.ExpectQuery(query).WithArgs(from, to).WillReturnError(errors.New("no rows"))
+			},
+			expectedError: true,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			var result []models.ServiceRevenue
+			err := r.RevenueByService(context.Background(), from, to, func(row *models.ServiceRevenue) error {
+				result = append(result, *row)
+				return nil
+			})
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, result)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -3,8 +3,14 @@ package repository
 import (
 	"avito-tech/internal/models"
 	"avito-tech/pkg/logger"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 
@@ -14,29 +20,213 @@ var (
 	ErrNoRowsAffected = errors.New("now rows affected")
 	ErrUserDoesntExist = errors.New("user doesnt exist")
 
+	ErrAccountExists   = errors.New("account already exists")
+	ErrAccountFrozen   = errors.New("account is frozen")
+	ErrAccountClosed   = errors.New("account is closed")
+	ErrAccountNotEmpty = errors.New("account still holds money")
+	ErrVersionMismatch = errors.New("account was changed since the given version")
+
 	ErrNewAccNegativeBalance = errors.New("can not set a negative balance for a new account")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationExists   = errors.New("order was already reserved")
+
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrNotRefundable       = errors.New("transaction is not a refundable transfer")
+	ErrRefundExceedsAmount = errors.New("refund exceeds the amount not yet refunded")
+	ErrRefundPrecision     = errors.New("refund has more decimal places than the transfer currency allows")
+
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+	ErrClientNotFound = errors.New("api client not found")
+
+	ErrScheduleNotFound  = errors.New("scheduled transfer not found")
+	ErrScheduleNotActive = errors.New("scheduled transfer is no longer active")
 
 )
 
+// InsufficientFundsError is returned when a debit would overdraw a customer
+// account. It matches ErrInsufficientFunds with errors.Is.
+type InsufficientFundsError struct {
+	AccountID int
+	Currency  string
+	Balance   models.Money
+	Requested models.Money
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%s: account %d has %s %s, requested %s",
+		ErrInsufficientFunds, e.AccountID, e.Balance, e.Currency, e.Requested)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// BatchError is returned when a transaction of an all-or-nothing batch fails
+// and the whole batch is rolled back. Index is the position of the failed
+// transaction in the batch.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("transaction %d of the batch: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Querier is implemented by both *sql.DB and *sql.Tx, so helpers written
+// against it can run inside the caller's transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Account interface {
-	GetBalanceByID(id int) (acc *models.Account, err error)
-	ChangeBalance(acc *models.AccountDebit) (err error)
-	GetAll() (acc []models.Account, err error)
-	MoneyTransaction(transaction *models.Transaction) (err error)
+	GetBalanceByID(ctx context.Context, id int) (acc *models.Account, err error)
+	ChangeBalance(ctx context.Context, acc *models.AccountDebit) (err error)
+	GetAll(ctx context.Context) (acc []models.Account, err error)
+	MoneyTransaction(ctx context.Context, transaction *models.Transaction) (err error)
+	MoneyTransactions(ctx context.Context, transactions []models.Transaction, bestEffort bool) (errs []error, err error)
+	Create(ctx context.Context, id int) (err error)
+	Freeze(ctx context.Context, id int, version int64) (err error)
+	Unfreeze(ctx context.Context, id int, version int64) (err error)
+	Close(ctx context.Context, id int, version int64) (err error)
+	Refund(ctx context.Context, refund *models.Refund) (err error)
 }
 
 type TransactionHistory interface {
-	GetByAccountID(req *models.TransactionHistoryReq) (tr []models.TransactionHistory, err error)
+	GetByAccountID(ctx context.Context, req *models.TransactionHistoryReq) (tr []models.TransactionHistory, err error)
+	GetPageByAccountID(ctx context.Context, req *models.TransactionHistoryPageReq) (page *models.TransactionHistoryPage, err error)
+}
+
+type Reservation interface {
+	Reserve(ctx context.Context, res *models.Reservation) (err error)
+	Confirm(ctx context.Context, res *models.Reservation) (err error)
+	Cancel(ctx context.Context, res *models.Reservation) (err error)
+}
+
+type Idempotency interface {
+	Claim(ctx context.Context, key *models.IdempotencyKey, staleBefore time.Time) (claimed bool, err error)
+	GetByKey(ctx context.Context, keyID, key string) (stored *models.IdempotencyKey, err error)
+	SaveResponse(ctx context.Context, key *models.IdempotencyKey) (err error)
+	Release(ctx context.Context, keyID, key string) (err error)
+	DeleteExpired(ctx context.Context, now time.Time) (deleted int64, err error)
+}
+
+type Report interface {
+	RevenueByService(ctx context.Context, from, to time.Time, fn func(row *models.ServiceRevenue) error) (err error)
+}
+
+type APIClients interface {
+	CreateClient(ctx context.Context, client *models.APIClient) (err error)
+	GetClientByKeyID(ctx context.Context, keyID string) (client *models.APIClient, err error)
+	RevokeClient(ctx context.Context, keyID string) (err error)
+	UseNonce(ctx context.Context, keyID, nonce string, expiresAt time.Time) (fresh bool, err error)
+	DeleteExpiredNonces(ctx context.Context, now time.Time) (deleted int64, err error)
+}
+
+type ScheduledTransfers interface {
+	CreateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) (err error)
+	GetSchedule(ctx context.Context, id int) (schedule *models.ScheduledTransfer, err error)
+	ListSchedules(ctx context.Context, accountID int) (schedules []models.ScheduledTransfer, err error)
+	UpdateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) (err error)
+	CancelSchedule(ctx context.Context, id int) (err error)
+	ListRuns(ctx context.Context, scheduleID, limit int) (runs []models.ScheduledTransferRun, err error)
+	DueSchedules(ctx context.Context, now time.Time, limit int) (ids []int, err error)
+	RunSchedule(ctx context.Context, id int, now time.Time, policy models.RetryPolicy) (run *models.ScheduledTransferRun, err error)
 }
 
 type Repository struct {
 	Account
 	TransactionHistory
+	Reservation
+	Idempotency
+	Report
+	APIClients
+	ScheduledTransfers
+}
+
+// Transactions aborted by a deadlock or a serialization failure are run again
+// up to maxTxAttempts times, waiting a growing, jittered delay in between.
+const (
+	maxTxAttempts    = 5
+	txRetryBaseDelay = 10 * time.Millisecond
+	txRetryMaxDelay  = 200 * time.Millisecond
+)
+
+// SQLSTATEs of transactions Postgres aborted only because of concurrent ones.
+const (
+	deadlockDetected     = "40P01"
+	serializationFailure = "40001"
+)
+
+// inTransaction runs fn in a single transaction. The transaction is rolled
+// back if fn returns an error and committed otherwise. Cancelling ctx rolls
+// it back as well. When Postgres aborts the transaction because of a deadlock
+// or a serialization failure, fn is run again in a new one, so fn must not
+// keep state between runs.
+func inTransaction(ctx context.Context, db *sql.DB, fn func(q Querier) error) (err error) {
+	for attempt := 1; ; attempt++ {
+		err = runTransaction(ctx, db, fn)
+		if !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(retryDelay(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func runTransaction(ctx context.Context, db *sql.DB, fn func(q Querier) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == deadlockDetected || pqErr.Code == serializationFailure)
+}
+
+// retryDelay doubles with every attempt up to txRetryMaxDelay, and is
+// randomised so that the transactions that collided do not collide again.
+func retryDelay(attempt int) time.Duration {
+	delay := txRetryBaseDelay << (attempt - 1)
+	if delay > txRetryMaxDelay {
+		delay = txRetryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
-func New(db *sql.DB, logger logger.Logger) (repository *Repository) {
+// New builds every repository. autoCreateAccounts lets a deposit to an
+// unknown account open it instead of failing.
+func New(db *sql.DB, logger logger.Logger, autoCreateAccounts bool) (repository *Repository) {
 	return &Repository{
-		Account:            NewAccountRepository(db, logger),
+		Account:            NewAccountRepository(db, logger, autoCreateAccounts),
 		TransactionHistory: NewTransactionRepository(db, logger),
+		Reservation:        NewReservationRepository(db, logger),
+		Idempotency:        NewIdempotencyRepository(db, logger),
+		Report:             NewReportRepository(db, logger),
+		APIClients:         NewAPIClientRepository(db, logger),
+		ScheduledTransfers: NewScheduledTransferRepository(db, logger),
 	}
 }
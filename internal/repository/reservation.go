@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const (
+	statusReserved  = "reserved"
+	statusConfirmed = "confirmed"
+	statusCancelled = "cancelled"
+)
+
+// uniqueViolation is the SQLSTATE of a row that duplicates a unique key.
+const uniqueViolation = "23505"
+
+type reservation struct {
+	db     *sql.DB
+	logger logger.Logger
+}
+
+func NewReservationRepository(db *sql.DB, logger logger.Logger) (repository Reservation) {
+	return &reservation{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (rep *reservation) Reserve(ctx context.Context, res *models.Reservation) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		now := time.Now().UTC()
+
+		comment := fmt.Sprintf("reserved for order %d, service %d", res.OrderID, res.ServiceID)
+		entry := transfer(res.AccountID, HoldsAccountID, models.DefaultCurrency, res.Amount, comment, now)
+		entry.ExpectedVersions = expectVersion(res.AccountID, res.ExpectedVersion)
+		err := postEntry(ctx, q, entry)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO reservations (account_id, order_id, service_id, amount, status, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $6)`
+
+		_, err = q.ExecContext(ctx, query,
+			res.AccountID,
+			res.OrderID,
+			res.ServiceID,
+			res.Amount,
+			statusReserved,
+			now)
+		if isUniqueViolation(err) {
+			return ErrReservationExists
+		}
+		return err
+	})
+}
+
+func (rep *reservation) Confirm(ctx context.Context, res *models.Reservation) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		now := time.Now().UTC()
+
+		err := closeReservation(ctx, q, res, statusConfirmed, now)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO revenue (account_id, order_id, service_id, amount, date_time)
+				VALUES ($1, $2, $3, $4, $5)`
+
+		_, err = q.ExecContext(ctx, query,
+			res.AccountID,
+			res.OrderID,
+			res.ServiceID,
+			res.Amount,
+			now)
+		if err != nil {
+			return err
+		}
+
+		comment := fmt.Sprintf("paid %s for order %d, service %d", res.Amount, res.OrderID, res.ServiceID)
+		entry := transfer(HoldsAccountID, RevenueAccountID, models.DefaultCurrency, res.Amount, comment, now)
+		err = postEntry(ctx, q, entry)
+		if err != nil {
+			return err
+		}
+
+		// the money already left the balance when it was reserved, so the
+		// customer's history only records the payment itself.
+		th := &models.TransactionHistory{
+			AccountID:      res.AccountID,
+			Amount:         0,
+			Date:           now,
+			Comment:        comment,
+			EntryID:        entry.ID,
+			CounterpartyID: RevenueAccountID,
+			Currency:       models.DefaultCurrency,
+		}
+		return addInTransactionsHistory(ctx, q, th)
+	})
+}
+
+func (rep *reservation) Cancel(ctx context.Context, res *models.Reservation) (err error) {
+	return inTransaction(ctx, rep.db, func(q Querier) error {
+		now := time.Now().UTC()
+
+		err := closeReservation(ctx, q, res, statusCancelled, now)
+		if err != nil {
+			return err
+		}
+
+		comment := fmt.Sprintf("reservation cancelled for order %d, service %d", res.OrderID, res.ServiceID)
+		entry := transfer(HoldsAccountID, res.AccountID, models.DefaultCurrency, res.Amount, comment, now)
+		entry.ExpectedVersions = expectVersion(res.AccountID, res.ExpectedVersion)
+		return postEntry(ctx, q, entry)
+	})
+}
+
+// closeReservation moves a held reservation into its final status. Only
+// reservations that are still held and match the requested amount can be closed.
+func closeReservation(ctx context.Context, q Querier, res *models.Reservation, status string, now time.Time) (err error) {
+	query := `UPDATE reservations
+			SET status = $5, updated_at = $6
+			WHERE account_id = $1 AND order_id = $2 AND service_id = $3 AND amount = $4 AND status = $7`
+
+	result, err := q.ExecContext(ctx, query,
+		res.AccountID,
+		res.OrderID,
+		res.ServiceID,
+		res.Amount,
+		status,
+		now,
+		statusReserved)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
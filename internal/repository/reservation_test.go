@@ -0,0 +1,224 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reserve(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewReservationRepository(db, log)
+
+	res := &models.Reservation{
+		AccountID: 1,
+		ServiceID: 2,
+		OrderID:   3,
+		Amount:    100000,
+	}
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(mock, 5, res.AccountID, repository.HoldsAccountID, models.DefaultCurrency, res.Amount, "reserved for order 3, service 2")
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reservations")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, "reserved", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "no such account",
+			mock: func() {
+				mock.ExpectBegin()
+				steps := transferSteps(mock, 5, res.AccountID, repository.HoldsAccountID, models.DefaultCurrency, res.Amount, "reserved for order 3, service 2")
+				steps[0](false)
+				steps[1](false)
+				expectLockBalance(mock, res.AccountID, models.DefaultCurrency, nil)
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances (account_id, currency, balance)")).
+					WithArgs(res.AccountID, models.DefaultCurrency, -res.Amount, false).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectAccountStatus(mock, res.AccountID, "")
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrUserDoesntExist,
+		},
+		{
+			name: "order already reserved",
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(mock, 5, res.AccountID, repository.HoldsAccountID, models.DefaultCurrency, res.Amount, "reserved for order 3, service 2")
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reservations")).
+					WillReturnError(&pq.Error{Code: "23505", Constraint: "reservation_is_unique"})
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrReservationExists,
+		},
+		{
+			name: "reservation insert fails",
+			mock: func() {
+				mock.ExpectBegin()
+				expectTransfer(mock, 5, res.AccountID, repository.HoldsAccountID, models.DefaultCurrency, res.Amount, "reserved for order 3, service 2")
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reservations")).
+					WillReturnError(errStep)
+				mock.ExpectRollback()
+			},
+			expectedError: errStep,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.Reserve(context.Background(), res)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_Confirm(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewReservationRepository(db, log)
+
+	res := &models.Reservation{
+		AccountID: 1,
+		ServiceID: 2,
+		OrderID:   3,
+		Amount:    100000,
+	}
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, "confirmed", sqlmock.AnyArg(), "reserved").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO revenue")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				expectTransfer(mock, 5, repository.HoldsAccountID, repository.RevenueAccountID, models.DefaultCurrency, res.Amount, "paid 10.00 for order 3, service 2")
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions")).
+					WithArgs(res.AccountID, models.Money(0), sqlmock.AnyArg(), "paid 10.00 for order 3, service 2", 5, repository.RevenueAccountID, models.DefaultCurrency, nil, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "not reserved",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrReservationNotFound,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			err := r.Confirm(context.Background(), res)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_Cancel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewReservationRepository(db, log)
+
+	res := &models.Reservation{
+		AccountID: 1,
+		ServiceID: 2,
+		OrderID:   3,
+		Amount:    100000,
+	}
+
+	testTable := []struct {
+		name          string
+		version       int64
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, "cancelled", sqlmock.AnyArg(), "reserved").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectTransfer(mock, 5, repository.HoldsAccountID, res.AccountID, models.DefaultCurrency, res.Amount, "reservation cancelled for order 3, service 2")
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:    "at expected version",
+			version: lockedVersion,
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, "cancelled", sqlmock.AnyArg(), "reserved").WillReturnResult(sqlmock.NewResult(0, 1))
+				expectTransfer(mock, 5, repository.HoldsAccountID, res.AccountID, models.DefaultCurrency, res.Amount, "reservation cancelled for order 3, service 2")
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:    "account changed",
+			version: lockedVersion,
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WithArgs(res.AccountID, res.OrderID, res.ServiceID, res.Amount, "cancelled", sqlmock.AnyArg(), "reserved").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(lockAccountsQuery)).
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "version"}).AddRow(res.AccountID, lockedVersion+1))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrVersionMismatch,
+		},
+		{
+			name: "not reserved",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE reservations")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectedError: repository.ErrReservationNotFound,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			req := *res
+			req.ExpectedVersion = tt.version
+			err := r.Cancel(context.Background(), &req)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package repository
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"time"
+
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// foreignKeyViolation is the SQLSTATE of a reference to a missing row.
+const foreignKeyViolation = "23503"
+
+type scheduledTransfers struct {
+	db     *sql.DB
+	logger logger.Logger
+}
+
+func NewScheduledTransferRepository(db *sql.DB, logger logger.Logger) (repository ScheduledTransfers) {
+	return &scheduledTransfers{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const scheduleColumns = `schedule_id,
+					sender_id,
+					receiver_id,
+					amount,
+					currency,
+					comment,
+					period,
+					start_at,
+					on_insufficient_funds,
+					status,
+					next_run_at,
+					retry_at,
+					attempts,
+					created_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchedule(row scanner, schedule *models.ScheduledTransfer) error {
+	return row.Scan(
+		&schedule.ID,
+		&schedule.SenderID,
+		&schedule.ReceiverID,
+		&schedule.Amount,
+		&schedule.Currency,
+		&schedule.Comment,
+		&schedule.Period,
+		&schedule.StartAt,
+		&schedule.OnInsufficientFunds,
+		&schedule.Status,
+		&schedule.NextRunAt,
+		&schedule.RetryAt,
+		&schedule.Attempts,
+		&schedule.CreatedAt,
+	)
+}
+
+// CreateSchedule stores a new active schedule, first due at its first
+// occurrence from now on, and fills in its ID and state. Both accounts must
+// exist.
+func (rep *scheduledTransfers) CreateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) (err error) {
+	now := time.Now().UTC()
+	next := schedule.FirstRun(now)
+	schedule.StartAt = schedule.StartAt.UTC()
+	schedule.Status = models.ScheduleActive
+	schedule.NextRunAt, schedule.RetryAt, schedule.Attempts = &next, nil, 0
+	schedule.CreatedAt = now
+
+	query := `INSERT INTO scheduled_transfers (sender_id, receiver_id, amount, currency, comment, period, start_at, on_insufficient_funds, status, next_run_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING schedule_id`
+
+	err = rep.db.QueryRowContext(ctx, query,
+		schedule.SenderID,
+		schedule.ReceiverID,
+		schedule.Amount,
+		schedule.Currency,
+		schedule.Comment,
+		schedule.Period,
+		schedule.StartAt,
+		schedule.OnInsufficientFunds,
+		schedule.Status,
+		schedule.NextRunAt,
+		schedule.CreatedAt).
+		Scan(&schedule.ID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrUserDoesntExist
+		}
+		rep.logger.WithContext(ctx).Errorf("error occurred while creating scheduled transfer. err: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (rep *scheduledTransfers) GetSchedule(ctx context.Context, id int) (schedule *models.ScheduledTransfer, err error) {
+	schedule = &models.ScheduledTransfer{}
+	query := `SELECT ` + scheduleColumns + `
+			FROM scheduled_transfers
+			WHERE schedule_id = $1`
+
+	if err = scanSchedule(rep.db.QueryRowContext(ctx, query, id), schedule); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduleNotFound
+		}
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting scheduled transfer. err: %s", err)
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// ListSchedules returns the schedules sending from or to an account, or every
+// schedule when accountID is 0.
+func (rep *scheduledTransfers) ListSchedules(ctx context.Context, accountID int) (schedules []models.ScheduledTransfer, err error) {
+	query := `SELECT ` + scheduleColumns + `
+			FROM scheduled_transfers
+			WHERE $1 = 0 OR sender_id = $1 OR receiver_id = $1
+			ORDER BY schedule_id`
+
+	rows, err := rep.db.QueryContext(ctx, query, accountID)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while listing scheduled transfers. err: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules = []models.ScheduledTransfer{}
+	for rows.Next() {
+		var schedule models.ScheduledTransfer
+		if err = scanSchedule(rows, &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+// UpdateSchedule replaces the transfer and timing of an active schedule. The
+// schedule starts over: it is next due at its first occurrence from now on,
+// and a pending retry is dropped.
+func (rep *scheduledTransfers) UpdateSchedule(ctx context.Context, schedule *models.ScheduledTransfer) (err error) {
+	now := time.Now().UTC()
+	next := schedule.FirstRun(now)
+	schedule.StartAt = schedule.StartAt.UTC()
+	schedule.NextRunAt, schedule.RetryAt, schedule.Attempts = &next, nil, 0
+
+	query := `UPDATE scheduled_transfers
+			SET sender_id = $2,
+				receiver_id = $3,
+				amount = $4,
+				currency = $5,
+				comment = $6,
+				period = $7,
+				start_at = $8,
+				on_insufficient_funds = $9,
+				next_run_at = $10,
+				retry_at = NULL,
+				attempts = 0
+			WHERE schedule_id = $1 AND status = 'active'
+			RETURNING status, created_at`
+
+	err = rep.db.QueryRowContext(ctx, query,
+		schedule.ID,
+		schedule.SenderID,
+		schedule.ReceiverID,
+		schedule.Amount,
+		schedule.Currency,
+		schedule.Comment,
+		schedule.Period,
+		schedule.StartAt,
+		schedule.OnInsufficientFunds,
+		schedule.NextRunAt).
+		Scan(&schedule.Status, &schedule.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rep.notActive(ctx, schedule.ID)
+	}
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrUserDoesntExist
+		}
+		rep.logger.WithContext(ctx).Errorf("error occurred while updating scheduled transfer. err: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// CancelSchedule stops an active schedule for good. Cancelling a cancelled
+// schedule does nothing.
+func (rep *scheduledTransfers) CancelSchedule(ctx context.Context, id int) (err error) {
+	query := `UPDATE scheduled_transfers
+			SET status = 'cancelled', next_run_at = NULL, retry_at = NULL
+			WHERE schedule_id = $1 AND status = 'active'`
+
+	result, err := rep.db.ExecContext(ctx, query, id)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while cancelling scheduled transfer. err: %s", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		status, err := rep.scheduleStatus(ctx, id)
+		if err != nil {
+			return err
+		}
+		if status != models.ScheduleCancelled {
+			return ErrScheduleNotActive
+		}
+	}
+
+	return nil
+}
+
+// notActive explains why an update of a schedule changed nothing: it does not
+// exist, or it is no longer active.
+func (rep *scheduledTransfers) notActive(ctx context.Context, id int) (err error) {
+	if _, err = rep.scheduleStatus(ctx, id); err != nil {
+		return err
+	}
+	return ErrScheduleNotActive
+}
+
+func (rep *scheduledTransfers) scheduleStatus(ctx context.Context, id int) (status string, err error) {
+	query := `SELECT status FROM scheduled_transfers WHERE schedule_id = $1`
+
+	err = rep.db.QueryRowContext(ctx, query, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrScheduleNotFound
+	}
+
+	return status, err
+}
+
+// ListRuns returns the latest runs of a schedule, newest first.
+func (rep *scheduledTransfers) ListRuns(ctx context.Context, scheduleID, limit int) (runs []models.ScheduledTransferRun, err error) {
+	query := `SELECT run_id,
+					schedule_id,
+					due_at,
+					ran_at,
+					attempt,
+					amount,
+					currency,
+					status,
+					COALESCE(error, ''),
+					COALESCE(entry_id, 0)
+			FROM scheduled_transfer_runs
+			WHERE schedule_id = $1
+			ORDER BY run_id DESC
+			LIMIT $2`
+
+	rows, err := rep.db.QueryContext(ctx, query, scheduleID, limit)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while listing scheduled transfer runs. err: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs = []models.ScheduledTransferRun{}
+	for rows.Next() {
+		var run models.ScheduledTransferRun
+		if err = rows.Scan(
+			&run.ID,
+			&run.ScheduleID,
+			&run.DueAt,
+			&run.RanAt,
+			&run.Attempt,
+			&run.Amount,
+			&run.Currency,
+			&run.Status,
+			&run.Error,
+			&run.EntryID,
+		); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// no runs may also mean no schedule.
+	if len(runs) == 0 {
+		if _, err = rep.scheduleStatus(ctx, scheduleID); err != nil {
+			return nil, err
+		}
+	}
+
+	return runs, nil
+}
+
+// DueSchedules returns up to limit active schedules due at now, the longest
+// overdue first.
+func (rep *scheduledTransfers) DueSchedules(ctx context.Context, now time.Time, limit int) (ids []int, err error) {
+	query := `SELECT schedule_id
+			FROM scheduled_transfers
+			WHERE status = 'active' AND COALESCE(retry_at, next_run_at) <= $1
+			ORDER BY COALESCE(retry_at, next_run_at), schedule_id
+			LIMIT $2`
+
+	rows, err := rep.db.QueryContext(ctx, query, now.UTC(), limit)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting due scheduled transfers. err: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// scheduleFailures are the errors a run fails with for good, or until funds
+// arrive, and that are recorded as its outcome. Any other error leaves no
+// trace and the schedule due.
+var scheduleFailures = []error{
+	ErrInsufficientFunds,
+	ErrUserDoesntExist,
+	ErrAccountFrozen,
+	ErrAccountClosed,
+	ErrNoRowsAffected,
+	ErrZeroPosting,
+}
+
+func isScheduleFailure(err error) bool {
+	for _, failure := range scheduleFailures {
+		if errors.Is(err, failure) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSchedule runs the schedule if it is due at now, and returns the run, or
+// nil when the schedule is not due or being run elsewhere. The transfer is
+// posted like any other, and the run is recorded and the schedule moved on in
+// the same database transaction. A run that fails for insufficient funds is
+// retried after policy.Interval when the schedule asks for it and the policy
+// allows; otherwise a failed occurrence is skipped.
+func (rep *scheduledTransfers) RunSchedule(ctx context.Context, id int, now time.Time, policy models.RetryPolicy) (run *models.ScheduledTransferRun, err error) {
+	now = now.UTC()
+	err = inTransaction(ctx, rep.db, func(q Querier) error {
+		run = nil
+		schedule := &models.ScheduledTransfer{}
+		query := `SELECT ` + scheduleColumns + `
+				FROM scheduled_transfers
+				WHERE schedule_id = $1 AND status = 'active' AND COALESCE(retry_at, next_run_at) <= $2
+				FOR UPDATE SKIP LOCKED`
+
+		err := scanSchedule(q.QueryRowContext(ctx, query, id, now), schedule)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		run = &models.ScheduledTransferRun{
+			ScheduleID: schedule.ID,
+			DueAt:      *schedule.NextRunAt,
+			RanAt:      now,
+			Attempt:    schedule.Attempts + 1,
+			Amount:     schedule.Amount,
+			Currency:   schedule.Currency,
+		}
+		entry := transactionEntry(schedule.Transaction(), now)
+		failed, err := postInSavepoint(ctx, q, entry)
+		if err != nil {
+			return err
+		}
+		if failed != nil && !isScheduleFailure(failed) {
+			return failed
+		}
+		if failed == nil {
+			run.EntryID = entry.ID
+		}
+		advanceSchedule(schedule, run, failed, now, policy)
+
+		query = `INSERT INTO scheduled_transfer_runs (schedule_id, due_at, ran_at, attempt, amount, currency, status, error, entry_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				RETURNING run_id`
+
+		err = q.QueryRowContext(ctx, query,
+			run.ScheduleID,
+			run.DueAt,
+			run.RanAt,
+			run.Attempt,
+			run.Amount,
+			run.Currency,
+			run.Status,
+			nullString(run.Error),
+			nullID(run.EntryID)).
+			Scan(&run.ID)
+		if err != nil {
+			return err
+		}
+
+		query = `UPDATE scheduled_transfers
+				SET status = $2, next_run_at = $3, retry_at = $4, attempts = $5
+				WHERE schedule_id = $1`
+
+		_, err = q.ExecContext(ctx, query,
+			schedule.ID,
+			schedule.Status,
+			schedule.NextRunAt,
+			schedule.RetryAt,
+			schedule.Attempts)
+		return err
+	})
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while running scheduled transfer %d. err: %s", id, err)
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// advanceSchedule sets the outcome of the run and the state the schedule is
+// left in. failed is the error the transfer failed with, if any.
+func advanceSchedule(schedule *models.ScheduledTransfer, run *models.ScheduledTransferRun, failed error, now time.Time, policy models.RetryPolicy) {
+	next := schedule.NextRunAfter(now)
+	switch {
+	case failed == nil:
+		run.Status = models.RunSucceeded
+	case errors.Is(failed, ErrInsufficientFunds) &&
+		schedule.OnInsufficientFunds == models.OnInsufficientFundsRetry &&
+		run.Attempt < policy.MaxAttempts &&
+		(next == nil || now.Add(policy.Interval).Before(*next)):
+		retryAt := now.Add(policy.Interval)
+		run.Status, run.Error = models.RunRetrying, failed.Error()
+		schedule.RetryAt, schedule.Attempts = &retryAt, run.Attempt
+		return
+	default:
+		run.Status, run.Error = models.RunSkipped, failed.Error()
+	}
+
+	schedule.NextRunAt, schedule.RetryAt, schedule.Attempts = next, nil, 0
+	if next == nil {
+		schedule.Status = models.ScheduleFinished
+	}
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,340 @@
+package repository_test
+
+import (
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var scheduleColumns = []string{"schedule_id", "sender_id", "receiver_id", "amount", "currency", "comment", "period", "start_at",
+	"on_insufficient_funds", "status", "next_run_at", "retry_at", "attempts", "created_at"}
+
+func Test_CreateSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewScheduledTransferRepository(db, log)
+
+	insertQuery := regexp.QuoteMeta("INSERT INTO scheduled_transfers (sender_id, receiver_id, amount, currency, comment, period, start_at, on_insufficient_funds, status, next_run_at, created_at)")
+	start := time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs(3, 7, models.Money(5000000), "RUB", "monthly rent", "monthly", start, "skip", "active", start, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"schedule_id"}).AddRow(1))
+			},
+		},
+		{
+			name: "no such account",
+			mock: func() {
+				mock.ExpectQuery(insertQuery).WillReturnError(&pq.Error{Code: "23503"})
+			},
+			expectedError: repository.ErrUserDoesntExist,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.mock()
+
+			schedule := &models.ScheduledTransfer{SenderID: 3, ReceiverID: 7, Amount: 5000000, Currency: "RUB", Comment: "monthly rent",
+				Period: models.PeriodMonthly, StartAt: start, OnInsufficientFunds: models.OnInsufficientFundsSkip}
+			err := r.CreateSchedule(context.Background(), schedule)
+			assert.Equal(t, testCase.expectedError, err)
+			if err == nil {
+				assert.Equal(t, 1, schedule.ID)
+				assert.Equal(t, models.ScheduleActive, schedule.Status)
+				assert.Equal(t, &start, schedule.NextRunAt)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_CancelSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewScheduledTransferRepository(db, log)
+
+	cancelQuery := regexp.QuoteMeta("UPDATE scheduled_transfers SET status = 'cancelled', next_run_at = NULL, retry_at = NULL WHERE schedule_id = $1 AND status = 'active'")
+	expectStatus := func(status string) {
+		rows := sqlmock.NewRows([]string{"status"})
+		if status != "" {
+			rows.AddRow(status)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT status FROM scheduled_transfers WHERE schedule_id = $1")).WithArgs(1).WillReturnRows(rows)
+	}
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedError error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				mock.ExpectExec(cancelQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "already cancelled",
+			mock: func() {
+				mock.ExpectExec(cancelQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectStatus(models.ScheduleCancelled)
+			},
+		},
+		{
+			name: "finished",
+			mock: func() {
+				mock.ExpectExec(cancelQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectStatus(models.ScheduleFinished)
+			},
+			expectedError: repository.ErrScheduleNotActive,
+		},
+		{
+			name: "not found",
+			mock: func() {
+				mock.ExpectExec(cancelQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectStatus("")
+			},
+			expectedError: repository.ErrScheduleNotFound,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.mock()
+
+			err := r.CancelSchedule(context.Background(), 1)
+			assert.Equal(t, testCase.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_ListRuns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewScheduledTransferRepository(db, log)
+
+	runsQuery := regexp.QuoteMeta("FROM scheduled_transfer_runs WHERE schedule_id = $1 ORDER BY run_id DESC LIMIT $2")
+	runColumns := []string{"run_id", "schedule_id", "due_at", "ran_at", "attempt", "amount", "currency", "status", "error", "entry_id"}
+	due := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("OK", func(t *testing.T) {
+		mock.ExpectQuery(runsQuery).WithArgs(1, 50).WillReturnRows(sqlmock.NewRows(runColumns).
+			AddRow(2, 1, due, due.Add(time.Hour), 2, "500.00", "RUB", "succeeded", "", 9).
+			AddRow(1, 1, due, due, 1, "500.00", "RUB", "retrying", "insufficient funds", 0))
+
+		runs, err := r.ListRuns(context.Background(), 1, 50)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.ScheduledTransferRun{
+			{ID: 2, ScheduleID: 1, DueAt: due, RanAt: due.Add(time.Hour), Attempt: 2, Amount: 5000000, Currency: "RUB", Status: "succeeded", EntryID: 9},
+			{ID: 1, ScheduleID: 1, DueAt: due, RanAt: due, Attempt: 1, Amount: 5000000, Currency: "RUB", Status: "retrying", Error: "insufficient funds"},
+		}, runs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no schedule", func(t *testing.T) {
+		mock.ExpectQuery(runsQuery).WithArgs(1, 50).WillReturnRows(sqlmock.NewRows(runColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT status FROM scheduled_transfers WHERE schedule_id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}))
+
+		_, err := r.ListRuns(context.Background(), 1, 50)
+		assert.Equal(t, repository.ErrScheduleNotFound, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_RunSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewScheduledTransferRepository(db, log)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	due := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	nextMonth := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	now := due.Add(time.Minute)
+	retryAt := now.Add(25 * time.Hour)
+	policy := models.RetryPolicy{Interval: 25 * time.Hour, MaxAttempts: 3}
+
+	lockQuery := regexp.QuoteMeta("FROM scheduled_transfers WHERE schedule_id = $1 AND status = 'active' AND COALESCE(retry_at, next_run_at) <= $2 FOR UPDATE SKIP LOCKED")
+	expectSchedule := func(period, onInsufficientFunds string, attempts int) {
+		mock.ExpectQuery(lockQuery).WithArgs(1, now).WillReturnRows(sqlmock.NewRows(scheduleColumns).
+			AddRow(1, 3, 7, "500.00", "RUB", "monthly rent", period, start, onInsufficientFunds, "active", due, nil, attempts, start))
+	}
+	savepoint := func(statement string) {
+		mock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	failForFunds := func() {
+		steps := transferSteps(mock, 7, 3, 7, "RUB", 5000000, "monthly rent")
+		steps[0](false)
+		steps[1](false)
+		expectLockBalance(mock, 3, "RUB", "1.00")
+	}
+	expectRun := func(attempt int, status string, runErr, entryID interface{}) {
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO scheduled_transfer_runs (schedule_id, due_at, ran_at, attempt, amount, currency, status, error, entry_id)")).
+			WithArgs(1, due, now, attempt, models.Money(5000000), "RUB", status, runErr, entryID).
+			WillReturnRows(sqlmock.NewRows([]string{"run_id"}).AddRow(11))
+	}
+	expectUpdate := func(status string, next, retry interface{}, attempts int) {
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE scheduled_transfers SET status = $2, next_run_at = $3, retry_at = $4, attempts = $5 WHERE schedule_id = $1")).
+			WithArgs(1, status, next, retry, attempts).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	fundsErr := "insufficient funds: account 3 has 1.00 RUB, requested 500.00"
+
+	testTable := []struct {
+		name          string
+		mock          func()
+		expectedRun   *models.ScheduledTransferRun
+		expectedError error
+	}{
+		{
+			name: "not due",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1, now).WillReturnRows(sqlmock.NewRows(scheduleColumns))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "succeeded",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodMonthly, models.OnInsufficientFundsSkip, 0)
+				savepoint("SAVEPOINT batch_item")
+				expectTransfer(mock, 7, 3, 7, "RUB", 5000000, "monthly rent")
+				savepoint("RELEASE SAVEPOINT batch_item")
+				expectRun(1, "succeeded", nil, 7)
+				expectUpdate("active", nextMonth, nil, 0)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 1,
+				Amount: 5000000, Currency: "RUB", Status: models.RunSucceeded, EntryID: 7},
+		},
+		{
+			name: "once succeeded",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodOnce, models.OnInsufficientFundsSkip, 0)
+				savepoint("SAVEPOINT batch_item")
+				expectTransfer(mock, 7, 3, 7, "RUB", 5000000, "monthly rent")
+				savepoint("RELEASE SAVEPOINT batch_item")
+				expectRun(1, "succeeded", nil, 7)
+				expectUpdate("finished", nil, nil, 0)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 1,
+				Amount: 5000000, Currency: "RUB", Status: models.RunSucceeded, EntryID: 7},
+		},
+		{
+			name: "insufficient funds skipped",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodMonthly, models.OnInsufficientFundsSkip, 0)
+				savepoint("SAVEPOINT batch_item")
+				failForFunds()
+				savepoint("ROLLBACK TO SAVEPOINT batch_item")
+				expectRun(1, "skipped", fundsErr, nil)
+				expectUpdate("active", nextMonth, nil, 0)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 1,
+				Amount: 5000000, Currency: "RUB", Status: models.RunSkipped, Error: fundsErr},
+		},
+		{
+			name: "insufficient funds retried",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodMonthly, models.OnInsufficientFundsRetry, 1)
+				savepoint("SAVEPOINT batch_item")
+				failForFunds()
+				savepoint("ROLLBACK TO SAVEPOINT batch_item")
+				expectRun(2, "retrying", fundsErr, nil)
+				expectUpdate("active", due, retryAt, 2)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 2,
+				Amount: 5000000, Currency: "RUB", Status: models.RunRetrying, Error: fundsErr},
+		},
+		{
+			name: "retries exhausted",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodMonthly, models.OnInsufficientFundsRetry, 2)
+				savepoint("SAVEPOINT batch_item")
+				failForFunds()
+				savepoint("ROLLBACK TO SAVEPOINT batch_item")
+				expectRun(3, "skipped", fundsErr, nil)
+				expectUpdate("active", nextMonth, nil, 0)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 3,
+				Amount: 5000000, Currency: "RUB", Status: models.RunSkipped, Error: fundsErr},
+		},
+		{
+			name: "no retry past the next occurrence",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1, now).WillReturnRows(sqlmock.NewRows(scheduleColumns).
+					AddRow(1, 3, 7, "500.00", "RUB", "monthly rent", models.PeriodDaily, start, "retry", "active", due, nil, 0, start))
+				savepoint("SAVEPOINT batch_item")
+				failForFunds()
+				savepoint("ROLLBACK TO SAVEPOINT batch_item")
+				expectRun(1, "skipped", fundsErr, nil)
+				expectUpdate("active", due.AddDate(0, 0, 1), nil, 0)
+				mock.ExpectCommit()
+			},
+			expectedRun: &models.ScheduledTransferRun{ID: 11, ScheduleID: 1, DueAt: due, RanAt: now, Attempt: 1,
+				Amount: 5000000, Currency: "RUB", Status: models.RunSkipped, Error: fundsErr},
+		},
+		{
+			name: "transfer fails unexpectedly",
+			mock: func() {
+				mock.ExpectBegin()
+				expectSchedule(models.PeriodMonthly, models.OnInsufficientFundsSkip, 0)
+				savepoint("SAVEPOINT batch_item")
+				transferSteps(mock, 7, 3, 7, "RUB", 5000000, "monthly rent")[0](true)
+				savepoint("ROLLBACK TO SAVEPOINT batch_item")
+				mock.ExpectRollback()
+			},
+			expectedError: errStep,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.mock()
+
+			run, err := r.RunSchedule(context.Background(), 1, now, policy)
+			assert.Equal(t, testCase.expectedError, err)
+			assert.Equal(t, testCase.expectedRun, run)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -3,7 +3,9 @@ package repository
 import (
 	"avito-tech/internal/models"
 	"avito-tech/pkg/logger"
+	"context"
 	"fmt"
+	"strings"
 
 	"database/sql"
 )
@@ -20,7 +22,7 @@ func NewTransactionRepository(db *sql.DB, logger logger.Logger) (repository Tran
 	}
 }
 
-func (rep *transactionHistory) GetByAccountID(req *models.TransactionHistoryReq) (transactions []models.TransactionHistory, err error) {
+func (rep *transactionHistory) GetByAccountID(ctx context.Context, req *models.TransactionHistoryReq) (transactions []models.TransactionHistory, err error) {
 
 	var rows *sql.Rows
 
@@ -28,21 +30,34 @@ func (rep *transactionHistory) GetByAccountID(req *models.TransactionHistoryReq)
 					account_id,
 					amount,
 					date_time,
-					COMMENT
+					COMMENT,
+					COALESCE(entry_id, 0),
+					COALESCE(counterparty_id, 0),
+					currency,
+					COALESCE(rate, 0),
+					COALESCE(refund_of, 0)
 			FROM transactions
 			WHERE account_id = $1`
 
+	// without OrderBy rows come in transaction_id order, i.e. oldest first.
+	var sort models.Sort
 	if req.OrderBy != "" {
-		query += fmt.Sprintf(" ORDER BY %s", req.OrderBy)
+		sort, err = models.ParseSort(req.OrderBy)
+		if err != nil {
+			return nil, err
+		}
 	}
+	query += " ORDER BY " + orderBy(sort.Total())
 
+	args := []interface{}{req.AccountID}
 	if req.Limit != 0 {
-		query += fmt.Sprintf(" LIMIT %d OFFSET %d", req.Limit, req.Offset)
+		query += " LIMIT $2 OFFSET $3"
+		args = append(args, req.Limit, req.Offset)
 	}
 
-	rows, err = rep.db.Query(query, req.AccountID)
+	rows, err = rep.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		rep.logger.Errorf("error occurred while getting transaction history. err: %s", err)
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting transaction history. err: %s", err)
 		return nil, err
 	}
 
@@ -55,8 +70,13 @@ func (rep *transactionHistory) GetByAccountID(req *models.TransactionHistoryReq)
 			&tr.Amount,
 			&tr.Date,
 			&tr.Comment,
+			&tr.EntryID,
+			&tr.CounterpartyID,
+			&tr.Currency,
+			&tr.Rate,
+			&tr.RefundOf,
 		); err != nil {
-			rep.logger.Errorf("error occurred while getting transaction history. err: %s", err)
+			rep.logger.WithContext(ctx).Errorf("error occurred while getting transaction history. err: %s", err)
 			return nil, err
 		}
 
@@ -66,3 +86,171 @@ func (rep *transactionHistory) GetByAccountID(req *models.TransactionHistoryReq)
 	return transactions, nil
 
 }
+
+// GetPageByAccountID returns a page of the account history in req.Sort
+// order, using keyset pagination so that deep pages cost the same as the
+// first one.
+func (rep *transactionHistory) GetPageByAccountID(ctx context.Context, req *models.TransactionHistoryPageReq) (page *models.TransactionHistoryPage, err error) {
+	var (
+		conditions = []string{"account_id = $1"}
+		args       = []interface{}{req.AccountID}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if req.From != nil {
+		conditions = append(conditions, "date_time >= "+arg(*req.From))
+	}
+	if req.To != nil {
+		conditions = append(conditions, "date_time < "+arg(*req.To))
+	}
+	if req.MinAmount != nil {
+		conditions = append(conditions, "abs(amount) >= "+arg(*req.MinAmount))
+	}
+	if req.MaxAmount != nil {
+		conditions = append(conditions, "abs(amount) <= "+arg(*req.MaxAmount))
+	}
+	switch req.Direction {
+	case models.DirectionCredit:
+		conditions = append(conditions, "amount > 0")
+	case models.DirectionDebit:
+		conditions = append(conditions, "amount < 0")
+	}
+
+	// a page before a cursor is read in reverse order from the cursor and
+	// flipped back, so both directions can stop after limit rows.
+	sort := req.Sort.Total()
+	if req.After != nil {
+		conditions = append(conditions, seek(sort, req.After, arg))
+	}
+	if req.Before != nil {
+		sort = sort.Reverse()
+		conditions = append(conditions, seek(sort, req.Before, arg))
+	}
+
+	// one row more than asked tells whether there is another page.
+	query := fmt.Sprintf(`SELECT transaction_id,
+					account_id,
+					amount,
+					date_time,
+					COMMENT,
+					COALESCE(entry_id, 0),
+					COALESCE(counterparty_id, 0),
+					currency,
+					COALESCE(rate, 0),
+					COALESCE(refund_of, 0)
+			FROM transactions
+			WHERE %s
+			ORDER BY %s
+			LIMIT %d`, strings.Join(conditions, " AND "), orderBy(sort), req.Limit+1)
+
+	rows, err := rep.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		rep.logger.WithContext(ctx).Errorf("error occurred while getting transaction history page. err: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []models.TransactionHistory{}
+	for rows.Next() {
+		tr := models.TransactionHistory{}
+		if err = rows.Scan(
+			&tr.TransactionID,
+			&tr.AccountID,
+			&tr.Amount,
+			&tr.Date,
+			&tr.Comment,
+			&tr.EntryID,
+			&tr.CounterpartyID,
+			&tr.Currency,
+			&tr.Rate,
+			&tr.RefundOf,
+		); err != nil {
+			rep.logger.WithContext(ctx).Errorf("error occurred while getting transaction history page. err: %s", err)
+			return nil, err
+		}
+
+		transactions = append(transactions, tr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(transactions) > req.Limit
+	if more {
+		transactions = transactions[:req.Limit]
+	}
+	if req.Before != nil {
+		for i, j := 0, len(transactions)-1; i < j; i, j = i+1, j-1 {
+			transactions[i], transactions[j] = transactions[j], transactions[i]
+		}
+	}
+
+	page = &models.TransactionHistoryPage{Transactions: transactions}
+	if len(transactions) == 0 {
+		return page, nil
+	}
+
+	// reading before a cursor, older rows are known to exist and "more"
+	// means newer ones; reading forward it is the other way round.
+	hasOlder := req.Before != nil || more
+	hasNewer := req.After != nil || req.Before != nil && more
+	if hasOlder {
+		page.NextCursor = models.CursorOf(&transactions[len(transactions)-1], req.Sort).String()
+	}
+	if hasNewer {
+		page.PrevCursor = models.CursorOf(&transactions[0], req.Sort).String()
+	}
+
+	return page, nil
+}
+
+func orderBy(sort models.Sort) string {
+	keys := make([]string, len(sort))
+	for i, key := range sort {
+		keys[i] = key.Column + " ASC"
+		if key.Desc {
+			keys[i] = key.Column + " DESC"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// seek returns the condition selecting rows that come after the cursor in
+// sort, which must end with the unique tiebreaker. When every key runs the
+// same way it is a single row comparison, which an index can serve.
+func seek(sort models.Sort, c *models.HistoryCursor, arg func(v interface{}) string) string {
+	op := func(key models.SortKey) string {
+		if key.Desc {
+			return "<"
+		}
+		return ">"
+	}
+
+	columns := make([]string, len(sort))
+	values := make([]string, len(sort))
+	uniform := true
+	for i, key := range sort {
+		columns[i] = key.Column
+		values[i] = arg(c.Value(key.Column))
+		uniform = uniform && key.Desc == sort[0].Desc
+	}
+	if uniform {
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op(sort[0]), strings.Join(values, ", "))
+	}
+
+	// a row comes later when it ties on every key before the first one on
+	// which it differs, and is on the right side of that one.
+	alternatives := make([]string, len(sort))
+	for i, key := range sort {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", columns[j], values[j]))
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", key.Column, op(key), values[i]))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
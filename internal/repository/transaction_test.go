@@ -3,6 +3,7 @@ package repository_test
 import (
 	"avito-tech/internal/models"
 	"avito-tech/internal/repository"
+	"context"
 	"errors"
 	"regexp"
 	"testing"
@@ -37,21 +38,40 @@ func Test_GetByAccountID(t *testing.T) {
 				OrderBy:   "",
 			},
 			mock: func(req *models.TransactionHistoryReq) {
-				rows := sqlmock.NewRows([]string{"transaction_id", "account_id", "amount", "date_time", "comment"}).
-					AddRow(1, 2, 11.2, date, "salary")
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT transaction_id ,account_id , amount , date_time ,comment FROM transactions WHERE account_id = $1")).WithArgs(req.AccountID).WillReturnRows(rows)
+				rows := sqlmock.NewRows([]string{"transaction_id", "account_id", "amount", "date_time", "comment", "entry_id", "counterparty_id", "currency", "rate", "refund_of"}).
+					AddRow(1, 2, "11.20", date, "salary", 5, 3, "USD", 0.016, 0)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT transaction_id, account_id, amount, date_time, COMMENT, COALESCE(entry_id, 0), COALESCE(counterparty_id, 0), currency, COALESCE(rate, 0), COALESCE(refund_of, 0) FROM transactions WHERE account_id = $1")).WithArgs(req.AccountID).WillReturnRows(rows)
 			},
 			expectedResult: []models.TransactionHistory{
 				{
-					TransactionID: 1,
-					AccountID:     2,
-					Amount:        11.2,
-					Date:          date,
-					Comment:       "salary",
+					TransactionID:  1,
+					AccountID:      2,
+					Amount:         112000,
+					Date:           date,
+					Comment:        "salary",
+					EntryID:        5,
+					CounterpartyID: 3,
+					Currency:       "USD",
+					Rate:           0.016,
 				},
 			},
 			expectedError: false,
 		},
+		{
+			name: "sorted with a tiebreaker",
+			req: &models.TransactionHistoryReq{
+				AccountID: 1,
+				Limit:     10,
+				Offset:    20,
+				OrderBy:   "-date_time,amount",
+			},
+			mock: func(req *models.TransactionHistoryReq) {
+				rows := sqlmock.NewRows([]string{"transaction_id", "account_id", "amount", "date_time", "comment", "entry_id", "counterparty_id", "currency", "rate", "refund_of"})
+				mock.ExpectQuery(regexp.QuoteMeta("FROM transactions WHERE account_id = $1 ORDER BY date_time DESC, amount ASC, transaction_id ASC LIMIT $2 OFFSET $3")).WithArgs(req.AccountID, req.Limit, req.Offset).WillReturnRows(rows)
+			},
+			expectedResult: nil,
+			expectedError:  false,
+		},
 		{
 			name: "no rows",
 			req: &models.TransactionHistoryReq{
@@ -60,7 +80,7 @@ func Test_GetByAccountID(t *testing.T) {
 				Offset:    0,
 				OrderBy:   "amount",
 			}, mock: func(req *models.TransactionHistoryReq) {
-				mock.ExpectQuery(regexp.QuoteMeta("SELECT transaction_id ,account_id , amount , date_time ,comment FROM transactions WHERE account_id = $1")).WithArgs(req.AccountID).WillReturnError(errors.New("no rows"))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT transaction_id, account_id, amount, date_time, COMMENT, COALESCE(entry_id, 0), COALESCE(counterparty_id, 0), currency, COALESCE(rate, 0), COALESCE(refund_of, 0) FROM transactions WHERE account_id = $1")).WithArgs(req.AccountID).WillReturnError(errors.New("no rows"))
 			},
 
 			expectedResult: nil,
@@ -70,7 +90,127 @@ func Test_GetByAccountID(t *testing.T) {
 	for _, tt := range testTable {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock(tt.req)
-			result, err := r.GetByAccountID(tt.req)
+			result, err := r.GetByAccountID(context.Background(), tt.req)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, result)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_GetPageByAccountID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	r := repository.NewTransactionRepository(db, log)
+
+	date := time.Date(2022, 03, 11, 0, 0, 0, 0, time.UTC)
+	columns := []string{"transaction_id", "account_id", "amount", "date_time", "comment", "entry_id", "counterparty_id", "currency", "rate", "refund_of"}
+	row := func(id int) models.TransactionHistory {
+		return models.TransactionHistory{TransactionID: id, AccountID: 1, Amount: -10000, Date: date, Comment: "coffee", Currency: "RUB"}
+	}
+	cursorAt := func(id int, sort models.Sort) string {
+		tr := row(id)
+		return models.CursorOf(&tr, sort).String()
+	}
+	rowsOf := func(ids ...int) *sqlmock.Rows {
+		rows := sqlmock.NewRows(columns)
+		for _, id := range ids {
+			rows.AddRow(id, 1, "-1.00", date, "coffee", 0, 0, "RUB", 0, 0)
+		}
+		return rows
+	}
+	cursor := &models.HistoryCursor{Sort: "-date_time", Date: date, Amount: -10000, TransactionID: 5}
+	from := date.AddDate(0, -1, 0)
+	min, max := models.Money(10000), models.Money(1000000)
+	selectQuery := "SELECT transaction_id, account_id, amount, date_time, COMMENT, COALESCE(entry_id, 0), COALESCE(counterparty_id, 0), currency, COALESCE(rate, 0), COALESCE(refund_of, 0) FROM transactions "
+
+	testTable := []struct {
+		name           string
+		req            *models.TransactionHistoryPageReq
+		mock           func()
+		expectedResult *models.TransactionHistoryPage
+		expectedError  bool
+	}{
+		{
+			name: "first page with more",
+			req:  &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 2},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery + "WHERE account_id = $1 ORDER BY date_time DESC, transaction_id DESC LIMIT 3")).
+					WithArgs(1).WillReturnRows(rowsOf(9, 8, 7))
+			},
+			expectedResult: &models.TransactionHistoryPage{
+				Transactions: []models.TransactionHistory{row(9), row(8)},
+				NextCursor:   cursorAt(8, models.DefaultHistorySort),
+			},
+		},
+		{
+			name: "last page after a cursor",
+			req:  &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 2, After: cursor},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery+"WHERE account_id = $1 AND (date_time, transaction_id) < ($2, $3) ORDER BY date_time DESC, transaction_id DESC LIMIT 3")).
+					WithArgs(1, date, 5).WillReturnRows(rowsOf(4))
+			},
+			expectedResult: &models.TransactionHistoryPage{
+				Transactions: []models.TransactionHistory{row(4)},
+				PrevCursor:   cursorAt(4, models.DefaultHistorySort),
+			},
+		},
+		{
+			name: "page before a cursor is read backwards",
+			req:  &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 2, Before: cursor},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery+"WHERE account_id = $1 AND (date_time, transaction_id) > ($2, $3) ORDER BY date_time ASC, transaction_id ASC LIMIT 3")).
+					WithArgs(1, date, 5).WillReturnRows(rowsOf(6, 7, 8))
+			},
+			expectedResult: &models.TransactionHistoryPage{
+				Transactions: []models.TransactionHistory{row(7), row(6)},
+				NextCursor:   cursorAt(6, models.DefaultHistorySort),
+				PrevCursor:   cursorAt(7, models.DefaultHistorySort),
+			},
+		},
+		{
+			name: "mixed directions after a cursor",
+			req: &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.Sort{{Column: "amount"}, {Column: "date_time", Desc: true}}, Limit: 2,
+				After: &models.HistoryCursor{Sort: "amount,-date_time", Date: date, Amount: -10000, TransactionID: 5}},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery+"WHERE account_id = $1 AND ((amount > $2) OR (amount = $2 AND date_time < $3) OR (amount = $2 AND date_time = $3 AND transaction_id < $4)) ORDER BY amount ASC, date_time DESC, transaction_id DESC LIMIT 3")).
+					WithArgs(1, models.Money(-10000), date, 5).WillReturnRows(rowsOf(4))
+			},
+			expectedResult: &models.TransactionHistoryPage{
+				Transactions: []models.TransactionHistory{row(4)},
+				PrevCursor:   cursorAt(4, models.Sort{{Column: "amount"}, {Column: "date_time", Desc: true}}),
+			},
+		},
+		{
+			name: "filters",
+			req:  &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 10, From: &from, To: &date, MinAmount: &min, MaxAmount: &max, Direction: models.DirectionDebit},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery+"WHERE account_id = $1 AND date_time >= $2 AND date_time < $3 AND abs(amount) >= $4 AND abs(amount) <= $5 AND amount < 0 ORDER BY date_time DESC, transaction_id DESC LIMIT 11")).
+					WithArgs(1, from, date, min, max).WillReturnRows(rowsOf())
+			},
+			expectedResult: &models.TransactionHistoryPage{Transactions: []models.TransactionHistory{}},
+		},
+		{
+			name: "query fails",
+			req:  &models.TransactionHistoryPageReq{AccountID: 1, Sort: models.DefaultHistorySort, Limit: 10, Direction: models.DirectionCredit},
+			mock: func() {
+				mock.ExpectQuery(regexp.QuoteMeta(selectQuery + "WHERE account_id = $1 AND amount > 0 ORDER BY")).
+					WillReturnError(errors.New("connection reset"))
+			},
+			expectedError: true,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			result, err := r.GetPageByAccountID(context.Background(), tt.req)
 			if tt.expectedError {
 				assert.Error(t, err)
 			} else {
@@ -0,0 +1,106 @@
+// Package scheduler runs due scheduled transfers in the background.
+package scheduler
+
+import (
+	"avito-tech/internal/config"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository"
+	"avito-tech/pkg/logger"
+	"context"
+	"time"
+)
+
+type Scheduler struct {
+	logger    logger.Logger
+	schedules repository.ScheduledTransfers
+	config    config.Scheduler
+	now       func() time.Time
+}
+
+func New(logger logger.Logger, schedules repository.ScheduledTransfers, config config.Scheduler) *Scheduler {
+	return &Scheduler{
+		logger:    logger,
+		schedules: schedules,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+// Run runs the due schedules right away and then every interval, until ctx
+// is cancelled. A run in progress then is finished, not abandoned, so once
+// Run returns no transfer is in flight.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Infof("scheduler started, running due transfers every %s", s.config.Interval)
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info("scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs the schedules due now, a batch at a time, until none is left
+// or ctx is cancelled, and returns how many it ran. A schedule whose run
+// fails for other than business reasons is left due for the next tick.
+func (s *Scheduler) RunDue(ctx context.Context) (ran int) {
+	for ctx.Err() == nil {
+		ids, err := s.schedules.DueSchedules(ctx, s.now(), s.config.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Errorf("error occurred while getting due scheduled transfers. err: %s", err)
+			}
+			return ran
+		}
+
+		batchRan := 0
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return ran
+			}
+			if s.run(id) {
+				batchRan++
+			}
+		}
+		ran += batchRan
+
+		// a full batch may have more behind it, unless none of it could
+		// be run and the same schedules would come back.
+		if len(ids) < s.config.BatchSize || batchRan == 0 {
+			return ran
+		}
+	}
+	return ran
+}
+
+// run runs one schedule and reports whether it ran. It does not use the
+// context of Run, so that stopping the scheduler does not cut a transfer
+// short.
+func (s *Scheduler) run(id int) bool {
+	ctx, cancel := context.WithTimeout(logger.NewContext(context.Background(), logger.Fields{"schedule_id": id}), s.config.RunTimeout)
+	defer cancel()
+	log := s.logger.WithContext(ctx)
+
+	run, err := s.schedules.RunSchedule(ctx, id, s.now(), models.RetryPolicy{
+		Interval:    s.config.RetryInterval,
+		MaxAttempts: s.config.MaxAttempts,
+	})
+	if err != nil || run == nil {
+		// errors are logged by the repository.
+		return false
+	}
+
+	switch run.Status {
+	case models.RunSucceeded:
+		log.Infof("scheduled transfer of %s %s succeeded", run.Amount, run.Currency)
+	default:
+		log.Warnf("scheduled transfer of %s %s %s, attempt %d. err: %s", run.Amount, run.Currency, run.Status, run.Attempt, run.Error)
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package scheduler_test
+
+import (
+	"avito-tech/internal/config"
+	"avito-tech/internal/models"
+	"avito-tech/internal/repository/mock_repository"
+	"avito-tech/internal/scheduler"
+	"avito-tech/pkg/logger"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var log = logger.GetLogger()
+
+var schedulerConfig = config.Scheduler{
+	Enabled:       true,
+	Interval:      time.Hour,
+	BatchSize:     2,
+	RunTimeout:    time.Second,
+	RetryInterval: time.Hour,
+	MaxAttempts:   3,
+}
+
+var policy = models.RetryPolicy{Interval: time.Hour, MaxAttempts: 3}
+
+func Test_RunDue(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	schedules := mock_repository.NewMockScheduledTransfers(c)
+	succeeded := &models.ScheduledTransferRun{ScheduleID: 1, Status: models.RunSucceeded}
+	gomock.InOrder(
+		schedules.EXPECT().DueSchedules(gomock.Any(), gomock.Any(), 2).Return([]int{1, 2}, nil),
+		schedules.EXPECT().RunSchedule(gomock.Any(), 1, gomock.Any(), policy).Return(succeeded, nil),
+		// run by another instance in the meantime.
+		schedules.EXPECT().RunSchedule(gomock.Any(), 2, gomock.Any(), policy).Return(nil, nil),
+		schedules.EXPECT().DueSchedules(gomock.Any(), gomock.Any(), 2).Return([]int{3}, nil),
+		schedules.EXPECT().RunSchedule(gomock.Any(), 3, gomock.Any(), policy).Return(nil, errors.New("connection refused")),
+	)
+
+	s := scheduler.New(log, schedules, schedulerConfig)
+	assert.Equal(t, 1, s.RunDue(context.Background()))
+}
+
+func Test_RunDue_noProgress(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	schedules := mock_repository.NewMockScheduledTransfers(c)
+	schedules.EXPECT().DueSchedules(gomock.Any(), gomock.Any(), 2).Return([]int{1, 2}, nil)
+	schedules.EXPECT().RunSchedule(gomock.Any(), gomock.Any(), gomock.Any(), policy).Return(nil, errors.New("connection refused")).Times(2)
+
+	s := scheduler.New(log, schedules, schedulerConfig)
+	assert.Equal(t, 0, s.RunDue(context.Background()))
+}
+
+func Test_Run(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	ctx, stop := context.WithCancel(context.Background())
+	schedules := mock_repository.NewMockScheduledTransfers(c)
+	schedules.EXPECT().DueSchedules(gomock.Any(), gomock.Any(), 2).Return([]int{1}, nil)
+	schedules.EXPECT().RunSchedule(gomock.Any(), 1, gomock.Any(), policy).
+		DoAndReturn(func(runCtx context.Context, id int, now time.Time, policy models.RetryPolicy) (*models.ScheduledTransferRun, error) {
+			// stopping the scheduler lets the run in progress finish.
+			stop()
+			assert.NoError(t, runCtx.Err())
+			assert.Equal(t, logger.Fields{"schedule_id": 1}, logger.FieldsFromContext(runCtx))
+			return &models.ScheduledTransferRun{ScheduleID: 1, Status: models.RunSucceeded}, nil
+		})
+
+	done := make(chan struct{})
+	go func() {
+		scheduler.New(log, schedules, schedulerConfig).Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE revenue;
+DROP TABLE reservations;
+DROP TABLE transactions;
+DROP TABLE accounts;
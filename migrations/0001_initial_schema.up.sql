@@ -0,0 +1,38 @@
+-- Tables as they were before migrations were versioned. IF NOT EXISTS lets
+-- databases created from the old init.sql adopt this migration.
+CREATE TABLE IF NOT EXISTS accounts (
+  account_id serial PRIMARY KEY,
+  balance real NOT NULL
+  CONSTRAINT balance_cannot_be_negative CHECK (balance >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+  transaction_id serial PRIMARY KEY,
+  account_id integer REFERENCES accounts (account_id) NOT NULL,
+  amount real NOT NULL,
+  date_time timestamp NOT NULL,
+  comment text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reservations (
+  reservation_id serial PRIMARY KEY,
+  account_id integer REFERENCES accounts (account_id) NOT NULL,
+  order_id integer NOT NULL,
+  service_id integer NOT NULL,
+  amount real NOT NULL
+  CONSTRAINT reservation_amount_must_be_positive CHECK (amount > 0),
+  status text NOT NULL DEFAULT 'reserved'
+  CONSTRAINT reservation_status_is_known CHECK (status IN ('reserved', 'confirmed', 'cancelled')),
+  created_at timestamp NOT NULL,
+  updated_at timestamp NOT NULL,
+  CONSTRAINT reservation_is_unique UNIQUE (account_id, order_id, service_id)
+);
+
+CREATE TABLE IF NOT EXISTS revenue (
+  revenue_id serial PRIMARY KEY,
+  account_id integer REFERENCES accounts (account_id) NOT NULL,
+  order_id integer NOT NULL,
+  service_id integer NOT NULL,
+  amount real NOT NULL,
+  date_time timestamp NOT NULL
+);
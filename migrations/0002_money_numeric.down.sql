@@ -0,0 +1,11 @@
+ALTER TABLE accounts
+  ALTER COLUMN balance TYPE real;
+
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE real;
+
+ALTER TABLE reservations
+  ALTER COLUMN amount TYPE real;
+
+ALTER TABLE revenue
+  ALTER COLUMN amount TYPE real;
@@ -0,0 +1,13 @@
+-- Converts money columns from real to exact numeric(20,2).
+-- Existing values are rounded to whole kopecks.
+ALTER TABLE accounts
+  ALTER COLUMN balance TYPE numeric(20,2) USING round(balance::numeric, 2);
+
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount::numeric, 2);
+
+ALTER TABLE reservations
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount::numeric, 2);
+
+ALTER TABLE revenue
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount::numeric, 2);
@@ -0,0 +1,9 @@
+-- Stores responses of POST requests sent with an Idempotency-Key header.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  idempotency_key text PRIMARY KEY,
+  request_hash text NOT NULL,
+  status_code integer,
+  response bytea,
+  created_at timestamp NOT NULL,
+  expires_at timestamp NOT NULL
+);
@@ -0,0 +1,15 @@
+DROP TRIGGER entry_is_balanced ON postings;
+DROP FUNCTION check_entry_is_balanced();
+
+ALTER TABLE transactions
+  DROP COLUMN entry_id,
+  DROP COLUMN counterparty_id;
+
+DROP TABLE postings;
+DROP TABLE journal_entries;
+
+DELETE FROM accounts WHERE account_id < 0;
+
+ALTER TABLE accounts DROP CONSTRAINT balance_cannot_be_negative;
+ALTER TABLE accounts
+  ADD CONSTRAINT balance_cannot_be_negative CHECK (balance >= 0);
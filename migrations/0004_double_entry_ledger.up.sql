@@ -0,0 +1,64 @@
+-- Adds the double-entry ledger: journal entries with postings that sum to
+-- zero, system accounts and counterparty links in the transaction history.
+ALTER TABLE accounts DROP CONSTRAINT balance_cannot_be_negative;
+ALTER TABLE accounts
+  ADD CONSTRAINT balance_cannot_be_negative CHECK (balance >= 0 OR account_id < 0);
+
+CREATE TABLE journal_entries (
+  entry_id serial PRIMARY KEY,
+  date_time timestamp NOT NULL,
+  comment text NOT NULL
+);
+
+CREATE TABLE postings (
+  posting_id serial PRIMARY KEY,
+  entry_id integer REFERENCES journal_entries (entry_id) NOT NULL,
+  account_id integer REFERENCES accounts (account_id) NOT NULL,
+  amount numeric(20,2) NOT NULL
+  CONSTRAINT posting_amount_cannot_be_zero CHECK (amount <> 0)
+);
+
+ALTER TABLE transactions
+  ADD COLUMN entry_id integer REFERENCES journal_entries (entry_id),
+  ADD COLUMN counterparty_id integer REFERENCES accounts (account_id);
+
+INSERT INTO accounts (account_id, balance) VALUES
+  (-1, 0), -- deposits
+  (-2, 0), -- withdrawals
+  (-3, 0), -- revenue
+  (-4, 0)  -- holds
+ON CONFLICT (account_id) DO NOTHING;
+
+CREATE FUNCTION check_entry_is_balanced() RETURNS trigger AS $$
+BEGIN
+  IF (SELECT sum(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+    RAISE EXCEPTION 'journal entry % postings do not sum to zero', NEW.entry_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER entry_is_balanced
+  AFTER INSERT OR UPDATE ON postings
+  DEFERRABLE INITIALLY DEFERRED
+  FOR EACH ROW EXECUTE FUNCTION check_entry_is_balanced();
+
+-- Opens the ledger with the balances accounts already hold: every non-zero
+-- balance gets an entry bringing it in from the deposits account, so that
+-- balances match the sum of their postings from the start.
+WITH opening AS (
+  SELECT nextval(pg_get_serial_sequence('journal_entries', 'entry_id')) AS entry_id, account_id, balance
+  FROM accounts
+  WHERE account_id > 0 AND balance <> 0
+), entries AS (
+  INSERT INTO journal_entries (entry_id, date_time, comment)
+  SELECT entry_id, now() AT TIME ZONE 'UTC', 'opening balance' FROM opening
+)
+INSERT INTO postings (entry_id, account_id, amount)
+SELECT entry_id, account_id, balance FROM opening
+UNION ALL
+SELECT entry_id, -1, -balance FROM opening;
+
+UPDATE accounts
+SET balance = -(SELECT COALESCE(sum(balance), 0) FROM accounts WHERE account_id > 0)
+WHERE account_id = -1;
@@ -0,0 +1,39 @@
+-- Only RUB fits back into accounts.balance, so reverting is refused once any
+-- other currency has been used.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM postings WHERE currency <> 'RUB')
+     OR EXISTS (SELECT 1 FROM balances WHERE currency <> 'RUB' AND balance <> 0) THEN
+    RAISE EXCEPTION 'cannot revert multi-currency balances: non-RUB money exists';
+  END IF;
+END;
+$$;
+
+ALTER TABLE accounts
+  ADD COLUMN balance numeric(20,2) NOT NULL DEFAULT 0
+  CONSTRAINT balance_cannot_be_negative CHECK (balance >= 0 OR account_id < 0);
+ALTER TABLE accounts ALTER COLUMN balance DROP DEFAULT;
+
+UPDATE accounts a
+SET balance = b.balance
+FROM balances b
+WHERE b.account_id = a.account_id AND b.currency = 'RUB';
+
+DROP TABLE balances;
+
+ALTER TABLE postings DROP COLUMN currency;
+
+ALTER TABLE transactions
+  DROP COLUMN currency,
+  DROP COLUMN rate;
+
+DELETE FROM accounts WHERE account_id = -5;
+
+CREATE OR REPLACE FUNCTION check_entry_is_balanced() RETURNS trigger AS $$
+BEGIN
+  IF (SELECT sum(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+    RAISE EXCEPTION 'journal entry % postings do not sum to zero', NEW.entry_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,34 @@
+-- Moves balances into a per-currency table. Existing balances, postings and
+-- history rows are in RUB.
+CREATE TABLE balances (
+  account_id integer REFERENCES accounts (account_id) NOT NULL,
+  currency char(3) NOT NULL,
+  balance numeric(20,2) NOT NULL DEFAULT 0
+  CONSTRAINT balance_cannot_be_negative CHECK (balance >= 0 OR account_id < 0),
+  PRIMARY KEY (account_id, currency)
+);
+
+INSERT INTO balances (account_id, currency, balance)
+SELECT account_id, 'RUB', balance FROM accounts;
+
+ALTER TABLE accounts DROP COLUMN balance;
+
+ALTER TABLE postings ADD COLUMN currency char(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE postings ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE transactions
+  ADD COLUMN currency char(3) NOT NULL DEFAULT 'RUB',
+  ADD COLUMN rate numeric;
+
+INSERT INTO accounts (account_id) VALUES (-5) -- currency exchange
+ON CONFLICT (account_id) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION check_entry_is_balanced() RETURNS trigger AS $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM postings WHERE entry_id = NEW.entry_id
+             GROUP BY currency HAVING sum(amount) <> 0) THEN
+    RAISE EXCEPTION 'journal entry % postings do not sum to zero', NEW.entry_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS transactions_account_date_idx;
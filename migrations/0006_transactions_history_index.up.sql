@@ -0,0 +1,3 @@
+-- Serves keyset pagination of an account history by (date_time, transaction_id).
+CREATE INDEX IF NOT EXISTS transactions_account_date_idx
+  ON transactions (account_id, date_time, transaction_id);
@@ -0,0 +1,5 @@
+ALTER TABLE accounts
+  DROP COLUMN closed_at,
+  DROP COLUMN frozen_at,
+  DROP COLUMN created_at,
+  DROP COLUMN status;
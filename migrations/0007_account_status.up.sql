@@ -0,0 +1,8 @@
+-- Adds the account lifecycle: active accounts take any movement, frozen ones
+-- only deposits, closed ones nothing. Existing accounts are active.
+ALTER TABLE accounts
+  ADD COLUMN status text NOT NULL DEFAULT 'active'
+    CONSTRAINT account_status_is_known CHECK (status IN ('active', 'frozen', 'closed')),
+  ADD COLUMN created_at timestamp NOT NULL DEFAULT now(),
+  ADD COLUMN frozen_at timestamp,
+  ADD COLUMN closed_at timestamp;
@@ -0,0 +1,10 @@
+-- Clients allowed to call the API. Only a SHA-256 hash of each key's secret
+-- is stored; scopes limit what the client may do.
+CREATE TABLE IF NOT EXISTS api_clients (
+  key_id text PRIMARY KEY,
+  name text NOT NULL,
+  key_hash bytea NOT NULL,
+  scopes text[] NOT NULL,
+  created_at timestamp NOT NULL DEFAULT now(),
+  revoked_at timestamp
+);
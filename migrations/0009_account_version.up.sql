@@ -0,0 +1,4 @@
+-- Every change to an account, its balances or its status increments version,
+-- which clients see as the ETag of the account.
+ALTER TABLE accounts
+  ADD COLUMN version bigint NOT NULL DEFAULT 1;
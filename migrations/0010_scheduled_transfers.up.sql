@@ -0,0 +1,48 @@
+-- Transfers run by the scheduler at start_at and then every period. Times
+-- are UTC. next_run_at is the occurrence due next, NULL once there is none;
+-- retry_at is set while a run that failed for insufficient funds waits to be
+-- tried again, and attempts counts the tries of that run so far.
+CREATE TABLE scheduled_transfers (
+  schedule_id serial PRIMARY KEY,
+  sender_id integer REFERENCES accounts (account_id) NOT NULL,
+  receiver_id integer REFERENCES accounts (account_id) NOT NULL,
+  amount numeric(20,2) NOT NULL
+  CONSTRAINT scheduled_amount_must_be_positive CHECK (amount > 0),
+  currency char(3) NOT NULL,
+  comment text NOT NULL,
+  period text NOT NULL
+  CONSTRAINT schedule_period_is_known CHECK (period IN ('once', 'daily', 'weekly', 'monthly')),
+  start_at timestamp NOT NULL,
+  on_insufficient_funds text NOT NULL DEFAULT 'skip'
+  CONSTRAINT insufficient_funds_policy_is_known CHECK (on_insufficient_funds IN ('skip', 'retry')),
+  status text NOT NULL DEFAULT 'active'
+  CONSTRAINT schedule_status_is_known CHECK (status IN ('active', 'finished', 'cancelled')),
+  next_run_at timestamp,
+  retry_at timestamp,
+  attempts integer NOT NULL DEFAULT 0,
+  created_at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE INDEX scheduled_transfers_due_idx
+  ON scheduled_transfers ((COALESCE(retry_at, next_run_at)))
+  WHERE status = 'active';
+
+CREATE INDEX scheduled_transfers_sender_idx ON scheduled_transfers (sender_id);
+CREATE INDEX scheduled_transfers_receiver_idx ON scheduled_transfers (receiver_id);
+
+-- Every attempt at a scheduled transfer and its outcome.
+CREATE TABLE scheduled_transfer_runs (
+  run_id serial PRIMARY KEY,
+  schedule_id integer REFERENCES scheduled_transfers (schedule_id) NOT NULL,
+  due_at timestamp NOT NULL,
+  ran_at timestamp NOT NULL,
+  attempt integer NOT NULL,
+  amount numeric(20,2) NOT NULL,
+  currency char(3) NOT NULL,
+  status text NOT NULL
+  CONSTRAINT run_status_is_known CHECK (status IN ('succeeded', 'retrying', 'skipped')),
+  error text,
+  entry_id integer REFERENCES journal_entries (entry_id)
+);
+
+CREATE INDEX scheduled_transfer_runs_schedule_idx ON scheduled_transfer_runs (schedule_id, run_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transactions_refund_of_idx;
+ALTER TABLE transactions
+  DROP COLUMN refund_of;
@@ -0,0 +1,7 @@
+-- Links the history rows of a refund to the rows of the transfer they refund,
+-- one for the sender and one for the receiver.
+ALTER TABLE transactions
+  ADD COLUMN refund_of integer REFERENCES transactions (transaction_id);
+
+CREATE INDEX transactions_refund_of_idx
+  ON transactions (refund_of) WHERE refund_of IS NOT NULL;
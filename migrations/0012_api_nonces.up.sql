@@ -0,0 +1,10 @@
+-- Nonces of signed requests, kept until the timestamp they were signed with
+-- is too old to be accepted anyway, so a captured request cannot be replayed.
+CREATE TABLE IF NOT EXISTS api_nonces (
+  key_id text NOT NULL REFERENCES api_clients (key_id),
+  nonce text NOT NULL,
+  expires_at timestamp NOT NULL,
+  PRIMARY KEY (key_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS api_nonces_expires_at_idx ON api_nonces (expires_at);
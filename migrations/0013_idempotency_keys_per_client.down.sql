@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idempotency_keys_expires_at_idx;
+
+-- keys used by several clients keep one of their rows.
+DELETE FROM idempotency_keys a
+  USING idempotency_keys b
+  WHERE a.idempotency_key = b.idempotency_key AND a.key_id > b.key_id;
+
+ALTER TABLE idempotency_keys
+  DROP CONSTRAINT idempotency_keys_pkey,
+  ADD PRIMARY KEY (idempotency_key);
+
+ALTER TABLE idempotency_keys
+  DROP COLUMN key_id;
@@ -0,0 +1,11 @@
+-- Idempotency keys are chosen by clients, so they are unique per client
+-- only: two clients sending the same key must not see each other's
+-- responses. Keys stored before belong to no client.
+ALTER TABLE idempotency_keys
+  ADD COLUMN key_id text NOT NULL DEFAULT '';
+
+ALTER TABLE idempotency_keys
+  DROP CONSTRAINT idempotency_keys_pkey,
+  ADD PRIMARY KEY (key_id, idempotency_key);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS postings_account_id_currency_idx;
+
+INSERT INTO balances (account_id, currency, balance)
+SELECT account_id, currency, sum(amount)
+FROM postings
+WHERE account_id < 0
+GROUP BY account_id, currency;
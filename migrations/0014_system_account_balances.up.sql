@@ -0,0 +1,6 @@
+-- System accounts no longer keep balances rows, every entry touching one of
+-- them used to update the same row. Their totals are summed up from their
+-- postings instead.
+DELETE FROM balances WHERE account_id < 0;
+
+CREATE INDEX IF NOT EXISTS postings_account_id_currency_idx ON postings (account_id, currency);
@@ -0,0 +1,14 @@
+ALTER TABLE scheduled_transfer_runs
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount, 2);
+
+ALTER TABLE scheduled_transfers
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount, 2);
+
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount, 2);
+
+ALTER TABLE postings
+  ALTER COLUMN amount TYPE numeric(20,2) USING round(amount, 2);
+
+ALTER TABLE balances
+  ALTER COLUMN balance TYPE numeric(20,2) USING round(balance, 2);
@@ -0,0 +1,17 @@
+-- Widens the money columns of movements that can be in any currency to four
+-- decimal places, the finest minor unit of an ISO 4217 currency money is
+-- moved in. How many of them an amount may use depends on its currency.
+ALTER TABLE balances
+  ALTER COLUMN balance TYPE numeric(24,4);
+
+ALTER TABLE postings
+  ALTER COLUMN amount TYPE numeric(24,4);
+
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE numeric(24,4);
+
+ALTER TABLE scheduled_transfers
+  ALTER COLUMN amount TYPE numeric(24,4);
+
+ALTER TABLE scheduled_transfer_runs
+  ALTER COLUMN amount TYPE numeric(24,4);
@@ -0,0 +1,64 @@
+package apikey_test
+
+import (
+	"avito-tech/pkg/apikey"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GenerateAndParse(t *testing.T) {
+	key, id, secret, err := apikey.Generate()
+	assert.NoError(t, err)
+	assert.Len(t, id, 16)
+	assert.Len(t, secret, 64)
+
+	parsedID, parsedSecret, err := apikey.Parse(key)
+	assert.NoError(t, err)
+	assert.Equal(t, id, parsedID)
+	assert.Equal(t, secret, parsedSecret)
+
+	other, _, _, err := apikey.Generate()
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	for _, malformed := range []string{"", "abc", ".secret", "id."} {
+		_, _, err = apikey.Parse(malformed)
+		assert.Equal(t, apikey.ErrMalformedKey, err, malformed)
+	}
+}
+
+func Test_SigningSecret(t *testing.T) {
+	secret := apikey.SigningSecret([]byte("server key"), "shop")
+	assert.Len(t, secret, 64)
+	assert.Equal(t, secret, apikey.SigningSecret([]byte("server key"), "shop"))
+	assert.NotEqual(t, secret, apikey.SigningSecret([]byte("server key"), "bank"))
+	assert.NotEqual(t, secret, apikey.SigningSecret([]byte("other key"), "shop"))
+}
+
+func Test_SignAndVerify(t *testing.T) {
+	signingSecret := apikey.SigningSecret([]byte("server key"), "shop")
+	body := []byte(`{"amount": 10}`)
+	signature := apikey.Sign(signingSecret, "POST", "/transaction", "1667296800", "n-1", body)
+
+	assert.True(t, apikey.Verify(signingSecret, "POST", "/transaction", "1667296800", "n-1", body, signature))
+
+	testTable := []struct {
+		name                            string
+		secret                          string
+		method, uri, ts, nonce, payload string
+	}{
+		{"other secret", apikey.SigningSecret([]byte("server key"), "bank"), "POST", "/transaction", "1667296800", "n-1", string(body)},
+		{"stored hash", string(apikey.Hash("secret")), "POST", "/transaction", "1667296800", "n-1", string(body)},
+		{"other method", signingSecret, "GET", "/transaction", "1667296800", "n-1", string(body)},
+		{"other uri", signingSecret, "POST", "/transaction?x=1", "1667296800", "n-1", string(body)},
+		{"other timestamp", signingSecret, "POST", "/transaction", "1667296801", "n-1", string(body)},
+		{"other nonce", signingSecret, "POST", "/transaction", "1667296800", "n-2", string(body)},
+		{"other body", signingSecret, "POST", "/transaction", "1667296800", "n-1", `{"amount": 1000}`},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.False(t, apikey.Verify(tt.secret, tt.method, tt.uri, tt.ts, tt.nonce, []byte(tt.payload), signature))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	APILayerURL = "http://api.apilayer.com/exchangerates_data"
+
+	apiLayerLatest = "%s/latest?base=%s&symbols=%s"
+)
+
+type apiLayerResponse struct {
+	Success bool               `json:"success"`
+	Rates   map[string]float64 `json:"rates"`
+}
+
+type apiLayerProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewAPILayerProvider returns a provider backed by the apilayer exchange
+// rates API. One HTTP client is shared by all requests.
+func NewAPILayerProvider(baseURL, apiKey string, timeout time.Duration) RateProvider {
+	return &apiLayerProvider{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (p *apiLayerProvider) Rate(ctx context.Context, from, to string) (rate float64, err error) {
+	if from == to {
+		return 1, nil
+	}
+
+	link := fmt.Sprintf(apiLayerLatest, p.baseURL, url.QueryEscape(from), url.QueryEscape(to))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("apikey", p.apiKey)
+
+	resp, err := p.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrRateUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusBadRequest {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, b)
+		}
+		return 0, fmt.Errorf("%w: upstream returned %d: %s", ErrRateUnavailable, resp.StatusCode, b)
+	}
+
+	body := &apiLayerResponse{}
+	err = json.NewDecoder(resp.Body).Decode(body)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrRateUnavailable, err)
+	}
+
+	rate, ok := body.Rates[to]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type cachedRate struct {
+	rate      float64
+	fetchedAt time.Time
+}
+
+type cachingProvider struct {
+	next RateProvider
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	rates map[[2]string]cachedRate
+}
+
+// NewCachingProvider caches rates from next for ttl. When next fails with
+// ErrRateUnavailable the last known rate is returned, however old it is, so
+// conversions keep working while the upstream is down.
+func NewCachingProvider(next RateProvider, ttl time.Duration) RateProvider {
+	return &cachingProvider{
+		next:  next,
+		ttl:   ttl,
+		now:   time.Now,
+		rates: map[[2]string]cachedRate{},
+	}
+}
+
+func (p *cachingProvider) Rate(ctx context.Context, from, to string) (rate float64, err error) {
+	key := [2]string{from, to}
+
+	p.mu.Lock()
+	cached, ok := p.rates[key]
+	p.mu.Unlock()
+
+	if ok && p.now().Sub(cached.fetchedAt) < p.ttl {
+		return cached.rate, nil
+	}
+
+	rate, err = p.next.Rate(ctx, from, to)
+	if err != nil {
+		if ok && errors.Is(err, ErrRateUnavailable) {
+			return cached.rate, nil
+		}
+		return 0, err
+	}
+
+	p.mu.Lock()
+	p.rates[key] = cachedRate{rate: rate, fetchedAt: p.now()}
+	p.mu.Unlock()
+
+	return rate, nil
+}
+
+type fallbackProvider struct {
+	providers []RateProvider
+}
+
+// NewFallbackProvider asks each provider in turn until one of them has the
+// rate available.
+func NewFallbackProvider(providers ...RateProvider) RateProvider {
+	return &fallbackProvider{
+		providers: providers,
+	}
+}
+
+func (p *fallbackProvider) Rate(ctx context.Context, from, to string) (rate float64, err error) {
+	err = ErrRateUnavailable
+	for _, provider := range p.providers {
+		rate, err = provider.Rate(ctx, from, to)
+		if err == nil || !errors.Is(err, ErrRateUnavailable) {
+			return rate, err
+		}
+	}
+	return 0, err
+}
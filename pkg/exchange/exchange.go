@@ -0,0 +1,34 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	ErrRateUnavailable = errors.New("exchange rate is unavailable")
+)
+
+// RateProvider returns how many units of currency "to" one unit of currency
+// "from" is worth. Currencies are ISO 4217 codes. Providers asking an
+// upstream give up when ctx is done.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (rate float64, err error)
+}
+
+// NormalizeCurrency upper-cases a currency code and checks that it looks like
+// an ISO 4217 code.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", ErrUnknownCurrency
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", ErrUnknownCurrency
+		}
+	}
+	return code, nil
+}
@@ -0,0 +1,95 @@
+package exchange_test
+
+import (
+	"avito-tech/pkg/exchange"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_APILayerProvider(t *testing.T) {
+	up := true
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !up {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		assert.Equal(t, "key", r.Header.Get("apikey"))
+		assert.Equal(t, "/latest", r.URL.Path)
+		assert.Equal(t, "RUB", r.URL.Query().Get("base"))
+		w.Write([]byte(`{"success": true, "rates": {"USD": 0.016}}`))
+	}))
+	defer srv.Close()
+
+	api := exchange.NewAPILayerProvider(srv.URL, "key", time.Second)
+	cached := exchange.NewCachingProvider(api, time.Nanosecond)
+
+	rate, err := cached.Rate(context.Background(), "RUB", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.016, rate)
+
+	up = false
+	_, err = api.Rate(context.Background(), "RUB", "USD")
+	assert.ErrorIs(t, err, exchange.ErrRateUnavailable)
+
+	// the cached rate has expired, but it is still served while upstream is down
+	rate, err = cached.Rate(context.Background(), "RUB", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.016, rate)
+	assert.Equal(t, 3, calls)
+
+	_, err = cached.Rate(context.Background(), "RUB", "EUR")
+	assert.ErrorIs(t, err, exchange.ErrRateUnavailable)
+}
+
+func Test_APILayerProviderGivesUpWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	api := exchange.NewAPILayerProvider(srv.URL, "key", time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := api.Rate(ctx, "RUB", "USD")
+	assert.ErrorIs(t, err, exchange.ErrRateUnavailable)
+}
+
+func Test_CachingProvider(t *testing.T) {
+	calls := 0
+	next := exchange.NewFallbackProvider(countingProvider{&calls, exchange.NewStaticProvider(map[string]map[string]float64{
+		"RUB": {"USD": 0.016},
+	})})
+	cached := exchange.NewCachingProvider(next, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		rate, err := cached.Rate(context.Background(), "RUB", "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, 0.016, rate)
+	}
+	assert.Equal(t, 1, calls)
+
+	rate, err := cached.Rate(context.Background(), "USD", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, 62.5, rate)
+
+	_, err = cached.Rate(context.Background(), "RUB", "EUR")
+	assert.ErrorIs(t, err, exchange.ErrUnknownCurrency)
+}
+
+type countingProvider struct {
+	calls *int
+	next  exchange.RateProvider
+}
+
+func (p countingProvider) Rate(ctx context.Context, from, to string) (float64, error) {
+	*p.calls++
+	return p.next.Rate(ctx, from, to)
+}
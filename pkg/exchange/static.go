@@ -0,0 +1,49 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+)
+
+type staticProvider struct {
+	rates map[string]map[string]float64
+}
+
+// NewStaticProvider returns a provider with fixed rates, keyed by base
+// currency and then by quote currency. Inverse rates are derived.
+func NewStaticProvider(rates map[string]map[string]float64) RateProvider {
+	return &staticProvider{
+		rates: rates,
+	}
+}
+
+// NewFileProvider loads fixed rates from a JSON file such as
+// {"RUB": {"USD": 0.016, "EUR": 0.015}}.
+func NewFileProvider(path string) (RateProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := map[string]map[string]float64{}
+	err = json.Unmarshal(b, &rates)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStaticProvider(rates), nil
+}
+
+func (p *staticProvider) Rate(ctx context.Context, from, to string) (rate float64, err error) {
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := p.rates[from][to]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.rates[to][from]; ok && rate != 0 {
+		return 1 / rate, nil
+	}
+	return 0, ErrUnknownCurrency
+}
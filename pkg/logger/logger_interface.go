@@ -1,5 +1,10 @@
 package logger
 
+import "context"
+
+// Fields are key/value pairs added to every line a logger writes.
+type Fields map[string]interface{}
+
 type Logger interface {
 	Errorf(format string, args ...interface{})
 	Fatalf(format string, args ...interface{})
@@ -10,4 +15,30 @@ type Logger interface {
 	Debugf(format string, args ...interface{})
 	Debug(args ...interface{})
 	Panicf(format string, args ...interface{})
+	// WithFields returns a logger that adds fields to every line.
+	WithFields(fields Fields) Logger
+	// WithContext returns a logger that adds the fields put in ctx by
+	// NewContext, such as the id of the request ctx belongs to.
+	WithContext(ctx context.Context) Logger
+}
+
+type fieldsKey struct{}
+
+// NewContext returns a copy of ctx carrying fields in addition to those ctx
+// already carries, for loggers to pick up through WithContext.
+func NewContext(ctx context.Context, fields Fields) context.Context {
+	merged := Fields{}
+	for key, value := range FieldsFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the fields put in ctx by NewContext.
+func FieldsFromContext(ctx context.Context) Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(Fields)
+	return fields
 }
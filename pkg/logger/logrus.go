@@ -1,22 +1,72 @@
 package logger
 
 import (
-	"github.com/sirupsen/logrus"
+	"context"
+	"fmt"
+	"io"
 	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Log formats.
+const (
+	FormatPretty = "pretty" // indented JSON
+	FormatJSON   = "json"   // one JSON object per line
+	FormatText   = "text"   // key=value
 )
 
+type Config struct {
+	Level  string    // logrus level name, debug by default
+	Format string    // one of the formats above, pretty by default
+	Output io.Writer // stdout by default
+}
+
 type Logg struct {
 	*logrus.Entry
 }
 
+// GetLogger returns a debug logger writing pretty JSON to stdout.
 func GetLogger() *Logg {
+	l, _ := New(Config{})
+	return l
+}
+
+func New(config Config) (*Logg, error) {
 	l := logrus.New()
 	l.SetReportCaller(true)
-	l.Formatter = &logrus.JSONFormatter{
-		PrettyPrint: true,
+
+	switch config.Format {
+	case "", FormatPretty:
+		l.Formatter = &logrus.JSONFormatter{PrettyPrint: true}
+	case FormatJSON:
+		l.Formatter = &logrus.JSONFormatter{}
+	case FormatText:
+		l.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+
+	level := logrus.DebugLevel
+	if config.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(config.Level); err != nil {
+			return nil, err
+		}
+	}
+	l.SetLevel(level)
+
+	if config.Output == nil {
+		config.Output = os.Stdout
 	}
+	l.SetOutput(config.Output)
+	return &Logg{logrus.NewEntry(l)}, nil
+}
+
+func (l *Logg) WithFields(fields Fields) Logger {
+	return &Logg{l.Entry.WithFields(logrus.Fields(fields))}
+}
 
-	l.SetOutput(os.Stdout)
-	l.SetLevel(logrus.DebugLevel)
-	return &Logg{logrus.NewEntry(l)}
+func (l *Logg) WithContext(ctx context.Context) Logger {
+	return &Logg{l.Entry.WithContext(ctx).WithFields(logrus.Fields(FieldsFromContext(ctx)))}
 }
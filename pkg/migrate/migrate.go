@@ -0,0 +1,292 @@
+package migrate
+
+import (
+	"avito-tech/pkg/logger"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lockID is the postgres advisory lock key held while migrating, so that
+// several instances starting at once apply each migration exactly once.
+const lockID int64 = 7203941
+
+var (
+	ErrNoMigrations   = errors.New("no migrations applied")
+	ErrUnknownVersion = errors.New("applied migration is unknown to this build")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	logger     logger.Logger
+	migrations []Migration
+}
+
+// New reads migrations from fsys. Every version needs a
+// <version>_<name>.up.sql and a matching .down.sql file.
+func New(db *sql.DB, logger logger.Logger, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		logger:     logger,
+		migrations: migrations,
+	}, nil
+}
+
+func load(fsys fs.FS) (migrations []Migration, err error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every migration that has not been applied yet, in version
+// order, and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (applied int, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			m.logger.Infof("applying migration %d_%s", migration.Version, migration.Name)
+			err = inTransaction(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (reverted *Migration, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn) error {
+		var version int64
+		err := conn.QueryRowContext(ctx, `SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1`).
+			Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoMigrations
+		}
+		if err != nil {
+			return err
+		}
+
+		for i := range m.migrations {
+			if m.migrations[i].Version == version {
+				reverted = &m.migrations[i]
+			}
+		}
+		if reverted == nil {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+
+		m.logger.Infof("reverting migration %d_%s", reverted.Version, reverted.Name)
+		err = inTransaction(ctx, conn, reverted.Down,
+			`DELETE FROM schema_migrations WHERE version = $1`, reverted.Version)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", reverted.Version, reverted.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
+
+// Status lists every known migration with the time it was applied, or nil
+// when it is pending.
+func (m *Migrator) Status(ctx context.Context) (statuses []MigrationStatus, err error) {
+	err = m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if appliedAt, ok := done[migration.Version]; ok {
+				appliedAt := appliedAt
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Version returns the latest applied migration version, 0 when none is, and
+// the latest version this build knows. Unlike Status it does not wait for
+// migrations running elsewhere, so it is cheap enough for health checks.
+func (m *Migrator) Version(ctx context.Context) (current, latest int64, err error) {
+	if len(m.migrations) > 0 {
+		latest = m.migrations[len(m.migrations)-1].Version
+	}
+
+	err = m.db.QueryRowContext(ctx, `SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return 0, latest, err
+	}
+
+	return current, latest, nil
+}
+
+// locked runs fn on a single connection holding the migration advisory lock.
+// The schema_migrations table is created first if it does not exist.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the lock belongs to the session, so it must be released even when
+		// ctx is already cancelled.
+		_, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+		if unlockErr != nil {
+			m.logger.Errorf("error occurred while releasing migration lock. err: %s", unlockErr)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (done map[int64]time.Time, err error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done = map[int64]time.Time{}
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+
+	return done, rows.Err()
+}
+
+// inTransaction runs a migration script and the statement recording it in
+// schema_migrations in one transaction.
+func inTransaction(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,223 @@
+package migrate_test
+
+import (
+	"avito-tech/migrations"
+	"avito-tech/pkg/logger"
+	"avito-tech/pkg/migrate"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var log = logger.GetLogger()
+
+var testMigrations = fstest.MapFS{
+	"0001_accounts.up.sql":   {Data: []byte("CREATE TABLE accounts (account_id serial PRIMARY KEY);")},
+	"0001_accounts.down.sql": {Data: []byte("DROP TABLE accounts;")},
+	"0002_balances.up.sql":   {Data: []byte("CREATE TABLE balances (account_id integer);")},
+	"0002_balances.down.sql": {Data: []byte("DROP TABLE balances;")},
+	"README.md":              {Data: []byte("not a migration")},
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectApplied(mock sqlmock.Sqlmock, versions ...int64) {
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range versions {
+		rows.AddRow(v, time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, applied_at FROM schema_migrations")).WillReturnRows(rows)
+}
+
+func TestNew(t *testing.T) {
+	testTable := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr bool
+	}{
+		{
+			name: "OK",
+			fsys: testMigrations,
+		},
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{
+				"0001_accounts.up.sql": {Data: []byte("CREATE TABLE accounts ();")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "one version with two names",
+			fsys: fstest.MapFS{
+				"0001_accounts.up.sql":   {Data: []byte("CREATE TABLE accounts ();")},
+				"0001_balances.down.sql": {Data: []byte("DROP TABLE balances;")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrate.New(nil, log, tt.fsys)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := migrate.New(db, log, testMigrations)
+	require.NoError(t, err)
+
+	t.Run("applies pending migrations in order", func(t *testing.T) {
+		expectLock(mock)
+		expectApplied(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE balances (account_id integer);")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)")).
+			WithArgs(2, "balances", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlock(mock)
+
+		applied, err := m.Up(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		errSQL := errors.New("syntax error")
+		expectLock(mock)
+		expectApplied(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE accounts (account_id serial PRIMARY KEY);")).WillReturnError(errSQL)
+		mock.ExpectRollback()
+		expectUnlock(mock)
+
+		applied, err := m.Up(context.Background())
+		assert.ErrorIs(t, err, errSQL)
+		assert.Equal(t, 0, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing to apply", func(t *testing.T) {
+		expectLock(mock)
+		expectApplied(mock, 1, 2)
+		expectUnlock(mock)
+
+		applied, err := m.Up(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := migrate.New(db, log, testMigrations)
+	require.NoError(t, err)
+
+	latest := regexp.QuoteMeta("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1")
+
+	t.Run("reverts the latest migration", func(t *testing.T) {
+		expectLock(mock)
+		mock.ExpectQuery(latest).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DROP TABLE balances;")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = $1")).
+			WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlock(mock)
+
+		reverted, err := m.Down(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), reverted.Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing applied", func(t *testing.T) {
+		expectLock(mock)
+		mock.ExpectQuery(latest).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		expectUnlock(mock)
+
+		_, err := m.Down(context.Background())
+		assert.Equal(t, migrate.ErrNoMigrations, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		expectLock(mock)
+		mock.ExpectQuery(latest).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(9))
+		expectUnlock(mock)
+
+		_, err := m.Down(context.Background())
+		assert.ErrorIs(t, err, migrate.ErrUnknownVersion)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := migrate.New(db, log, migrations.FS)
+	require.NoError(t, err)
+
+	expectLock(mock)
+	expectApplied(mock, 1)
+	expectUnlock(mock)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	assert.Equal(t, "initial_schema", statuses[0].Name)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	for i, status := range statuses[1:] {
+		assert.Equal(t, statuses[i].Version+1, status.Version)
+		assert.Nil(t, status.AppliedAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Version(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := migrate.New(db, log, testMigrations)
+	require.NoError(t, err)
+	query := regexp.QuoteMeta("SELECT COALESCE(max(version), 0) FROM schema_migrations")
+
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	current, latest, err := m.Version(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), current)
+	assert.Equal(t, int64(2), latest)
+
+	mock.ExpectQuery(query).WillReturnError(errors.New("connection refused"))
+	_, latest, err = m.Version(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), latest)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,6 +3,7 @@ package postgres
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"avito-tech/pkg/logger"
 
@@ -16,7 +17,12 @@ type PostgresDB struct {
 	Password string
 	DBName   string
 	SSLMode  string
-	Logger   logger.Logger
+	// Pool limits, left to database/sql when zero.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	Logger          logger.Logger
 }
 
 func NewPostgresDB(database *PostgresDB) (*sql.DB, error) {
@@ -31,6 +37,12 @@ func NewPostgresDB(database *PostgresDB) (*sql.DB, error) {
 		database.Logger.Panicf("Database open error:%s", err)
 		return nil, err
 	}
+	db.SetMaxOpenConns(database.MaxOpenConns)
+	if database.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(database.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(database.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(database.ConnMaxIdleTime)
 	err = db.Ping()
 	if err != nil {
 		database.Logger.Errorf("DB ping error:%s", err)
@@ -1,30 +1,38 @@
 package server
 
 import (
+	"avito-tech/internal/config"
 	"avito-tech/internal/handler"
 	"avito-tech/pkg/logger"
 	"context"
+	"net"
 	"net/http"
-	"time"
 )
 
 type Server struct {
 	Logger     logger.Logger
 	httpServer *http.Server
+	cancel     context.CancelFunc
 }
 
-func NewServer(logger logger.Logger, handler handler.Handler, host, port string) *Server {
+func NewServer(logger logger.Logger, handler handler.Handler, config config.Server) *Server {
+	// every request context derives from baseCtx, so cancelling it aborts the
+	// SQL of requests still running when shutdown gives up waiting.
+	baseCtx, cancel := context.WithCancel(context.Background())
 	srv := &http.Server{
-		Addr:           host + ":" + port,
+		Addr:           config.Host + ":" + config.Port,
 		Handler:        handler.InitRoutes(),
 		MaxHeaderBytes: 1 << 20, //1 Mb
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		IdleTimeout:    config.IdleTimeout,
+		BaseContext:    func(net.Listener) context.Context { return baseCtx },
 	}
 
 	return &Server{
 		Logger:     logger,
 		httpServer: srv,
+		cancel:     cancel,
 	}
 }
 
@@ -38,6 +46,9 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// Shutdown stops accepting connections and waits for in-flight requests until
+// ctx is done, then cancels the requests that are still running.
 func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.cancel()
 	return s.httpServer.Shutdown(ctx)
 }